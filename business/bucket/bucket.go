@@ -0,0 +1,155 @@
+// 功能: 封装restfult api - s3 存储桶模块
+package bucket
+
+import (
+	"errors"
+	"study-aws-api-go/business/mys3"
+	"study-aws-api-go/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 变量
+var s3Basic mys3.BucketBasics // main.go 初始化好的 s3 客户端
+var corsOrigins []string      // 配置文件里允许的跨域来源, 设置跨域规则没传 origins 时用
+
+// 初始化, main.go 创建好 s3 客户端后调用
+func InitBucket(basics mys3.BucketBasics, allowedOrigins []string) {
+	s3Basic = basics
+	corsOrigins = allowedOrigins
+}
+
+// 查 - 生命周期规则
+/*
+返回: json对象
+{
+	"rules": [{"id": "tmp-expire", "enabled": true, "prefix": "tmp/", "expirationDays": 7}]
+}
+*/
+func BucketLifecycleQuery(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	log.Debug("查询生命周期规则, 存储桶= ", bucketName)
+	rules, err := s3Basic.BucketLifecycleGet(c.Request.Context(), bucketName)
+	if err != nil {
+		log.Error("查询生命周期规则失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"rules": rules})
+}
+
+// 改 - 生命周期规则, 整个替换
+/*
+请求: json对象
+{
+	"rules": [
+		{"id": "tmp-expire", "enabled": true, "prefix": "tmp/", "expirationDays": 7, "abortIncompleteMultipartDays": 1},
+		{"id": "receipt-archive", "enabled": true, "prefix": "receipts/", "transitions": [{"days": 90, "storageClass": "GLACIER"}]},
+		{"id": "delete-marker", "enabled": true, "expiredObjectDeleteMarker": true, "noncurrentExpirationDays": 30, "noncurrentNewerVersions": 3}
+	]
+}
+返回: 规则不对 400, 其他错误 500
+*/
+func BucketLifecycleUpdate(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	log.Debug("修改生命周期规则, 存储桶= ", bucketName)
+	var req struct {
+		Rules []mys3.LifecycleRule `json:"rules" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Error("解析请求体失败, err: ", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return // 必须保留 return，确保绑定失败时提前退出
+	}
+	err := s3Basic.BucketLifecyclePut(c.Request.Context(), bucketName, req.Rules)
+	if errors.Is(err, mys3.ErrInvalidLifecycleRule) { // 规则不对是请求的问题
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error("修改生命周期规则失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, "修改成功")
+}
+
+// 删 - 生命周期规则, 全部删除
+func BucketLifecycleDelete(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	log.Debug("删除生命周期规则, 存储桶= ", bucketName)
+	if err := s3Basic.BucketLifecycleDelete(c.Request.Context(), bucketName); err != nil {
+		log.Error("删除生命周期规则失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, "删除成功")
+}
+
+// 查 - 跨域规则
+/*
+返回: json对象
+{
+	"rules": [{"AllowedOrigins": ["https://www.example.com"], "AllowedMethods": ["PUT", "POST"], ...}]
+}
+*/
+func BucketCorsQuery(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	log.Debug("查询跨域规则, 存储桶= ", bucketName)
+	rules, err := s3Basic.BucketCorsGet(c.Request.Context(), bucketName)
+	if err != nil {
+		log.Error("查询跨域规则失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"rules": rules})
+}
+
+// 改 - 跨域规则, 整个替换, 按允许的来源生成
+/*
+请求: json对象, 可以不传, 不传用配置文件的 aws_s3.cors_allowed_origins
+{
+	"origins": ["https://www.example.com"]
+}
+*/
+func BucketCorsUpdate(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	log.Debug("修改跨域规则, 存储桶= ", bucketName)
+	var req struct {
+		Origins []string `json:"origins"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Error("解析请求体失败, err: ", err)
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if len(req.Origins) == 0 {
+		req.Origins = corsOrigins
+	}
+	rules, err := mys3.CorsRulesFromOrigins(req.Origins)
+	if err != nil {
+		log.Error("生成跨域规则失败, err: ", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s3Basic.BucketCorsPut(c.Request.Context(), bucketName, rules); err != nil {
+		log.Error("修改跨域规则失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, "修改成功")
+}
+
+// 删 - 跨域规则, 全部删除
+func BucketCorsDelete(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	log.Debug("删除跨域规则, 存储桶= ", bucketName)
+	if err := s3Basic.BucketCorsDelete(c.Request.Context(), bucketName); err != nil {
+		log.Error("删除跨域规则失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, "删除成功")
+}
@@ -0,0 +1,30 @@
+package bucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"study-aws-api-go/business/mys3"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBucketLifecycleUpdateInvalidRule(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	InitBucket(mys3.BucketBasics{}, nil) // 规则校验不过不会发请求给 s3
+	r := gin.New()
+	r.PUT("/buckets/:bucket/lifecycle", BucketLifecycleUpdate)
+
+	for _, body := range []string{
+		`{"rules": []}`,
+		`{"rules": [{"id": "no-action", "enabled": true}]}`,
+		`{"rules": [{"id": "both", "enabled": true, "expirationDays": 1, "expiredObjectDeleteMarker": true}]}`,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/buckets/sexcomic/lifecycle", strings.NewReader(body)))
+		if w.Code != 400 {
+			t.Errorf("%s: 规则不对应该 400, status= %d, body= %s", body, w.Code, w.Body)
+		}
+	}
+}
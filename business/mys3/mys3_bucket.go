@@ -15,11 +15,17 @@ import (
 
 // 变量
 
+// 创建存储桶的选项, 不传就是 s3 默认设置
+type BucketAddOptions struct {
+	Private bool // 私有存储桶: 禁用ACL(BucketOwnerEnforced) + 全部阻止公共访问, 任一步失败会删掉刚建的桶
+}
+
 // 增
 // 参数:
 // - ctx context.Contex
 // - bucketName string        桶名称
-// - region string           桶区域
+// - region string           桶区域, 为空用默认区域 (Registry 的默认区域, 没有 Registry 用 S3Client 的区域)
+// - opts ...BucketAddOptions 可选, 如 BucketAddOptions{Private: true}
 // 返回值:
 // - error
 // func (basics BucketBasics) BucketQuery(ctx context.Context, s3Client *s3.Client) error { // 这种写法不够灵活
@@ -27,14 +33,35 @@ import (
 // 1. 创建存储桶
 // 2. 判断错误类型
 // 3. 等待一段时间，看存储桶是否创建成功，并可用
-func (basics BucketBasics) BucketAdd(ctx context.Context, bucketName string, region string) error {
+// 4. 私有存储桶, 设置阻止公共访问, 失败就删桶回滚, 保证不会留下一个半公开的桶
+func (basics BucketBasics) BucketAdd(ctx context.Context, bucketName string, region string, opts ...BucketAddOptions) error {
+	var opt BucketAddOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
 	// 1. 创建存储桶
-	_, err := basics.S3Client.CreateBucket(ctx, &s3.CreateBucketInput{
+	if region == "" {
+		region = basics.defaultRegion()
+	}
+	input := &s3.CreateBucketInput{
 		Bucket: aws.String(bucketName),
 		CreateBucketConfiguration: &types.CreateBucketConfiguration{ // 创建桶的区域
 			LocationConstraint: types.BucketLocationConstraint(region), // 把string 转成指定类型
 		},
-	})
+	}
+	if region == "us-east-1" {
+		input.CreateBucketConfiguration = nil // us-east-1 不能填 LocationConstraint, 填了会报 InvalidLocationConstraint
+	}
+	if opt.Private {
+		input.ObjectOwnership = types.ObjectOwnershipBucketOwnerEnforced // 创建时就禁用ACL, 对象都归桶拥有者
+	}
+	client, err := basics.regionClient(region) // 用桶所在区域的客户端建
+	if err != nil {
+		log.Errorf("创建存储桶 %s 失败, 没有区域 %s 的客户端, err= %v", bucketName, region, err)
+		return err
+	}
+	_, err = client.CreateBucket(ctx, input)
 
 	// 2. 判断错误类型
 	if err != nil {
@@ -47,15 +74,31 @@ func (basics BucketBasics) BucketAdd(ctx context.Context, bucketName string, reg
 		}
 		return err
 	}
+	if basics.Registry != nil {
+		basics.Registry.BucketRegionSet(basics.Account, bucketName, region) // 记下实际建桶的区域, 后面的操作不用再查
+	}
 
 	// 3. 等待一段时间-写死1分钟，看存储桶是否创建成功，并可用
 	log.Info("等待存储桶可用。Wait bucket can use.")
-	err = s3.NewBucketExistsWaiter(basics.S3Client).Wait(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)}, time.Minute)
+	err = s3.NewBucketExistsWaiter(client).Wait(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)}, time.Minute)
 	if err != nil {
 		log.Errorf("等待存储桶 %s 可用, 失败。Wait bucket can use failed.", bucketName)
 		return err
 	}
 
+	// 4. 私有存储桶, 设置阻止公共访问, 失败就删桶回滚
+	if opt.Private {
+		if err = basics.BucketPublicAccessBlockPut(ctx, bucketName, privatePublicAccessBlock); err != nil {
+			log.Errorf("存储桶 %s 设置私有失败, 删除刚创建的存储桶", bucketName)
+			if _, delErr := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)}); delErr != nil {
+				log.Errorf("回滚删除存储桶 %s 失败, 请手动删除, err= %v", bucketName, delErr)
+			} else if basics.Registry != nil {
+				basics.Registry.BucketRegionForget(basics.Account, bucketName)
+			}
+			return err
+		}
+	}
+
 	// 说明创建成功
 	log.Infof("创建存储桶成功。Bucket %s created successfully.", bucketName)
 	return nil
@@ -72,9 +115,11 @@ func (basics BucketBasics) BucketAdd(ctx context.Context, bucketName string, reg
 // 1. 删除错误
 // 2. 判断错误
 // 3. 等待bucket 真被删除 (s3 上没有这个bucket)
+// 只能删空的存储桶, 非空的用 BucketEmptyAndDelete (mys3_purge.go)
 func (basics BucketBasics) BucketDelete(ctx context.Context, bucketName string) error {
 	// 1. 删除错误
-	_, err := basics.S3Client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+	client := basics.clientFor(ctx, bucketName)
+	_, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
 	// 2. 判断错误
 	if err != nil {
 		var noBucket *types.NoSuchBucket
@@ -86,9 +131,12 @@ func (basics BucketBasics) BucketDelete(ctx context.Context, bucketName string)
 		}
 		return err
 	}
+	if basics.Registry != nil {
+		basics.Registry.BucketRegionForget(basics.Account, bucketName) // 同名的桶可能在别的区域重建
+	}
 
 	// 3. 等待bucket 真被删除 (s3 上没有这个bucket)
-	err = s3.NewBucketNotExistsWaiter(basics.S3Client).Wait(
+	err = s3.NewBucketNotExistsWaiter(client).Wait(
 		ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)}, time.Minute)
 	if err != nil {
 		log.Errorf("等待。。。 存储桶 %s 确实不存在, 失败。Wait bucket deleted failed.", bucketName)
@@ -149,7 +197,7 @@ func (basics BucketBasics) BucketExists(ctx context.Context, bucketName string)
 	exists := true // 默认存在
 
 	// 2. 判断
-	_, err := basics.S3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)})
+	_, err := basics.clientFor(ctx, bucketName).HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)})
 
 	// 3. 处理错误
 	if err != nil {
@@ -0,0 +1,337 @@
+// 功能: 端到端校验, 上传/下载时边传边算校验值, 和 s3 保存的校验值比对, 不一致返回 *IntegrityError
+/*
+s3 的校验值有两种:
+	1. 整个对象的, 单次上传(PutObject) 的对象: base64(SHA256/CRC32C), ETag 是 hex(MD5) (SSE-KMS/SSE-C 加密的除外)
+	2. 组合的, 分段上传的对象: 先算每段的, 再对所有段的校验值拼起来再算一次, 后面带 "-段数"
+	   如 SHA256 "xxxx-3", ETag "hex(md5(md5(段1)+md5(段2)+md5(段3)))-3"
+	   算组合校验值要知道分段大小, 上传时是我们自己定的; 下载时用 HEAD partNumber=1 查第1段大小 (除最后一段外每段一样大)
+*/
+package mys3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"study-aws-api-go/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 校验方式
+type ChecksumMode string
+
+const (
+	ChecksumModeNone   ChecksumMode = ""       // 不校验
+	ChecksumModeCRC32C ChecksumMode = "CRC32C" // CRC32C, 快, 上传时要用 ChecksumAlgorithmCrc32c
+	ChecksumModeSHA256 ChecksumMode = "SHA256" // SHA256, 上传时要用 ChecksumAlgorithmSha256 (默认)
+	ChecksumModeMD5    ChecksumMode = "MD5"    // 和 ETag 比, SSE-KMS/SSE-C 加密的对象 ETag 不是 MD5, 不能用
+)
+
+// 校验失败错误, 用 errors.As 判断
+type IntegrityError struct {
+	Bucket   string       // 存储桶
+	Key      string       // 对象key
+	Mode     ChecksumMode // 校验方式
+	Expected string       // s3 上保存的校验值
+	Actual   string       // 本地算出来的校验值
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("校验失败 %s:%s, %s 期望 %s, 实际 %s", e.Bucket, e.Key, e.Mode, e.Expected, e.Actual)
+}
+
+// 检查校验方式是否支持
+func (mode ChecksumMode) validate() error {
+	switch mode {
+	case ChecksumModeNone, ChecksumModeCRC32C, ChecksumModeSHA256, ChecksumModeMD5:
+		return nil
+	}
+	return fmt.Errorf("不支持的校验方式 %s", mode)
+}
+
+// 校验方式对应的上传校验算法, MD5 不用 s3 的附加校验算法
+func (mode ChecksumMode) algorithm() types.ChecksumAlgorithm {
+	switch mode {
+	case ChecksumModeCRC32C:
+		return types.ChecksumAlgorithmCrc32c
+	case ChecksumModeSHA256:
+		return types.ChecksumAlgorithmSha256
+	}
+	return ""
+}
+
+// 从 s3 返回的校验值里挑出对应校验方式的, ETag 去掉引号
+func (mode ChecksumMode) expected(etag, crc32c, sha256 *string) string {
+	switch mode {
+	case ChecksumModeCRC32C:
+		return aws.ToString(crc32c)
+	case ChecksumModeSHA256:
+		return aws.ToString(sha256)
+	case ChecksumModeMD5:
+		return strings.Trim(aws.ToString(etag), `"`)
+	}
+	return ""
+}
+
+func (mode ChecksumMode) newHash() hash.Hash {
+	switch mode {
+	case ChecksumModeCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case ChecksumModeSHA256:
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+// 编码: MD5(ETag) 是 hex, 其他是 base64
+func (mode ChecksumMode) encode(sum []byte) string {
+	if mode == ChecksumModeMD5 {
+		return hex.EncodeToString(sum)
+	}
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// 边写边算校验值, 同时算整个对象的和每一段的, 最后按 s3 的格式(整个/组合)给出结果
+type checksumHasher struct {
+	mode     ChecksumMode
+	partSize int64 // 分段大小, <=0 只算整个对象的
+
+	full        hash.Hash
+	part        hash.Hash
+	partWritten int64
+	partSums    []byte // 每段校验值(原始字节)拼在一起
+	parts       int
+}
+
+func newChecksumHasher(mode ChecksumMode, partSize int64) *checksumHasher {
+	h := &checksumHasher{mode: mode, partSize: partSize, full: mode.newHash()}
+	if partSize > 0 {
+		h.part = mode.newHash()
+	}
+	return h
+}
+
+func (h *checksumHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.full.Write(p)
+	for h.part != nil && len(p) > 0 {
+		chunk := min(int64(len(p)), h.partSize-h.partWritten)
+		h.part.Write(p[:chunk])
+		h.partWritten += chunk
+		p = p[chunk:]
+		if h.partWritten == h.partSize {
+			h.finishPart()
+		}
+	}
+	return n, nil
+}
+
+func (h *checksumHasher) finishPart() {
+	h.partSums = h.part.Sum(h.partSums)
+	h.parts++
+	h.part.Reset()
+	h.partWritten = 0
+}
+
+// 按 expected 的格式给出本地校验值: expected 带 "-段数" 就给组合校验值, 不带就给整个对象的
+func (h *checksumHasher) value(expected string) string {
+	if _, _, composite := splitCompositeChecksum(expected); !composite || h.part == nil {
+		return h.mode.encode(h.full.Sum(nil))
+	}
+	if h.partWritten > 0 {
+		h.finishPart()
+	}
+	sum := h.mode.newHash()
+	sum.Write(h.partSums)
+	return h.mode.encode(sum.Sum(nil)) + "-" + strconv.Itoa(h.parts)
+}
+
+// 比对, 不一致返回 *IntegrityError
+func (h *checksumHasher) verify(bucketName, awsFileName, expected string) error {
+	if actual := h.value(expected); actual != expected {
+		return &IntegrityError{Bucket: bucketName, Key: awsFileName, Mode: h.mode, Expected: expected, Actual: actual}
+	}
+	log.Debugf("校验通过 %s:%s, %s= %s", bucketName, awsFileName, h.mode, expected)
+	return nil
+}
+
+// 拆组合校验值 "xxx-3" -> "xxx", 3, true
+func splitCompositeChecksum(checksum string) (string, int, bool) {
+	i := strings.LastIndexByte(checksum, '-')
+	if i < 0 {
+		return checksum, 0, false
+	}
+	parts, err := strconv.Atoi(checksum[i+1:])
+	if err != nil {
+		return checksum, 0, false
+	}
+	return checksum[:i], parts, true
+}
+
+// 查分段上传的对象每段多大 (HEAD partNumber=1), 不是组合校验值返回 0
+func (basics BucketBasics) checksumPartSize(ctx context.Context, bucketName string, awsFileName string, expected string, enc Encryption) (int64, error) {
+	if _, _, composite := splitCompositeChecksum(expected); !composite {
+		return 0, nil
+	}
+	input := &s3.HeadObjectInput{
+		Bucket:     aws.String(bucketName),
+		Key:        aws.String(awsFileName),
+		PartNumber: aws.Int32(1),
+	}
+	enc.applyHead(input)
+	head, err := basics.clientFor(ctx, bucketName).HeadObject(ctx, input)
+	if err != nil {
+		return 0, fmt.Errorf("查询 %s:%s 的分段大小失败: %w", bucketName, awsFileName, err)
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+// 查 s3 上保存的校验值, mode 为空时按 SHA256 > CRC32C > MD5 挑一个有的
+func (mode ChecksumMode) pick(etag, crc32c, sha256 *string, encrypted bool) (ChecksumMode, string, error) {
+	if mode == ChecksumModeNone {
+		switch {
+		case aws.ToString(sha256) != "":
+			mode = ChecksumModeSHA256
+		case aws.ToString(crc32c) != "":
+			mode = ChecksumModeCRC32C
+		default:
+			mode = ChecksumModeMD5
+		}
+	}
+	if mode == ChecksumModeMD5 && encrypted {
+		return mode, "", errors.New("SSE-KMS/SSE-C 加密的对象 ETag 不是 MD5, 不能用 MD5 校验")
+	}
+	expected := mode.expected(etag, crc32c, sha256)
+	if expected == "" {
+		return mode, "", fmt.Errorf("对象没有 %s 校验值, 上传时要指定对应的校验算法", mode)
+	}
+	return mode, expected, nil
+}
+
+// 校验 - 本地文件和 s3 上的对象是否一样
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	localFileName string : 本地文件
+	mode ChecksumMode : 校验方式, 不填按 SHA256 > CRC32C > MD5 挑对象有的
+	enc ...Encryption : 可选, SSE-C 对象要传密钥, 不然 HEAD 会报 400
+返回值:
+	error: 一样返回 nil, 不一样返回 *IntegrityError
+思路:
+	1. HEAD 拿到 s3 上保存的校验值 (要开 ChecksumMode 才会返回 SHA256/CRC32C)
+	2. 组合校验值要查分段大小
+	3. 读本地文件算校验值, 比对
+*/
+func (basics BucketBasics) ObjectVerify(ctx context.Context, bucketName string, awsFileName string, localFileName string, mode ChecksumMode, enc ...Encryption) error {
+	if err := mode.validate(); err != nil {
+		return err
+	}
+	var encryption Encryption
+	if len(enc) > 0 {
+		if err := enc[0].validate(); err != nil {
+			return err
+		}
+		encryption = enc[0]
+	}
+
+	// 1. HEAD 拿到 s3 上保存的校验值
+	input := &s3.HeadObjectInput{
+		Bucket:       aws.String(bucketName),
+		Key:          aws.String(awsFileName),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}
+	encryption.applyHead(input)
+	head, err := basics.clientFor(ctx, bucketName).HeadObject(ctx, input)
+	if err != nil {
+		log.Errorf("校验 %s:%s 失败, err= %v", bucketName, awsFileName, err)
+		return err
+	}
+	if isClientEncrypted(head.Metadata) {
+		return fmt.Errorf("校验 %s:%s 失败, 客户端加密的对象 s3 上是密文, 没法和本地明文比对", bucketName, awsFileName)
+	}
+	encrypted := head.ServerSideEncryption == types.ServerSideEncryptionAwsKms || head.ServerSideEncryption == types.ServerSideEncryptionAwsKmsDsse ||
+		head.SSECustomerAlgorithm != nil
+	mode, expected, err := mode.pick(head.ETag, head.ChecksumCRC32C, head.ChecksumSHA256, encrypted)
+	if err != nil {
+		return fmt.Errorf("校验 %s:%s 失败, %w", bucketName, awsFileName, err)
+	}
+
+	// 2. 组合校验值要查分段大小
+	partSize, err := basics.checksumPartSize(ctx, bucketName, awsFileName, expected, encryption)
+	if err != nil {
+		return err
+	}
+
+	// 3. 读本地文件算校验值, 比对
+	file, err := os.Open(localFileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hasher := newChecksumHasher(mode, partSize)
+	if _, err = io.Copy(hasher, file); err != nil {
+		return err
+	}
+	if err = hasher.verify(bucketName, awsFileName, expected); err != nil {
+		log.Errorf("本地文件 %s 和 %s:%s 不一样, err= %v", localFileName, bucketName, awsFileName, err)
+		return err
+	}
+	log.Infof("本地文件 %s 和 %s:%s 一样, %s= %s", localFileName, bucketName, awsFileName, mode, expected)
+	return nil
+}
+
+// 上传校验用的分段大小: 和传输管理器一样的算法, 能 Seek 的按总大小调整, 保证不超过10000段
+func uploadPartSize(body io.Reader, partSize int64, defaultPartSize int64) int64 {
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if partSize <= 0 {
+		partSize = manager.DefaultUploadPartSize
+	}
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return partSize
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return partSize
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if _, seekErr := seeker.Seek(start, io.SeekStart); err != nil || seekErr != nil {
+		return partSize
+	}
+	if size := end - start; size/partSize >= int64(manager.MaxUploadParts) {
+		partSize = size/int64(manager.MaxUploadParts) + 1
+	}
+	return partSize
+}
+
+// 下载校验用: 挑出 s3 上保存的校验值, 组合校验值要查分段大小, 返回算校验值的 hasher 和期望的校验值
+func (basics BucketBasics) downloadVerifier(ctx context.Context, bucketName string, awsFileName string, opts DownloadOptions,
+	etag, crc32c, sha256 *string, sse types.ServerSideEncryption) (*checksumHasher, string, error) {
+	encrypted := sse == types.ServerSideEncryptionAwsKms || sse == types.ServerSideEncryptionAwsKmsDsse || opts.Encryption.Mode == EncryptionSSEC
+	mode, expected, err := opts.Verify.pick(etag, crc32c, sha256, encrypted)
+	if err != nil {
+		return nil, "", fmt.Errorf("下载校验 %s:%s 失败, %w", bucketName, awsFileName, err)
+	}
+	partSize, err := basics.checksumPartSize(ctx, bucketName, awsFileName, expected, opts.Encryption)
+	if err != nil {
+		return nil, "", err
+	}
+	return newChecksumHasher(mode, partSize), expected, nil
+}
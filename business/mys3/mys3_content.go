@@ -0,0 +1,109 @@
+// 功能: 上传时的内容相关http头, 自动检测 Content-Type, 生成中文文件名的 Content-Disposition
+package mys3
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// 变量
+const sniffLen = 512 // http.DetectContentType 最多看前512字节
+
+// 检测 Content-Type
+/*
+参数:
+	key string : 对象key 或文件名, 用扩展名判断
+	body io.Reader : 数据流, 扩展名判断不出来时看内容
+	contentEncoding string : Content-Encoding, 如 gzip, 压缩过的内容看不出类型, 只看扩展名
+返回值:
+	string: Content-Type, 判断不出来是 application/octet-stream
+	io.Reader: 后续要用的数据流。能 Seek 的读完会 Seek 回去, 还是原来的; 不能 Seek 的包一层 bufio, 预读的数据不会丢
+思路:
+	1. 先看扩展名, 如 .jpg -> image/jpeg
+	2. 再看内容前512字节
+*/
+func detectContentType(key string, body io.Reader, contentEncoding string) (string, io.Reader) {
+	// 1. 先看扩展名
+	if contentType := mime.TypeByExtension(strings.ToLower(path.Ext(key))); contentType != "" {
+		return contentType, body
+	}
+	if contentEncoding != "" || body == nil {
+		return "application/octet-stream", body
+	}
+
+	// 2. 再看内容前512字节
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			head := make([]byte, sniffLen)
+			n, _ := io.ReadFull(seeker, head)
+			if _, err = seeker.Seek(start, io.SeekStart); err == nil {
+				return http.DetectContentType(head[:n]), body
+			}
+		}
+	}
+	buffered := bufio.NewReaderSize(body, sniffLen)
+	head, _ := buffered.Peek(sniffLen) // 不够512字节会返回 EOF, 有多少看多少
+	return http.DetectContentType(head), buffered
+}
+
+// 检测 Content-Type, 同 detectContentType, 给其他存储后端用, 保证和 s3 上传时判断的一样
+func DetectContentType(key string, body io.Reader) (string, io.Reader) {
+	return detectContentType(key, body, "")
+}
+
+// 生成 Content-Disposition, 中文文件名按 RFC 5987 编码, 浏览器下载时文件名不乱码
+/*
+参数:
+	dispositionType string : inline (浏览器直接显示) 或 attachment (下载), 不填默认 attachment
+	fileName string : 文件名, 如 "充满各种变态行为的家-1.jpg"
+返回值:
+	string: 如 attachment; filename="_________-1.jpg"; filename*=UTF-8''%E5%85%85...-1.jpg
+	        filename 是给老浏览器的 ASCII 兜底, 新浏览器都用 filename*
+*/
+func ContentDisposition(dispositionType string, fileName string) string {
+	if dispositionType == "" {
+		dispositionType = "attachment"
+	}
+	if fileName == "" {
+		return dispositionType
+	}
+
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range fileName {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('_')
+		case r < 0x20 || r > 0x7e:
+			fallback.WriteByte('_')
+			ascii = false
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	if ascii {
+		return fmt.Sprintf(`%s; filename="%s"`, dispositionType, fallback.String())
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, dispositionType, fallback.String(), rfc5987Escape(fileName))
+}
+
+// RFC 5987 编码: 除了 attr-char 都按 UTF-8 字节 %XX 编码 (url.PathEscape 会保留 ; = 等, 不能直接用)
+func rfc5987Escape(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,396 @@
+// 功能: 对象的复制、移动、改名 (服务端复制, 不经过本地)
+package mys3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"study-aws-api-go/log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 变量
+const (
+	maxCopyObjectSize   = 5 * 1024 * 1024 * 1024 // CopyObject 最大只能复制 5GB, 再大要用 UploadPartCopy
+	defaultCopyPartSize = 512 * 1024 * 1024      // UploadPartCopy 默认每段 512MB
+	defaultCopyParallel = 5                      // UploadPartCopy 默认并发数
+)
+
+// 复制选项
+type CopyOptions struct {
+	ReplaceMetadata bool              // true: 用 Metadata 替换原来的元数据 (客户端加密的 cse-* 会保留); false(默认): 保留原来的元数据
+	Metadata        map[string]string // 新的用户元数据 x-amz-meta-*, ReplaceMetadata=true 时生效
+	ContentType     string            // 新的 Content-Type, ReplaceMetadata=true 时生效, 不填保留原来的
+	ReplaceTags     bool              // true: 用 Tags 替换原来的标签; false(默认): 保留原来的标签
+	Tags            map[string]string // 新的标签, ReplaceTags=true 时生效
+	SourceVersionId string            // 复制源对象的指定版本, 不填复制当前版本
+
+	SourceEncryption Encryption // 源对象的加密, 只有 SSE-C 要传源对象的密钥
+	Encryption       Encryption // 目标对象的加密, 不填用目标存储桶默认加密 (不会继承源对象的 SSE-KMS/SSE-C)
+
+	StorageClass types.StorageClass // 目标对象的存储类型, 不填是 STANDARD (不会继承源对象的)
+
+	MultipartThreshold int64 // 超过多大用分段复制, <=0 默认5GB, 最大也是5GB
+	PartSize           int64 // 分段复制每段大小, <=0 默认512MB
+	Concurrency        int   // 分段复制并发数, <=0 默认5
+}
+
+// 复制结果
+type CopyResult struct {
+	ETag      string // 新对象的ETag
+	VersionId string // 新对象的版本id, 存储桶没开版本控制时为空
+}
+
+// 复制
+/*
+参数:
+	ctx context.Contex : 上下文
+	srcBucket string : 源存储桶
+	srcKey string : 源对象key
+	dstBucket string : 目标存储桶, 可以和源存储桶一样
+	dstKey string : 目标对象key
+	opts CopyOptions : 元数据/标签是保留还是替换, 分段复制参数
+返回值:
+	*CopyResult: 复制结果
+	error: 错误
+思路:
+	1. HeadObject 拿到源对象大小和元数据
+	2. <=5GB 用 CopyObject 一次复制
+	3. >5GB 用 UploadPartCopy 分段复制
+	4. 等待目标对象确实存在,默认1分钟
+*/
+func (basics BucketBasics) ObjectCopy(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string, opts CopyOptions) (*CopyResult, error) {
+	// 1. HeadObject 拿到源对象大小和元数据
+	if err := opts.SourceEncryption.validate(); err != nil {
+		return nil, fmt.Errorf("源对象加密选项不对: %w", err)
+	}
+	if err := opts.Encryption.validate(); err != nil {
+		return nil, fmt.Errorf("目标对象加密选项不对: %w", err)
+	}
+	headInput := &s3.HeadObjectInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(srcKey),
+	}
+	opts.SourceEncryption.applyHead(headInput)
+	if opts.SourceVersionId != "" {
+		headInput.VersionId = aws.String(opts.SourceVersionId)
+	}
+	head, err := basics.clientFor(ctx, srcBucket).HeadObject(ctx, headInput)
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			log.Errorf("复制失败, 源文件 %s:%s 不存在", srcBucket, srcKey)
+			err = notFound
+		}
+		log.Errorf("复制 %s:%s -> %s:%s 失败, err= %v", srcBucket, srcKey, dstBucket, dstKey, err)
+		return nil, err
+	}
+
+	// 2/3. 按大小选择复制方式
+	threshold := opts.MultipartThreshold
+	if threshold <= 0 {
+		threshold = maxCopyObjectSize
+	}
+	threshold = min(threshold, maxCopyObjectSize) // 配得再大, 超过5GB的 CopyObject 也复制不了
+	var result *CopyResult
+	if aws.ToInt64(head.ContentLength) <= threshold {
+		result, err = basics.copySmall(ctx, srcBucket, srcKey, dstBucket, dstKey, head, opts)
+	} else {
+		result, err = basics.copyMultipart(ctx, srcBucket, srcKey, dstBucket, dstKey, head, opts)
+	}
+	if err != nil {
+		log.Errorf("复制 %s:%s -> %s:%s 失败, err= %v", srcBucket, srcKey, dstBucket, dstKey, err)
+		return nil, err
+	}
+
+	// 4. 等待目标对象确实存在,默认1分钟
+	dstHeadInput := &s3.HeadObjectInput{
+		Bucket: aws.String(dstBucket),
+		Key:    aws.String(dstKey),
+	}
+	opts.Encryption.applyHead(dstHeadInput)
+	err = s3.NewObjectExistsWaiter(basics.clientFor(ctx, dstBucket)).Wait(ctx, dstHeadInput, time.Minute)
+	if err != nil {
+		log.Errorf("等待失败。复制 %s:%s -> %s:%s 失败. reason: %v", srcBucket, srcKey, dstBucket, dstKey, err)
+		return nil, err
+	}
+
+	log.Infof("复制 %s:%s -> %s:%s 成功", srcBucket, srcKey, dstBucket, dstKey)
+	return result, nil
+}
+
+// 移动: 先复制再删除源对象, 可以跨存储桶
+/*
+参数:
+	ctx context.Contex : 上下文
+	srcBucket string : 源存储桶
+	srcKey string : 源对象key
+	dstBucket string : 目标存储桶
+	dstKey string : 目标对象key
+	opts CopyOptions : 同 ObjectCopy
+返回值:
+	*CopyResult: 复制结果
+	error: 错误。复制成功但删除源对象失败时, 目标对象已经存在, 返回删除的错误
+*/
+func (basics BucketBasics) ObjectMove(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string, opts CopyOptions) (*CopyResult, error) {
+	if srcBucket == dstBucket && srcKey == dstKey {
+		return nil, fmt.Errorf("移动失败, 源和目标一样 %s:%s", srcBucket, srcKey)
+	}
+	result, err := basics.ObjectCopy(ctx, srcBucket, srcKey, dstBucket, dstKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = basics.ObjectDelete(ctx, srcBucket, srcKey, "", false); err != nil {
+		log.Errorf("移动 %s:%s -> %s:%s, 复制成功但删除源文件失败, err= %v", srcBucket, srcKey, dstBucket, dstKey, err)
+		return result, err
+	}
+	log.Infof("移动 %s:%s -> %s:%s 成功", srcBucket, srcKey, dstBucket, dstKey)
+	return result, nil
+}
+
+// 改名: 同一个存储桶里移动
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶
+	oldKey string : 原来的对象key
+	newKey string : 新的对象key
+	opts ...CopyOptions : 可选, 同 ObjectCopy, 如 SSE-C 对象要传 SourceEncryption 和 Encryption
+返回值:
+	*CopyResult: 复制结果
+	error: 错误
+*/
+func (basics BucketBasics) ObjectRename(ctx context.Context, bucketName string, oldKey string, newKey string, opts ...CopyOptions) (*CopyResult, error) {
+	var opt CopyOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	return basics.ObjectMove(ctx, bucketName, oldKey, bucketName, newKey, opt)
+}
+
+// 复制小对象 (<=5GB), 一次 CopyObject
+func (basics BucketBasics) copySmall(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, head *s3.HeadObjectOutput, opts CopyOptions) (*CopyResult, error) {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(dstBucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(copySource(srcBucket, srcKey, opts.SourceVersionId)),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		TaggingDirective:  types.TaggingDirectiveCopy,
+		StorageClass:      opts.StorageClass,
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId, input.BucketKeyEnabled = opts.Encryption.sse()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = opts.Encryption.sseC()
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = opts.SourceEncryption.sseC()
+	if opts.ReplaceMetadata {
+		// REPLACE 会把 Content-Type 这些系统元数据也替换掉, 没指定的从源对象带过来
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.Metadata = mergeMetadata(opts.Metadata, cseMetadataOf(head.Metadata)) // 客户端加密的数据密钥和IV不能丢
+		input.ContentType = head.ContentType
+		input.CacheControl = head.CacheControl
+		input.ContentDisposition = head.ContentDisposition
+		input.ContentEncoding = head.ContentEncoding
+		input.ContentLanguage = head.ContentLanguage
+		if opts.ContentType != "" {
+			input.ContentType = aws.String(opts.ContentType)
+		}
+	}
+	if opts.ReplaceTags {
+		input.TaggingDirective = types.TaggingDirectiveReplace
+		input.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+
+	output, err := basics.clientFor(ctx, dstBucket).CopyObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	result := &CopyResult{VersionId: aws.ToString(output.VersionId)}
+	if output.CopyObjectResult != nil {
+		result.ETag = aws.ToString(output.CopyObjectResult.ETag)
+	}
+	return result, nil
+}
+
+// 复制大对象 (>5GB), CreateMultipartUpload + 并发 UploadPartCopy + CompleteMultipartUpload
+/*
+思路:
+	1. 准备元数据和标签: 保留就从源对象拿, 替换就用 opts 里的
+	2. 创建分段上传
+	3. 按字节范围并发 UploadPartCopy
+	4. 合并分段, 出错就 abort 掉, 不留垃圾分段
+*/
+func (basics BucketBasics) copyMultipart(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, head *s3.HeadObjectOutput, opts CopyOptions) (*CopyResult, error) {
+	// 1. 准备元数据和标签
+	createInput := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(dstBucket),
+		Key:                aws.String(dstKey),
+		Metadata:           head.Metadata,
+		ContentType:        head.ContentType,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		StorageClass:       opts.StorageClass,
+	}
+	createInput.ServerSideEncryption, createInput.SSEKMSKeyId, createInput.BucketKeyEnabled = opts.Encryption.sse()
+	createInput.SSECustomerAlgorithm, createInput.SSECustomerKey, createInput.SSECustomerKeyMD5 = opts.Encryption.sseC()
+	if opts.ReplaceMetadata {
+		createInput.Metadata = mergeMetadata(opts.Metadata, cseMetadataOf(head.Metadata)) // 客户端加密的数据密钥和IV不能丢
+		if opts.ContentType != "" {
+			createInput.ContentType = aws.String(opts.ContentType)
+		}
+	}
+	tags := opts.Tags
+	if !opts.ReplaceTags {
+		tagInput := &s3.GetObjectTaggingInput{
+			Bucket: aws.String(srcBucket),
+			Key:    aws.String(srcKey),
+		}
+		if opts.SourceVersionId != "" {
+			tagInput.VersionId = aws.String(opts.SourceVersionId)
+		}
+		tagOut, err := basics.clientFor(ctx, srcBucket).GetObjectTagging(ctx, tagInput)
+		if err != nil {
+			return nil, fmt.Errorf("读取源文件标签失败: %w", err)
+		}
+		tags = tagsToMap(tagOut.TagSet)
+	}
+	if len(tags) > 0 {
+		createInput.Tagging = aws.String(encodeTags(tags))
+	}
+
+	// 2. 创建分段上传
+	createOut, err := basics.clientFor(ctx, dstBucket).CreateMultipartUpload(ctx, createInput)
+	if err != nil {
+		return nil, err
+	}
+	uploadId := createOut.UploadId
+
+	// 3. 按字节范围并发 UploadPartCopy
+	size := aws.ToInt64(head.ContentLength)
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = defaultCopyPartSize
+	}
+	partSize = resumablePartSize(size, partSize) // 最小5MB, 最多10000段
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultCopyParallel
+	}
+	partCount := int32((size + partSize - 1) / partSize)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		parts    = make([]types.CompletedPart, 0, partCount)
+		partChan = make(chan int32)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partChan {
+				start := int64(partNumber-1) * partSize
+				end := min(start+partSize, size) - 1
+				partInput := &s3.UploadPartCopyInput{
+					Bucket:          aws.String(dstBucket),
+					Key:             aws.String(dstKey),
+					UploadId:        uploadId,
+					PartNumber:      aws.Int32(partNumber),
+					CopySource:      aws.String(copySource(srcBucket, srcKey, opts.SourceVersionId)),
+					CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+				}
+				partInput.SSECustomerAlgorithm, partInput.SSECustomerKey, partInput.SSECustomerKeyMD5 = opts.Encryption.sseC()
+				partInput.CopySourceSSECustomerAlgorithm, partInput.CopySourceSSECustomerKey, partInput.CopySourceSSECustomerKeyMD5 = opts.SourceEncryption.sseC()
+				partOut, err := basics.clientFor(ctx, dstBucket).UploadPartCopy(ctx, partInput)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("分段复制第 %d 段失败: %w", partNumber, err)
+					}
+				} else {
+					parts = append(parts, types.CompletedPart{
+						PartNumber: aws.Int32(partNumber),
+						ETag:       partOut.CopyPartResult.ETag,
+					})
+					log.Debugf("分段复制第 %d/%d 段成功 %s:%s", partNumber, partCount, dstBucket, dstKey)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for partNumber := int32(1); partNumber <= partCount; partNumber++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			break
+		}
+		partChan <- partNumber
+	}
+	close(partChan)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+
+	// 4. 合并分段, 出错就 abort 掉
+	if firstErr == nil {
+		sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+		completeInput := &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(dstBucket),
+			Key:             aws.String(dstKey),
+			UploadId:        uploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		}
+		completeInput.SSECustomerAlgorithm, completeInput.SSECustomerKey, completeInput.SSECustomerKeyMD5 = opts.Encryption.sseC()
+		completeOut, err := basics.clientFor(ctx, dstBucket).CompleteMultipartUpload(ctx, completeInput)
+		if err == nil {
+			return &CopyResult{ETag: aws.ToString(completeOut.ETag), VersionId: aws.ToString(completeOut.VersionId)}, nil
+		}
+		firstErr = err
+	}
+	_, abortErr := basics.clientFor(ctx, dstBucket).AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(dstBucket),
+		Key:      aws.String(dstKey),
+		UploadId: uploadId,
+	})
+	if abortErr != nil {
+		log.Errorf("取消分段复制失败 %s:%s, uploadId= %s, err= %v", dstBucket, dstKey, aws.ToString(uploadId), abortErr)
+	}
+	return nil, firstErr
+}
+
+// 拼 CopySource: "存储桶/对象key", key 要 url 编码 (中文文件名、空格等), "/" 保留; 有版本id就加 "?versionId=xxx"
+func copySource(bucketName string, key string, versionId string) string {
+	source := bucketName + "/" + (&url.URL{Path: key}).EscapedPath()
+	if versionId != "" {
+		source += "?versionId=" + url.QueryEscape(versionId)
+	}
+	return source
+}
+
+// 标签 map -> "k1=v1&k2=v2", 给 Tagging 参数用
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// 标签 []types.Tag -> map
+func tagsToMap(tagSet []types.Tag) map[string]string {
+	tags := make(map[string]string, len(tagSet))
+	for _, tag := range tagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags
+}
@@ -0,0 +1,319 @@
+package mys3
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestObjectCopy(t *testing.T) {
+	ctx, basics, srv := newTestBucket(t)
+	opts := UploadOptions{Metadata: map[string]string{"comic-id": "1024"}, Tags: map[string]string{"country": "kr"}}
+	if _, err := basics.ObjectUpload(ctx, testBucket, "src.txt", writeTempFile(t, "src.txt", []byte("hello")), opts); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+
+	// 默认保留元数据和标签
+	if _, err := basics.ObjectCopy(ctx, testBucket, "src.txt", testBucket, "keep.txt", CopyOptions{}); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	metadata, _ := basics.ObjectMetadataGet(ctx, testBucket, "keep.txt")
+	tags, _ := basics.ObjectTagsGet(ctx, testBucket, "keep.txt")
+	if metadata == nil || metadata.Metadata["comic-id"] != "1024" || tags["country"] != "kr" {
+		t.Fatalf("复制后元数据和标签应该保留, metadata= %+v, tags= %v", metadata, tags)
+	}
+
+	// 替换元数据和标签
+	_, err := basics.ObjectCopy(ctx, testBucket, "src.txt", testBucket, "replace.txt", CopyOptions{
+		ReplaceMetadata: true,
+		Metadata:        map[string]string{"comic-id": "2048"},
+		ContentType:     "text/markdown",
+		ReplaceTags:     true,
+		Tags:            map[string]string{"country": "jp"},
+	})
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	metadata, _ = basics.ObjectMetadataGet(ctx, testBucket, "replace.txt")
+	tags, _ = basics.ObjectTagsGet(ctx, testBucket, "replace.txt")
+	if metadata == nil || metadata.Metadata["comic-id"] != "2048" || metadata.ContentType != "text/markdown" || tags["country"] != "jp" {
+		t.Fatalf("复制后元数据和标签应该替换, metadata= %+v, tags= %v", metadata, tags)
+	}
+
+	// 改名
+	if _, err = basics.ObjectRename(ctx, testBucket, "src.txt", "renamed.txt"); err != nil {
+		t.Fatalf("改名失败: %v", err)
+	}
+	if _, ok := srv.ObjectData(testBucket, "src.txt"); ok {
+		t.Fatal("改名后原来的对象不应该存在")
+	}
+	if data, _ := srv.ObjectData(testBucket, "renamed.txt"); string(data) != "hello" {
+		t.Fatalf("改名后的内容不对: %q", data)
+	}
+}
+
+func TestObjectCopyMultipart(t *testing.T) {
+	ctx, basics, srv := newTestBucket(t)
+	data := randomBytes(t, 11*1024*1024)
+	opts := UploadOptions{Metadata: map[string]string{"comic-id": "1024"}, Tags: map[string]string{"country": "kr"}}
+	if _, err := basics.ObjectUpload(ctx, testBucket, "src.bin", writeTempFile(t, "src.bin", data), opts); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+
+	result, err := basics.ObjectCopy(ctx, testBucket, "src.bin", testBucket, "dst.bin",
+		CopyOptions{MultipartThreshold: 1, PartSize: 5 * 1024 * 1024, Concurrency: 2})
+	if err != nil {
+		t.Fatalf("分段复制失败: %v", err)
+	}
+	if got, _ := srv.ObjectData(testBucket, "dst.bin"); !bytes.Equal(got, data) {
+		t.Fatal("分段复制后内容不对")
+	}
+	metadata, _ := basics.ObjectMetadataGet(ctx, testBucket, "dst.bin")
+	tags, _ := basics.ObjectTagsGet(ctx, testBucket, "dst.bin")
+	if metadata == nil || metadata.ETag != result.ETag || metadata.Metadata["comic-id"] != "1024" || tags["country"] != "kr" {
+		t.Fatalf("分段复制后元数据和标签应该保留, metadata= %+v, tags= %v", metadata, tags)
+	}
+}
+
+func TestObjectCopyReplaceMetadataClientEncrypted(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	basics.CSEMasterKey = randomBytes(t, 32)
+	data := randomBytes(t, 200*1024)
+	if _, err := basics.ObjectUpload(ctx, testBucket, "secret.bin", writeTempFile(t, "secret.bin", data), UploadOptions{ClientEncrypt: true}); err != nil {
+		t.Fatalf("客户端加密上传失败: %v", err)
+	}
+
+	// 替换元数据时, 一次复制和分段复制都要保留 cse-* 才能解密
+	for name, threshold := range map[string]int64{"small.bin": 0, "multipart.bin": 1} {
+		_, err := basics.ObjectCopy(ctx, testBucket, "secret.bin", testBucket, name, CopyOptions{
+			ReplaceMetadata:    true,
+			Metadata:           map[string]string{"comic-id": "2048"},
+			MultipartThreshold: threshold,
+		})
+		if err != nil {
+			t.Fatalf("复制 %s 失败: %v", name, err)
+		}
+		metadata, _ := basics.ObjectMetadataGet(ctx, testBucket, name)
+		if metadata == nil || metadata.Metadata["comic-id"] != "2048" {
+			t.Fatalf("%s 的元数据应该替换, metadata= %+v", name, metadata)
+		}
+		downloadFileName := filepath.Join(t.TempDir(), name)
+		if err = basics.ObjectDownload(ctx, testBucket, name, downloadFileName); err != nil {
+			t.Fatalf("%s 解密下载失败: %v", name, err)
+		}
+		if got, _ := os.ReadFile(downloadFileName); !bytes.Equal(got, data) {
+			t.Fatalf("%s 解密后的内容不对", name)
+		}
+	}
+}
+
+func TestObjectRenameSSEC(t *testing.T) {
+	ctx, basics, srv := newTestBucket(t)
+	ssec := Encryption{Mode: EncryptionSSEC, CustomerKey: randomBytes(t, 32)}
+	if err := basics.FileUploadLowApi(ctx, testBucket, "ssec.txt", writeTempFile(t, "ssec.txt", []byte("hello")), UploadOptions{Encryption: ssec}); err != nil {
+		t.Fatalf("SSE-C 上传失败: %v", err)
+	}
+	if _, err := basics.ObjectRename(ctx, testBucket, "ssec.txt", "renamed.txt"); err == nil {
+		t.Fatal("SSE-C 对象不传密钥改名应该失败")
+	}
+	if _, err := basics.ObjectRename(ctx, testBucket, "ssec.txt", "renamed.txt", CopyOptions{SourceEncryption: ssec, Encryption: ssec}); err != nil {
+		t.Fatalf("SSE-C 对象改名失败: %v", err)
+	}
+	if _, ok := srv.ObjectData(testBucket, "ssec.txt"); ok {
+		t.Fatal("改名后原来的对象不应该存在")
+	}
+	if metadata, err := basics.ObjectMetadataGet(ctx, testBucket, "renamed.txt", ssec); err != nil || metadata.Encryption.Mode != EncryptionSSEC {
+		t.Fatalf("改名后还应该用同一个密钥加密, metadata= %+v, err= %v", metadata, err)
+	}
+}
+
+func TestObjectStorageClassSet(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	if _, err := basics.ObjectUpload(ctx, testBucket, "a.txt", writeTempFile(t, "a.txt", []byte("hello"))); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+
+	for _, storageClass := range []types.StorageClass{types.StorageClassStandardIa, types.StorageClassStandardIa, types.StorageClassGlacier} {
+		if _, err := basics.ObjectStorageClassSet(ctx, testBucket, "a.txt", storageClass); err != nil {
+			t.Fatalf("修改存储类型 %s 失败: %v", storageClass, err)
+		}
+		if metadata, _ := basics.ObjectMetadataGet(ctx, testBucket, "a.txt"); metadata == nil || metadata.StorageClass != storageClass {
+			t.Fatalf("存储类型应该是 %s, metadata= %+v", storageClass, metadata)
+		}
+	}
+
+	status, err := basics.ObjectRestoreStatus(ctx, testBucket, "a.txt")
+	if err != nil || !status.Archived || status.Ready {
+		t.Fatalf("归档对象没恢复不能下载, status= %+v, err= %v", status, err)
+	}
+	if err = basics.ObjectDownload(ctx, testBucket, "a.txt", filepath.Join(t.TempDir(), "a.txt")); errorCode(err) != "InvalidObjectState" {
+		t.Fatalf("归档对象下载应该返回 InvalidObjectState, err= %v", err)
+	}
+}
+
+func TestObjectStorageClassSetKeepsEncryption(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	kms := Encryption{Mode: EncryptionSSEKMS, KMSKeyId: "alias/comic"}
+	if err := basics.FileUploadLowApi(ctx, testBucket, "kms.txt", writeTempFile(t, "kms.txt", []byte("hello")), UploadOptions{Encryption: kms}); err != nil {
+		t.Fatalf("SSE-KMS 上传失败: %v", err)
+	}
+	if _, err := basics.ObjectStorageClassSet(ctx, testBucket, "kms.txt", types.StorageClassStandardIa); err != nil {
+		t.Fatalf("修改存储类型失败: %v", err)
+	}
+	if metadata, _ := basics.ObjectMetadataGet(ctx, testBucket, "kms.txt"); metadata == nil || metadata.StorageClass != types.StorageClassStandardIa || !reflect.DeepEqual(metadata.Encryption, kms) {
+		t.Fatalf("改存储类型后 SSE-KMS 密钥应该保留, metadata= %+v", metadata)
+	}
+
+	// SSE-C 要传密钥, 改完还是用同一个密钥读
+	ssec := Encryption{Mode: EncryptionSSEC, CustomerKey: randomBytes(t, 32)}
+	if err := basics.FileUploadLowApi(ctx, testBucket, "ssec.txt", writeTempFile(t, "ssec.txt", []byte("hello")), UploadOptions{Encryption: ssec}); err != nil {
+		t.Fatalf("SSE-C 上传失败: %v", err)
+	}
+	if _, err := basics.ObjectStorageClassSet(ctx, testBucket, "ssec.txt", types.StorageClassStandardIa); err == nil {
+		t.Fatal("SSE-C 对象不传密钥应该失败")
+	}
+	if _, err := basics.ObjectStorageClassSet(ctx, testBucket, "ssec.txt", types.StorageClassStandardIa, ssec); err != nil {
+		t.Fatalf("SSE-C 对象修改存储类型失败: %v", err)
+	}
+	downloadFileName := filepath.Join(t.TempDir(), "ssec.txt")
+	if err := basics.ObjectDownload(ctx, testBucket, "ssec.txt", downloadFileName, DownloadOptions{Encryption: ssec}); err != nil {
+		t.Fatalf("SSE-C 下载失败: %v", err)
+	}
+	if got, _ := os.ReadFile(downloadFileName); string(got) != "hello" {
+		t.Fatalf("下载的内容不对: %q", got)
+	}
+}
+
+func TestObjectUploadResumable(t *testing.T) {
+	ctx, basics, srv := newTestBucket(t)
+	data := randomBytes(t, 11*1024*1024)
+	uploadFileName := writeTempFile(t, "big.bin", data)
+
+	result, err := basics.ObjectUploadResumable(ctx, testBucket, "big.bin", uploadFileName, ResumableOptions{PartSize: 5 * 1024 * 1024})
+	if err != nil {
+		t.Fatalf("断点续传上传失败: %v", err)
+	}
+	if got, _ := srv.ObjectData(testBucket, "big.bin"); !bytes.Equal(got, data) {
+		t.Fatalf("上传后内容不对, result= %+v", result)
+	}
+	if _, err = os.Stat(uploadFileName + checkpointFileSuffix); !os.IsNotExist(err) {
+		t.Fatal("上传成功后断点文件应该删掉")
+	}
+}
+
+func TestObjectUploadResumableDiscardStale(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	uploadFileName := writeTempFile(t, "big.bin", randomBytes(t, 1024))
+	checkpointFile := uploadFileName + checkpointFileSuffix
+	for name, checkpoint := range map[string]string{
+		"不一致": `{"bucket":"%s","key":"old.bin","fileSize":1024,"partSize":5242880,"uploadId":"%s"}`,
+		"损坏":  `{"bucket":"%s","key":"old.bin","fileSize":"1024","uploadId":"%s"}`,
+	} {
+		output, err := basics.S3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String(testBucket), Key: aws.String("old.bin")})
+		if err != nil {
+			t.Fatalf("创建分段上传失败: %v", err)
+		}
+		if err = os.WriteFile(checkpointFile, fmt.Appendf(nil, checkpoint, testBucket, aws.ToString(output.UploadId)), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// 断点用不了, 旧的分段上传要取消掉, 不然一直收费
+		if _, err = basics.ObjectUploadResumable(ctx, testBucket, "big.bin", uploadFileName, ResumableOptions{}); err != nil {
+			t.Fatalf("%s: 断点续传上传失败: %v", name, err)
+		}
+		if uploads, err := basics.MultipartUploadsList(ctx, testBucket, ""); err != nil || len(uploads) != 0 {
+			t.Fatalf("%s: 旧的分段上传应该取消了, uploads= %v, err= %v", name, uploads, err)
+		}
+	}
+}
+
+func TestObjectUploadResumableOptions(t *testing.T) {
+	ctx, basics, srv := newTestBucket(t)
+	data := randomBytes(t, 6*1024*1024)
+	uploadFileName := writeTempFile(t, "big.bin", data)
+	info, _ := os.Stat(uploadFileName)
+	ssec := Encryption{Mode: EncryptionSSEC, CustomerKey: randomBytes(t, 32)}
+	opts := UploadOptions{
+		Encryption:   ssec,
+		Metadata:     map[string]string{"comic-id": "1024"},
+		Tags:         map[string]string{"country": "kr"},
+		StorageClass: types.StorageClassStandardIa,
+		ContentType:  "application/zip",
+	}
+
+	// 断点文件里的选项和本次不一样, 旧的分段上传取消掉重新传
+	output, err := basics.S3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String(testBucket), Key: aws.String("big.bin")})
+	if err != nil {
+		t.Fatalf("创建分段上传失败: %v", err)
+	}
+	checkpointFile := uploadFileName + checkpointFileSuffix
+	err = saveCheckpoint(checkpointFile, &uploadCheckpoint{
+		Bucket: testBucket, Key: "big.bin", FileName: uploadFileName, FileSize: info.Size(), ModTime: info.ModTime(),
+		PartSize: manager.MinUploadPartSize, UploadId: aws.ToString(output.UploadId),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = basics.ObjectUploadResumable(ctx, testBucket, "big.bin", uploadFileName, ResumableOptions{}, opts); err != nil {
+		t.Fatalf("断点续传上传失败: %v", err)
+	}
+	if uploads, err := basics.MultipartUploadsList(ctx, testBucket, ""); err != nil || len(uploads) != 0 {
+		t.Fatalf("上传选项变了, 旧的分段上传应该取消了, uploads= %v, err= %v", uploads, err)
+	}
+	if got, _ := srv.ObjectData(testBucket, "big.bin"); !bytes.Equal(got, data) {
+		t.Fatal("上传后内容不对")
+	}
+
+	// 上传选项都生效了
+	if _, err = basics.ObjectMetadataGet(ctx, testBucket, "big.bin"); err == nil {
+		t.Fatal("SSE-C 对象不带密钥 HEAD 应该失败")
+	}
+	metadata, err := basics.ObjectMetadataGet(ctx, testBucket, "big.bin", ssec)
+	if err != nil || metadata.Encryption.Mode != EncryptionSSEC || metadata.Metadata["comic-id"] != "1024" ||
+		metadata.StorageClass != types.StorageClassStandardIa || metadata.ContentType != "application/zip" {
+		t.Fatalf("上传选项没生效, metadata= %+v, err= %v", metadata, err)
+	}
+	if tags, err := basics.ObjectTagsGet(ctx, testBucket, "big.bin"); err != nil || tags["country"] != "kr" {
+		t.Fatalf("标签不对: %v, err= %v", tags, err)
+	}
+
+	// 断点文件里只有密钥的 md5, 没有密钥
+	cpData, _ := json.Marshal(newCheckpointOptions(opts))
+	if bytes.Contains(cpData, []byte(base64.StdEncoding.EncodeToString(ssec.CustomerKey))) || !bytes.Contains(cpData, []byte("customerKeyMD5")) {
+		t.Fatalf("断点文件不应该存 SSE-C 密钥: %s", cpData)
+	}
+}
+
+func TestMultipartUploadsAbort(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	for _, key := range []string{"a.bin", "dir/b.bin"} {
+		_, err := basics.S3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String(testBucket), Key: aws.String(key)})
+		if err != nil {
+			t.Fatalf("创建分段上传失败: %v", err)
+		}
+	}
+
+	if uploads, err := basics.MultipartUploadsList(ctx, testBucket, "dir/"); err != nil || len(uploads) != 1 {
+		t.Fatalf("按前缀查分段上传应该有1个, uploads= %v, err= %v", uploads, err)
+	}
+	if aborted, err := basics.MultipartUploadsAbort(ctx, testBucket, "", time.Hour); err != nil || aborted != 0 {
+		t.Fatalf("没有超过1小时的分段上传, aborted= %d, err= %v", aborted, err)
+	}
+	if aborted, err := basics.MultipartUploadsAbort(ctx, testBucket, "", 0); err != nil || aborted != 2 {
+		t.Fatalf("应该取消2个分段上传, aborted= %d, err= %v", aborted, err)
+	}
+	if uploads, err := basics.MultipartUploadsList(ctx, testBucket, ""); err != nil || len(uploads) != 0 {
+		t.Fatalf("取消后不应该有分段上传了, uploads= %v, err= %v", uploads, err)
+	}
+}
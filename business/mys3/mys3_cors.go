@@ -0,0 +1,136 @@
+// 功能: 存储桶跨域(CORS)规则, 浏览器用预签名url直传 s3 时, 存储桶本身也要允许跨域
+package mys3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"study-aws-api-go/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// 浏览器预检请求缓存时间, 秒
+const corsMaxAgeSeconds = 3000
+
+// 查 - 存储桶跨域规则
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	[]types.CORSRule: 跨域规则, 没有配置时为空
+	error: 错误
+*/
+func (basics BucketBasics) BucketCorsGet(ctx context.Context, bucketName string) ([]types.CORSRule, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetBucketCors(ctx, &s3.GetBucketCorsInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchCORSConfiguration" {
+			log.Debugf("存储桶 %s 没有跨域规则", bucketName)
+			return []types.CORSRule{}, nil
+		}
+		log.Errorf("查询存储桶 %s 跨域规则失败, err= %v", bucketName, err)
+		return nil, err
+	}
+	for _, rule := range output.CORSRules {
+		log.Debugf("存储桶 %s 跨域规则: origins= %v, methods= %v", bucketName, rule.AllowedOrigins, rule.AllowedMethods)
+	}
+	return output.CORSRules, nil
+}
+
+// 改 - 设置存储桶跨域规则, 会整个替换原来的规则
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	rules []types.CORSRule : 跨域规则, 最多100条, 可以用 CorsRulesFromOrigins 生成
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketCorsPut(ctx context.Context, bucketName string, rules []types.CORSRule) error {
+	if len(rules) == 0 {
+		return errors.New("设置跨域规则失败, 规则为空, 要删除全部规则请用 BucketCorsDelete")
+	}
+	_, err := basics.clientFor(ctx, bucketName).PutBucketCors(ctx, &s3.PutBucketCorsInput{
+		Bucket:            aws.String(bucketName),
+		CORSConfiguration: &types.CORSConfiguration{CORSRules: rules},
+	})
+	if err != nil {
+		log.Errorf("设置存储桶 %s 跨域规则失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("设置存储桶 %s 跨域规则成功, 共 %d 条", bucketName, len(rules))
+	return nil
+}
+
+// 删 - 删除存储桶的所有跨域规则
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketCorsDelete(ctx context.Context, bucketName string) error {
+	_, err := basics.clientFor(ctx, bucketName).DeleteBucketCors(ctx, &s3.DeleteBucketCorsInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		log.Errorf("删除存储桶 %s 跨域规则失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("删除存储桶 %s 跨域规则成功", bucketName)
+	return nil
+}
+
+// 根据允许的来源生成跨域规则, 够浏览器用预签名url上传、下载
+/*
+参数:
+	origins []string : 允许的来源, 如 config.yaml 的 aws_s3.cors_allowed_origins, ["https://www.example.com"]
+返回值:
+	[]types.CORSRule: 2条规则, 上传(PUT/POST) 和 下载(GET/HEAD)
+	error: 来源为空或格式不对
+思路:
+	1. 上传规则: 允许所有请求头 (Content-Type、x-amz-checksum-* 等, 预签名时签进去的头浏览器都要带)
+	2. 下载规则: 只放 GET/HEAD
+	3. 都暴露 ETag、版本id, 前端分段上传要拿 ETag 去合并
+*/
+func CorsRulesFromOrigins(origins []string) ([]types.CORSRule, error) {
+	var allowed []string
+	for _, origin := range origins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/") // 浏览器发的 Origin 没有结尾的 /
+		if origin == "" {
+			continue
+		}
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return nil, fmt.Errorf("生成跨域规则失败, 来源 %s 要带 http:// 或 https://", origin)
+		}
+		allowed = append(allowed, origin)
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("生成跨域规则失败, 没有配置允许的来源 aws_s3.cors_allowed_origins")
+	}
+
+	exposeHeaders := []string{"ETag", "x-amz-version-id", "x-amz-request-id", "x-amz-server-side-encryption"}
+	return []types.CORSRule{
+		{
+			ID:             aws.String("browser-upload"),
+			AllowedOrigins: allowed,
+			AllowedMethods: []string{"PUT", "POST"},
+			AllowedHeaders: []string{"*"},
+			ExposeHeaders:  exposeHeaders,
+			MaxAgeSeconds:  aws.Int32(corsMaxAgeSeconds),
+		},
+		{
+			ID:             aws.String("browser-download"),
+			AllowedOrigins: allowed,
+			AllowedMethods: []string{"GET", "HEAD"},
+			AllowedHeaders: []string{"*"},
+			ExposeHeaders:  exposeHeaders,
+			MaxAgeSeconds:  aws.Int32(corsMaxAgeSeconds),
+		},
+	}, nil
+}
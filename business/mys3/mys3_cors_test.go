@@ -0,0 +1,43 @@
+package mys3
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBucketCors(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	if rules, err := basics.BucketCorsGet(ctx, testBucket); err != nil || len(rules) != 0 {
+		t.Fatalf("没配置过应该没有跨域规则, rules= %+v, err= %v", rules, err)
+	}
+
+	// 来源要带协议, 结尾的 / 去掉
+	if _, err := CorsRulesFromOrigins([]string{"www.example.com"}); err == nil {
+		t.Fatal("不带协议的来源应该报错")
+	}
+	if _, err := CorsRulesFromOrigins([]string{" ", ""}); err == nil {
+		t.Fatal("没有来源应该报错")
+	}
+	rules, err := CorsRulesFromOrigins([]string{"https://www.example.com/", "http://localhost:5173"})
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("生成跨域规则失败, rules= %+v, err= %v", rules, err)
+	}
+	if want := []string{"https://www.example.com", "http://localhost:5173"}; !reflect.DeepEqual(rules[0].AllowedOrigins, want) {
+		t.Fatalf("来源不对: %v", rules[0].AllowedOrigins)
+	}
+
+	if err = basics.BucketCorsPut(ctx, testBucket, rules); err != nil {
+		t.Fatalf("设置跨域规则失败: %v", err)
+	}
+	got, err := basics.BucketCorsGet(ctx, testBucket)
+	if err != nil || !reflect.DeepEqual(got, rules) {
+		t.Fatalf("读回来的跨域规则不一样:\n got= %+v\nwant= %+v\n err= %v", got, rules, err)
+	}
+
+	if err = basics.BucketCorsDelete(ctx, testBucket); err != nil {
+		t.Fatalf("删除跨域规则失败: %v", err)
+	}
+	if got, err = basics.BucketCorsGet(ctx, testBucket); err != nil || len(got) != 0 {
+		t.Fatalf("删除后应该没有跨域规则, rules= %+v, err= %v", got, err)
+	}
+}
@@ -0,0 +1,196 @@
+// 功能: 凭证来源, 静态密钥 / 本地 profile / 环境变量 / web identity token 文件 / STS AssumeRole, 不用再把密钥写进 config.yaml
+package mys3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"study-aws-api-go/log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// 变量
+const (
+	CredentialSourceStatic      = "static"       // 静态密钥, config.yaml 里的 access_key_id/access_key_secret, 默认
+	CredentialSourceProfile     = "profile"      // 本地 ~/.aws/credentials 和 ~/.aws/config 里的 profile, 支持 sso/role_arn
+	CredentialSourceEnv         = "env"          // 环境变量 AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/AWS_SESSION_TOKEN
+	CredentialSourceWebIdentity = "web_identity" // web identity token 文件换临时凭证, 如 EKS IRSA、GitHub Actions OIDC
+	CredentialSourceAssumeRole  = "assume_role"  // 用源凭证 STS AssumeRole 换临时凭证, 跨账号访问用
+
+	defaultRoleSessionName  = "study-aws-api-go" // 没填会话名用这个, 在 CloudTrail 里能看到
+	credentialsExpiryWindow = 5 * time.Minute    // 临时凭证过期前5分钟就刷新, 不会用到快过期的
+)
+
+// 凭证选项
+/*
+说明:
+	static: AccessKeyId、SecretKey 必填
+	profile: Profile 不填用 default
+	env: 不用填
+	web_identity: RoleArn、WebIdentityTokenFile 不填用环境变量 AWS_ROLE_ARN、AWS_WEB_IDENTITY_TOKEN_FILE
+	assume_role: RoleArn 必填; 源凭证填了 Profile 用 profile, 填了 AccessKeyId 用静态密钥, 都没填用 aws 默认凭证链
+*/
+type CredentialOptions struct {
+	Source               string        // 来源: static(默认) / profile / env / web_identity / assume_role
+	AccessKeyId          string        // static: 访问密钥Id
+	SecretKey            string        // static: 访问密钥
+	SessionToken         string        // static: 临时凭证的token, 一般不填
+	Profile              string        // profile: profile 名字
+	RoleArn              string        // web_identity/assume_role: 角色 arn, 如 arn:aws:iam::123456789012:role/comic-reader
+	RoleSessionName      string        // web_identity/assume_role: 会话名, 不填默认 study-aws-api-go
+	WebIdentityTokenFile string        // web_identity: token 文件路径
+	ExternalId           string        // assume_role: 外部id, 对方账号的信任策略要求了才填
+	Duration             time.Duration // web_identity/assume_role: 临时凭证有效期, <=0 默认1小时, 最长看角色配置
+	STSEndpoint          string        // 自定义 STS 地址, 如 LocalStack/MinIO, 为空用 aws
+}
+
+// 按选项创建凭证提供者, 带缓存, 临时凭证快过期时自动刷新
+/*
+参数:
+	ctx context.Context : 上下文, 读取 profile 用
+	region string : 区域, STS 请求用
+	opts CredentialOptions : 凭证选项
+返回值:
+	aws.CredentialsProvider: 凭证提供者, 给 s3.Options.Credentials 用
+	error: 错误, 来源不对或者必填项没填
+*/
+func NewCredentialsProvider(ctx context.Context, region string, opts CredentialOptions) (aws.CredentialsProvider, error) {
+	source := strings.ToLower(opts.Source)
+	switch source {
+	case "", CredentialSourceStatic:
+		if opts.AccessKeyId == "" || opts.SecretKey == "" {
+			return nil, errors.New("静态凭证 access_key_id 和 access_key_secret 都要填")
+		}
+		log.Info("凭证来源: 静态密钥")
+		return aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(opts.AccessKeyId, opts.SecretKey, opts.SessionToken)), nil
+
+	case CredentialSourceProfile:
+		provider, err := profileCredentials(ctx, region, opts.Profile)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("凭证来源: profile %s", opts.Profile)
+		return provider, nil
+
+	case CredentialSourceEnv:
+		if _, err := (envCredentialsProvider{}).Retrieve(ctx); err != nil { // 先读一次, 没设置启动时就报错
+			return nil, err
+		}
+		log.Info("凭证来源: 环境变量")
+		return envCredentialsProvider{}, nil
+
+	case CredentialSourceWebIdentity:
+		envConfig, _ := config.NewEnvConfig()
+		roleArn := firstNonEmpty(opts.RoleArn, envConfig.RoleARN)
+		tokenFile := firstNonEmpty(opts.WebIdentityTokenFile, envConfig.WebIdentityTokenFilePath)
+		if roleArn == "" || tokenFile == "" {
+			return nil, errors.New("web_identity 凭证要填 role_arn 和 web_identity_token_file, 或设置环境变量 AWS_ROLE_ARN 和 AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+		client := sts.New(sts.Options{Region: region}, opts.stsEndpoint()) // AssumeRoleWithWebIdentity 不用签名, 不要凭证
+		provider := stscreds.NewWebIdentityRoleProvider(client, roleArn, stscreds.IdentityTokenFile(tokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = firstNonEmpty(opts.RoleSessionName, envConfig.RoleSessionName, defaultRoleSessionName)
+			o.Duration = opts.Duration
+		})
+		log.Infof("凭证来源: web identity, 角色 %s, token 文件 %s", roleArn, tokenFile)
+		return newTemporaryCredentialsCache(provider), nil
+
+	case CredentialSourceAssumeRole:
+		if opts.RoleArn == "" {
+			return nil, errors.New("assume_role 凭证要填 role_arn")
+		}
+		sourceProvider, err := opts.assumeRoleSource(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+		client := sts.New(sts.Options{Region: region, Credentials: sourceProvider}, opts.stsEndpoint())
+		provider := stscreds.NewAssumeRoleProvider(client, opts.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = firstNonEmpty(opts.RoleSessionName, defaultRoleSessionName)
+			o.Duration = opts.Duration
+			if opts.ExternalId != "" {
+				o.ExternalID = aws.String(opts.ExternalId)
+			}
+		})
+		log.Infof("凭证来源: assume role, 角色 %s", opts.RoleArn)
+		return newTemporaryCredentialsCache(provider), nil
+
+	default:
+		return nil, fmt.Errorf("凭证来源 %s 不对, 只支持 static/profile/env/web_identity/assume_role", opts.Source)
+	}
+}
+
+// assume_role 的源凭证: profile > 静态密钥 > aws 默认凭证链
+func (opts CredentialOptions) assumeRoleSource(ctx context.Context, region string) (aws.CredentialsProvider, error) {
+	switch {
+	case opts.Profile != "":
+		return profileCredentials(ctx, region, opts.Profile)
+	case opts.AccessKeyId != "":
+		return aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(opts.AccessKeyId, opts.SecretKey, opts.SessionToken)), nil
+	default:
+		sdkConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+		if err != nil {
+			return nil, fmt.Errorf("加载 aws 默认凭证链失败: %w", err)
+		}
+		return sdkConfig.Credentials, nil
+	}
+}
+
+// STS 客户端的地址, 没配置不改
+func (opts CredentialOptions) stsEndpoint() func(*sts.Options) {
+	return func(o *sts.Options) {
+		if opts.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(strings.TrimRight(opts.STSEndpoint, "/"))
+		}
+	}
+}
+
+// 环境变量凭证, 每次 Retrieve 都重新读环境变量, 轮换了密钥不用重启
+// 不套 aws.CredentialsCache, 静态凭证不会过期, 套了就只读一次
+type envCredentialsProvider struct{}
+
+func (envCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	envConfig, err := config.NewEnvConfig()
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("读取环境变量凭证失败: %w", err)
+	}
+	if !envConfig.Credentials.HasKeys() {
+		return aws.Credentials{}, errors.New("环境变量 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY 没设置")
+	}
+	return envConfig.Credentials, nil
+}
+
+// 本地 profile 的凭证, LoadDefaultConfig 返回的已经带缓存了
+func profileCredentials(ctx context.Context, region string, profile string) (aws.CredentialsProvider, error) {
+	optFns := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	if profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(profile))
+	}
+	sdkConfig, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return nil, fmt.Errorf("加载 profile %s 失败: %w", profile, err)
+	}
+	return sdkConfig.Credentials, nil
+}
+
+// 临时凭证缓存, 过期前 credentialsExpiryWindow 就刷新
+func newTemporaryCredentialsCache(provider aws.CredentialsProvider) *aws.CredentialsCache {
+	return aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsExpiryWindow
+	})
+}
+
+// 第一个不为空的
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package mys3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 假的 STS, 只实现 AssumeRole 和 AssumeRoleWithWebIdentity, 返回的临时凭证 expiresIn 后过期
+func newTestSTS(t *testing.T, expiresIn time.Duration, check func(form url.Values)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		check(r.PostForm)
+		n := calls.Add(1)
+		action := r.PostForm.Get("Action")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%[1]sResult><Credentials>
+<AccessKeyId>ASIATEST%[2]d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>%[3]s</Expiration></Credentials></%[1]sResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></%[1]sResponse>`,
+			action, n, time.Now().Add(expiresIn).UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestCredentialsStaticAndEnv(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewCredentialsProvider(ctx, "ap-northeast-1", CredentialOptions{AccessKeyId: "AKIA"}); err == nil {
+		t.Fatal("静态凭证没填密钥应该报错")
+	}
+	if _, err := NewCredentialsProvider(ctx, "ap-northeast-1", CredentialOptions{Source: "unknown"}); err == nil {
+		t.Fatal("不支持的凭证来源应该报错")
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	provider, err := NewCredentialsProvider(ctx, "ap-northeast-1", CredentialOptions{Source: CredentialSourceEnv})
+	if err != nil {
+		t.Fatalf("环境变量凭证失败: %v", err)
+	}
+	if creds, err := provider.Retrieve(ctx); err != nil || creds.AccessKeyID != "AKIAENV" {
+		t.Fatalf("环境变量凭证不对: %+v, err= %v", creds, err)
+	}
+
+	// 轮换了环境变量里的密钥, 不用重建就能拿到新的
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAROTATED")
+	if creds, err := provider.Retrieve(ctx); err != nil || creds.AccessKeyID != "AKIAROTATED" {
+		t.Fatalf("应该读到轮换后的环境变量凭证: %+v, err= %v", creds, err)
+	}
+}
+
+func TestCredentialsAssumeRole(t *testing.T) {
+	ctx := context.Background()
+	srv, calls := newTestSTS(t, time.Hour, func(form url.Values) {
+		if form.Get("RoleArn") != "arn:aws:iam::123456789012:role/comic-reader" || form.Get("ExternalId") != "comic" ||
+			form.Get("DurationSeconds") != "900" || form.Get("RoleSessionName") != defaultRoleSessionName {
+			t.Errorf("AssumeRole 参数不对: %v", form)
+		}
+	})
+	provider, err := NewCredentialsProvider(ctx, "ap-northeast-1", CredentialOptions{
+		Source:      CredentialSourceAssumeRole,
+		AccessKeyId: "AKIA",
+		SecretKey:   "secret",
+		RoleArn:     "arn:aws:iam::123456789012:role/comic-reader",
+		ExternalId:  "comic",
+		Duration:    15 * time.Minute,
+		STSEndpoint: srv.URL,
+	})
+	if err != nil {
+		t.Fatalf("创建 assume_role 凭证失败: %v", err)
+	}
+
+	// 没过期用缓存, 只调一次 STS
+	for range 2 {
+		if creds, err := provider.Retrieve(ctx); err != nil || creds.AccessKeyID != "ASIATEST1" || !creds.CanExpire {
+			t.Fatalf("临时凭证不对: %+v, err= %v", creds, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("凭证没过期不应该重新获取, 调用了 %d 次", calls.Load())
+	}
+}
+
+func TestCredentialsWebIdentityRefresh(t *testing.T) {
+	ctx := context.Background()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("oidc-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 2分钟后过期, 在提前刷新的5分钟内, 每次都要重新获取
+	srv, calls := newTestSTS(t, 2*time.Minute, func(form url.Values) {
+		if form.Get("WebIdentityToken") != "oidc-token" || form.Get("RoleSessionName") != "comic" {
+			t.Errorf("AssumeRoleWithWebIdentity 参数不对: %v", form)
+		}
+	})
+	provider, err := NewCredentialsProvider(ctx, "ap-northeast-1", CredentialOptions{
+		Source:               CredentialSourceWebIdentity,
+		RoleArn:              "arn:aws:iam::123456789012:role/comic-reader",
+		RoleSessionName:      "comic",
+		WebIdentityTokenFile: tokenFile,
+		STSEndpoint:          srv.URL,
+	})
+	if err != nil {
+		t.Fatalf("创建 web_identity 凭证失败: %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		if creds, err := provider.Retrieve(ctx); err != nil || creds.AccessKeyID != fmt.Sprintf("ASIATEST%d", i) {
+			t.Fatalf("第%d次获取的临时凭证不对: %+v, err= %v", i, creds, err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("快过期的凭证应该每次都刷新, 调用了 %d 次", calls.Load())
+	}
+}
@@ -0,0 +1,282 @@
+// 功能: 客户端信封加密, 数据离开本机前先用 AES-GCM 加密, 下载时自动解密, 全程流式不整个读进内存
+/*
+格式:
+	1. 每个对象随机生成一个数据密钥(32字节) 和 基础IV(12字节)
+	2. 数据按 64KB 分块, 每块单独 AES-GCM 加密, 密文块 = 明文块 + 16字节认证标签
+	   每块的 nonce = 基础IV 后8字节 XOR 块序号, 附加数据(AAD) 标记是不是最后一块, 防止被截断/调换顺序
+	3. 数据密钥用主密钥 AES-GCM 加密(包裹)后, 和基础IV一起存到对象元数据 x-amz-meta-cse-*
+	4. 主密钥从 config.yaml 或本地密钥文件读, 不上传到 s3
+*/
+package mys3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 变量
+const (
+	cseChunkSize = 64 * 1024 // 明文分块大小
+	cseAlgorithm = "AES256-GCM-CHUNKED"
+
+	// 对象元数据 key (sdk 返回时都是小写)
+	cseMetaKey       = "cse-key"        // base64(nonce + 包裹后的数据密钥)
+	cseMetaIV        = "cse-iv"         // base64(基础IV)
+	cseMetaAlgorithm = "cse-alg"        // 加密算法, 以后换格式用来区分
+	cseMetaChunkSize = "cse-chunk-size" // 明文分块大小
+)
+
+// 读取客户端加密主密钥
+/*
+参数:
+	key string : base64 编码的主密钥, config.yaml 的 aws_s3.cse_master_key, 优先用这个
+	keyFile string : 密钥文件路径, config.yaml 的 aws_s3.cse_master_key_file, 文件内容是 base64 或 32字节原始密钥
+返回值:
+	[]byte: 32字节主密钥, 两个都没配置时返回 nil, nil (不开客户端加密)
+	error: 错误
+*/
+func LoadMasterKey(key string, keyFile string) ([]byte, error) {
+	if key == "" && keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取主密钥文件 %s 失败: %w", keyFile, err)
+		}
+		if len(content) == 32 { // 原始密钥
+			return content, nil
+		}
+		key = strings.TrimSpace(string(content))
+	}
+	if key == "" {
+		return nil, nil
+	}
+	masterKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("主密钥不是合法的 base64: %w", err)
+	}
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("主密钥必须是32字节, 现在是 %d 字节", len(masterKey))
+	}
+	return masterKey, nil
+}
+
+// 对象元数据里有没有客户端加密信息
+func isClientEncrypted(metadata map[string]string) bool {
+	_, ok := metadata[cseMetaKey]
+	return ok
+}
+
+// 对象元数据里客户端加密的那几项 (数据密钥、IV 等), 复制时替换元数据也要带上, 不然就解不开了
+func cseMetadataOf(metadata map[string]string) map[string]string {
+	var cse map[string]string
+	for _, k := range []string{cseMetaKey, cseMetaIV, cseMetaAlgorithm, cseMetaChunkSize} {
+		if v, ok := metadata[k]; ok {
+			if cse == nil {
+				cse = map[string]string{}
+			}
+			cse[k] = v
+		}
+	}
+	return cse
+}
+
+// 加密 - 包装上传数据流
+/*
+参数:
+	masterKey []byte : 主密钥
+	src io.Reader : 明文数据流
+返回值:
+	io.Reader: 密文数据流, 交给上传
+	map[string]string: 要存到对象元数据里的信息
+	error: 错误
+*/
+func newEncryptReader(masterKey []byte, src io.Reader) (io.Reader, map[string]string, error) {
+	if len(masterKey) != 32 {
+		return nil, nil, errors.New("客户端加密失败, 没有配置主密钥 aws_s3.cse_master_key / cse_master_key_file")
+	}
+	dataKey := make([]byte, 32)
+	baseIV := make([]byte, 12)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(baseIV); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := wrapDataKey(masterKey, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata := map[string]string{
+		cseMetaKey:       base64.StdEncoding.EncodeToString(wrapped),
+		cseMetaIV:        base64.StdEncoding.EncodeToString(baseIV),
+		cseMetaAlgorithm: cseAlgorithm,
+		cseMetaChunkSize: strconv.Itoa(cseChunkSize),
+	}
+	return &cseReader{src: src, aead: aead, baseIV: baseIV, chunkSize: cseChunkSize, encrypt: true}, metadata, nil
+}
+
+// 解密 - 包装下载数据流
+/*
+参数:
+	masterKey []byte : 主密钥
+	src io.Reader : 密文数据流, 如 GetObject 的 Body
+	metadata map[string]string : 对象元数据, 有数据密钥和IV
+返回值:
+	io.Reader: 明文数据流, 读到最后一块才算完整, 被截断/篡改会返回错误
+	error: 错误
+*/
+func newDecryptReader(masterKey []byte, src io.Reader, metadata map[string]string) (io.Reader, error) {
+	if len(masterKey) != 32 {
+		return nil, errors.New("客户端解密失败, 对象是客户端加密的, 但没有配置主密钥")
+	}
+	if alg := metadata[cseMetaAlgorithm]; alg != cseAlgorithm {
+		return nil, fmt.Errorf("客户端解密失败, 不支持的加密算法 [%s]", alg)
+	}
+	chunkSize, err := strconv.Atoi(metadata[cseMetaChunkSize])
+	if err != nil || chunkSize <= 0 {
+		return nil, fmt.Errorf("客户端解密失败, 分块大小不对 [%s]", metadata[cseMetaChunkSize])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metadata[cseMetaKey])
+	if err != nil {
+		return nil, fmt.Errorf("客户端解密失败, 数据密钥不是合法的 base64: %w", err)
+	}
+	baseIV, err := base64.StdEncoding.DecodeString(metadata[cseMetaIV])
+	if err != nil || len(baseIV) != 12 {
+		return nil, errors.New("客户端解密失败, IV 不对")
+	}
+	dataKey, err := unwrapDataKey(masterKey, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &cseReader{src: src, aead: aead, baseIV: baseIV, chunkSize: chunkSize + aead.Overhead(), encrypt: false}, nil
+}
+
+// 用主密钥包裹数据密钥, 返回 nonce + 密文
+func wrapDataKey(masterKey []byte, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(cseAlgorithm)), nil
+}
+
+// 用主密钥解开数据密钥
+func unwrapDataKey(masterKey []byte, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("客户端解密失败, 数据密钥太短")
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(cseAlgorithm))
+	if err != nil {
+		return nil, errors.New("客户端解密失败, 解不开数据密钥, 主密钥不对")
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 分块加密/解密的数据流, 每次只缓存一块
+type cseReader struct {
+	src       io.Reader
+	aead      cipher.AEAD
+	baseIV    []byte
+	chunkSize int  // 每次从 src 读多少: 加密时是明文块大小, 解密时是密文块大小
+	encrypt   bool // true 加密, false 解密
+
+	counter uint64 // 块序号
+	buf     []byte // 从 src 读的数据, 多读1字节用来判断是不是最后一块
+	out     bytes.Buffer
+	done    bool
+	err     error
+}
+
+func (r *cseReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.nextChunk()
+	}
+	return r.out.Read(p)
+}
+
+// 处理下一块: 读 chunkSize+1 字节, 读满了说明后面还有, 多出来的1字节留给下一块
+func (r *cseReader) nextChunk() error {
+	if r.buf == nil {
+		r.buf = make([]byte, 0, r.chunkSize+1)
+	}
+	n, err := io.ReadFull(r.src, r.buf[len(r.buf):r.chunkSize+1])
+	r.buf = r.buf[:len(r.buf)+n]
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	}
+
+	chunk := r.buf
+	if !last {
+		chunk = r.buf[:r.chunkSize]
+	}
+	nonce := make([]byte, len(r.baseIV))
+	copy(nonce, r.baseIV)
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^r.counter)
+	aad := []byte{0}
+	if last {
+		aad[0] = 1
+	}
+
+	if r.encrypt {
+		r.out.Write(r.aead.Seal(nil, nonce, chunk, aad))
+	} else {
+		plain, err := r.aead.Open(nil, nonce, chunk, aad)
+		if err != nil {
+			return fmt.Errorf("客户端解密失败, 第 %d 块校验不通过, 数据被截断或篡改", r.counter)
+		}
+		r.out.Write(plain)
+	}
+
+	r.counter++
+	if last {
+		r.done = true
+		return nil
+	}
+	// 多读的1字节挪到开头, 给下一块用
+	r.buf[0] = r.buf[r.chunkSize]
+	r.buf = r.buf[:1]
+	return nil
+}
@@ -0,0 +1,210 @@
+// 功能: 服务端加密, 单次上传/下载/复制的加密选项 (SSE-S3、SSE-KMS、SSE-C) 和存储桶默认加密
+package mys3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"study-aws-api-go/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// 加密方式
+type EncryptionMode string
+
+const (
+	EncryptionNone   EncryptionMode = ""        // 不指定, 用存储桶默认加密
+	EncryptionSSES3  EncryptionMode = "SSE-S3"  // s3 管理的密钥, AES256
+	EncryptionSSEKMS EncryptionMode = "SSE-KMS" // KMS 密钥, 可以指定 KMSKeyId
+	EncryptionSSEC   EncryptionMode = "SSE-C"   // 客户自己提供密钥, s3 不保存密钥, 读/HEAD/复制都要带同一个密钥
+)
+
+const sseCustomerAlgorithm = "AES256" // SSE-C 只支持 AES256
+
+// 加密选项
+type Encryption struct {
+	Mode             EncryptionMode // 加密方式
+	KMSKeyId         string         // SSE-KMS 用, KMS 密钥id/arn/别名, 不填用 aws/s3 默认密钥
+	BucketKeyEnabled bool           // SSE-KMS 用, 开启存储桶密钥, 减少 KMS 调用次数
+	CustomerKey      []byte         // SSE-C 用, 32字节的原始密钥 (不是 base64), 丢了数据就找不回来了
+}
+
+// 检查加密选项是否合法
+func (enc Encryption) validate() error {
+	switch enc.Mode {
+	case EncryptionNone, EncryptionSSES3:
+	case EncryptionSSEKMS:
+	case EncryptionSSEC:
+		if len(enc.CustomerKey) != 32 {
+			return fmt.Errorf("SSE-C 密钥必须是32字节, 现在是 %d 字节", len(enc.CustomerKey))
+		}
+	default:
+		return fmt.Errorf("不支持的加密方式 %s", enc.Mode)
+	}
+	if enc.KMSKeyId != "" && enc.Mode != EncryptionSSEKMS {
+		return fmt.Errorf("KMSKeyId 只能和 SSE-KMS 一起用, 现在是 [%s]", enc.Mode)
+	}
+	return nil
+}
+
+// 服务端加密参数 (SSE-S3 / SSE-KMS), 写对象时用。SSE-C 和不指定时返回空
+func (enc Encryption) sse() (types.ServerSideEncryption, *string, *bool) {
+	switch enc.Mode {
+	case EncryptionSSES3:
+		return types.ServerSideEncryptionAes256, nil, nil
+	case EncryptionSSEKMS:
+		var keyId *string
+		if enc.KMSKeyId != "" {
+			keyId = aws.String(enc.KMSKeyId)
+		}
+		var bucketKey *bool
+		if enc.BucketKeyEnabled {
+			bucketKey = aws.Bool(true)
+		}
+		return types.ServerSideEncryptionAwsKms, keyId, bucketKey
+	}
+	return "", nil, nil
+}
+
+// SSE-C 参数: 算法, base64(密钥), base64(md5(密钥))。v2 sdk 不会帮我们编码, 要自己算。不是 SSE-C 返回 nil
+func (enc Encryption) sseC() (*string, *string, *string) {
+	if enc.Mode != EncryptionSSEC {
+		return nil, nil, nil
+	}
+	sum := md5.Sum(enc.CustomerKey)
+	return aws.String(sseCustomerAlgorithm),
+		aws.String(base64.StdEncoding.EncodeToString(enc.CustomerKey)),
+		aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// 从 HEAD 结果拿对象的加密方式, 复制到自己身上时要原样带上, 不然会变成存储桶默认加密。SSE-C 只能拿到 Mode, 密钥要调用方给
+func encryptionFromHead(head *s3.HeadObjectOutput) Encryption {
+	switch {
+	case head.SSECustomerAlgorithm != nil:
+		return Encryption{Mode: EncryptionSSEC}
+	case head.ServerSideEncryption == types.ServerSideEncryptionAwsKms:
+		return Encryption{Mode: EncryptionSSEKMS, KMSKeyId: aws.ToString(head.SSEKMSKeyId), BucketKeyEnabled: aws.ToBool(head.BucketKeyEnabled)}
+	case head.ServerSideEncryption == types.ServerSideEncryptionAes256:
+		return Encryption{Mode: EncryptionSSES3}
+	}
+	return Encryption{}
+}
+
+// 设置到 PutObjectInput 上, 传输管理器分段上传时会带到 CreateMultipartUpload/UploadPart/CompleteMultipartUpload
+func (enc Encryption) applyPut(input *s3.PutObjectInput) {
+	input.ServerSideEncryption, input.SSEKMSKeyId, input.BucketKeyEnabled = enc.sse()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = enc.sseC()
+}
+
+// 设置到 GetObjectInput 上, 只有 SSE-C 要带密钥, SSE-S3/SSE-KMS 读的时候 s3 自动解密
+func (enc Encryption) applyGet(input *s3.GetObjectInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = enc.sseC()
+}
+
+// 设置到 HeadObjectInput 上, SSE-C 对象不带密钥 HEAD 会报 400
+func (enc Encryption) applyHead(input *s3.HeadObjectInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = enc.sseC()
+}
+
+// 查 - 存储桶默认加密
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	*Encryption: 默认加密, 没有配置时 Mode 为 EncryptionNone (2023年后新桶默认都是 SSE-S3)
+	error: 错误
+*/
+func (basics BucketBasics) BucketEncryptionGet(ctx context.Context, bucketName string) (*Encryption, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ServerSideEncryptionConfigurationNotFoundError" {
+			log.Debugf("存储桶 %s 没有默认加密", bucketName)
+			return &Encryption{}, nil
+		}
+		log.Errorf("查询存储桶 %s 默认加密失败, err= %v", bucketName, err)
+		return nil, err
+	}
+
+	enc := &Encryption{}
+	if output.ServerSideEncryptionConfiguration == nil {
+		return enc, nil
+	}
+	for _, rule := range output.ServerSideEncryptionConfiguration.Rules {
+		if rule.ApplyServerSideEncryptionByDefault == nil {
+			continue
+		}
+		switch rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm {
+		case types.ServerSideEncryptionAes256:
+			enc.Mode = EncryptionSSES3
+		case types.ServerSideEncryptionAwsKms, types.ServerSideEncryptionAwsKmsDsse:
+			enc.Mode = EncryptionSSEKMS
+			enc.KMSKeyId = aws.ToString(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)
+		}
+		enc.BucketKeyEnabled = aws.ToBool(rule.BucketKeyEnabled)
+	}
+	log.Debugf("存储桶 %s 默认加密: %s, kms= %s, bucketKey= %v", bucketName, enc.Mode, enc.KMSKeyId, enc.BucketKeyEnabled)
+	return enc, nil
+}
+
+// 改 - 设置存储桶默认加密, 之后上传没指定加密的对象都按这个加密
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	enc Encryption : 只能是 SSE-S3 或 SSE-KMS, SSE-C 不能做默认加密
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketEncryptionPut(ctx context.Context, bucketName string, enc Encryption) error {
+	if err := enc.validate(); err != nil {
+		return err
+	}
+	if enc.Mode != EncryptionSSES3 && enc.Mode != EncryptionSSEKMS {
+		return fmt.Errorf("设置存储桶默认加密失败, 只支持 SSE-S3 和 SSE-KMS, 现在是 [%s]", enc.Mode)
+	}
+	algorithm, keyId, _ := enc.sse()
+	_, err := basics.clientFor(ctx, bucketName).PutBucketEncryption(ctx, &s3.PutBucketEncryptionInput{
+		Bucket: aws.String(bucketName),
+		ServerSideEncryptionConfiguration: &types.ServerSideEncryptionConfiguration{
+			Rules: []types.ServerSideEncryptionRule{{
+				ApplyServerSideEncryptionByDefault: &types.ServerSideEncryptionByDefault{
+					SSEAlgorithm:   algorithm,
+					KMSMasterKeyID: keyId,
+				},
+				BucketKeyEnabled: aws.Bool(enc.BucketKeyEnabled),
+			}},
+		},
+	})
+	if err != nil {
+		log.Errorf("设置存储桶 %s 默认加密 %s 失败, err= %v", bucketName, enc.Mode, err)
+		return err
+	}
+	log.Infof("设置存储桶 %s 默认加密 %s 成功", bucketName, enc.Mode)
+	return nil
+}
+
+// 删 - 删除存储桶默认加密, 之后 s3 按 SSE-S3 加密
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketEncryptionDelete(ctx context.Context, bucketName string) error {
+	_, err := basics.clientFor(ctx, bucketName).DeleteBucketEncryption(ctx, &s3.DeleteBucketEncryptionInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		log.Errorf("删除存储桶 %s 默认加密失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("删除存储桶 %s 默认加密成功", bucketName)
+	return nil
+}
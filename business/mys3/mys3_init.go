@@ -2,10 +2,10 @@ package mys3
 
 import (
 	"context"
-	"study-aws-api-go/errorutil"
+	"strings"
+	"study-aws-api-go/log"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -13,21 +13,26 @@ import (
 
 // 变量
 type BucketBasics struct {
-	S3Client  *s3.Client
-	S3Manager *manager.Uploader
+	S3Client     *s3.Client
+	S3Manager    *manager.Uploader
+	S3Downloader *manager.Downloader // 分段并发下载用
+	CSEMasterKey []byte              // 客户端加密主密钥, 32字节, 用 LoadMasterKey 读取, 不用客户端加密可以为空
+	Registry     *ClientRegistry     // 多区域客户端, 按存储桶所在区域选客户端; 为空所有操作都用 S3Client
+	Account      string              // Registry 里的账号, 为空用默认账号
 }
 
-// 生成s3客户端,用New方式
+// 生成s3客户端,用New方式, 静态密钥; 凭证来源要配置用 NewCredentialsProvider + ClientRegistry
 // 参数
 // - area string s3所在区域，如ap-northeast-1 ->  日本 东京
 // - accesKeyId string s3访问密钥Id
 // - secretKey string s3访问密钥
 // - sessionToken string s3 session 的token (可选) 一般不填，一般默认""
+// - optFns ...func(*s3.Options) 可选, 修改客户端配置, 如 WithEndpoint 连本地的 MinIO
 // 思路：
 // - 初始化aws s3配置
 // - 初始化s3客户端
 // 返回 1 context 上下文 2 s3client s3客户端
-func InitS3Client(area, accessKeyId, secretKey, sessionToken string) (context.Context, *s3.Client) {
+func InitS3Client(area, accessKeyId, secretKey, sessionToken string, optFns ...func(*s3.Options)) (context.Context, *s3.Client) {
 	// 2. 初始化aws s3配置
 	ctx := context.Background()
 	options := s3.Options{
@@ -35,21 +40,35 @@ func InitS3Client(area, accessKeyId, secretKey, sessionToken string) (context.Co
 		Credentials: aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(accessKeyId, secretKey, sessionToken)), // 凭证
 	}
 
-	clinet := s3.New(options) // 创建s3客户端
+	clinet := s3.New(options, optFns...) // 创建s3客户端
 	return ctx, clinet
 }
 
-// 生成s3客户端,不用了,这样写有点麻烦
-// 思路：
-// 1. 初始化aws s3配置
-// 2. 初始化s3客户端
-func InitS3Client_NoUse() *s3.Client {
-	// 2. 初始化aws s3配置
-	ctx := context.Background()
-	sdkConfig, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		errorutil.ErrorPanic(err, "出错了,不能加载aws s3默认配置,你设置好aws账号了吗?")
+// 自定义 s3 地址, 连 MinIO/SeaweedFS/LocalStack 这些兼容 s3 的本地服务, 给开发和 CI 用
+// 参数
+// - endpoint string 地址, 如 http://127.0.0.1:9000 或 127.0.0.1:9000 (不带协议按 disableSSL 补), 为空用 aws 默认地址
+// - usePathStyle bool 路径风格 http://host/bucket/key, 本地服务一般都要开; false 是 aws 默认的 http://bucket.host/key
+// - disableSSL bool 用 http 不用 https
+// 返回 给 InitS3Client 的 optFns
+func WithEndpoint(endpoint string, usePathStyle bool, disableSSL bool) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.UsePathStyle = usePathStyle
+		if endpoint == "" {
+			o.EndpointOptions.DisableHTTPS = disableSSL
+			return
+		}
+		switch {
+		case strings.HasPrefix(endpoint, "http://"):
+		case strings.HasPrefix(endpoint, "https://"):
+			if disableSSL {
+				endpoint = "http://" + strings.TrimPrefix(endpoint, "https://")
+			}
+		case disableSSL:
+			endpoint = "http://" + endpoint
+		default:
+			endpoint = "https://" + endpoint
+		}
+		o.BaseEndpoint = aws.String(strings.TrimRight(endpoint, "/"))
+		log.Infof("s3 地址: %s, 路径风格: %v", endpoint, usePathStyle)
 	}
-	s3Client := s3.NewFromConfig(sdkConfig) // 创建s3客户端
-	return s3Client
 }
@@ -0,0 +1,35 @@
+package mys3
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestWithEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint     string
+		disableSSL   bool
+		wantEndpoint string // 为空表示用 aws 默认地址
+	}{
+		{"http://127.0.0.1:9000", false, "http://127.0.0.1:9000"}, // 写了 http 就用 http
+		{"http://127.0.0.1:9000", true, "http://127.0.0.1:9000"},
+		{"https://minio.example.com/", false, "https://minio.example.com"}, // 结尾的 / 去掉
+		{"https://minio.example.com", true, "http://minio.example.com"},    // disableSSL 把 https 改成 http
+		{"127.0.0.1:9000", false, "https://127.0.0.1:9000"},                // 不带协议按 disableSSL 补
+		{"127.0.0.1:9000", true, "http://127.0.0.1:9000"},
+		{"", false, ""},
+		{"", true, ""},
+	}
+	for _, tt := range tests {
+		var o s3.Options
+		WithEndpoint(tt.endpoint, true, tt.disableSSL)(&o)
+		if got := aws.ToString(o.BaseEndpoint); got != tt.wantEndpoint || !o.UsePathStyle {
+			t.Errorf("WithEndpoint(%q, true, %v): BaseEndpoint= %q, UsePathStyle= %v, 应该是 %q", tt.endpoint, tt.disableSSL, got, o.UsePathStyle, tt.wantEndpoint)
+		}
+		if tt.endpoint == "" && o.EndpointOptions.DisableHTTPS != tt.disableSSL {
+			t.Errorf("WithEndpoint(%q, true, %v): 用 aws 默认地址时 DisableHTTPS 应该是 %v", tt.endpoint, tt.disableSSL, tt.disableSSL)
+		}
+	}
+}
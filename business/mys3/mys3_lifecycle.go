@@ -0,0 +1,300 @@
+// 功能: 存储桶生命周期规则的增删改查, 让旧订单凭证、临时上传自动过期/转冷存储
+package mys3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"study-aws-api-go/log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// 变量
+var ErrInvalidLifecycleRule = errors.New("生命周期规则不对") // 规则校验没通过, 没有发给 s3, 接口返回 400 用
+
+// 生命周期规则, 比 types.LifecycleRule 好用, 也能直接给前端 json 用
+/*
+说明:
+	s3 上的规则读出来再原样写回去不会丢设置, 查出来改一下再整个替换是安全的
+*/
+type LifecycleRule struct {
+	ID                    string            `json:"id"`                    // 规则id, 不填 s3 自动生成
+	Enabled               bool              `json:"enabled"`               // 是否启用
+	Prefix                string            `json:"prefix"`                // 过滤: 前缀, 如 "tmp/", 不填表示全部
+	Tags                  map[string]string `json:"tags"`                  // 过滤: 标签, 要全部匹配
+	ObjectSizeGreaterThan int64             `json:"objectSizeGreaterThan"` // 过滤: 对象大于多少字节, 0 不限
+	ObjectSizeLessThan    int64             `json:"objectSizeLessThan"`    // 过滤: 对象小于多少字节, 0 不限
+
+	ExpirationDays               int32                           `json:"expirationDays"`               // 创建多少天后过期删除, 0 不设置
+	ExpirationDate               *time.Time                      `json:"expirationDate,omitempty"`     // 到这一天过期删除 (UTC 零点), 和 ExpirationDays 二选一
+	ExpiredObjectDeleteMarker    bool                            `json:"expiredObjectDeleteMarker"`    // 删掉没有历史版本的删除标记, 不能和过期天数/日期、标签过滤一起用
+	NoncurrentExpirationDays     int32                           `json:"noncurrentExpirationDays"`     // 变成历史版本多少天后删除, 0 不设置
+	NoncurrentNewerVersions      int32                           `json:"noncurrentNewerVersions"`      // 历史版本过期时保留最新的几个, 0 不保留
+	Transitions                  []LifecycleTransition           `json:"transitions"`                  // 创建多少天后转存储类型
+	NoncurrentTransitions        []LifecycleNoncurrentTransition `json:"noncurrentTransitions"`        // 变成历史版本多少天后转存储类型
+	AbortIncompleteMultipartDays int32                           `json:"abortIncompleteMultipartDays"` // 未完成的分段上传多少天后清理, 0 不设置
+}
+
+// 转存储类型
+type LifecycleTransition struct {
+	Days         int32                        `json:"days"`           // 创建多少天后
+	Date         *time.Time                   `json:"date,omitempty"` // 到这一天转 (UTC 零点), 填了就不看 Days
+	StorageClass types.TransitionStorageClass `json:"storageClass"`   // STANDARD_IA / INTELLIGENT_TIERING / GLACIER_IR / GLACIER / DEEP_ARCHIVE
+}
+
+// 历史版本转存储类型
+type LifecycleNoncurrentTransition struct {
+	Days          int32                        `json:"days"`          // 变成历史版本多少天后
+	NewerVersions int32                        `json:"newerVersions"` // 保留最新的几个历史版本不转, 0 不保留
+	StorageClass  types.TransitionStorageClass `json:"storageClass"`  // 同 LifecycleTransition
+}
+
+// 查 - 存储桶的生命周期规则
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	[]LifecycleRule: 规则, 没有配置时为空
+	error: 错误
+*/
+func (basics BucketBasics) BucketLifecycleGet(ctx context.Context, bucketName string) ([]LifecycleRule, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+			log.Debugf("存储桶 %s 没有生命周期规则", bucketName)
+			return []LifecycleRule{}, nil
+		}
+		log.Errorf("查询存储桶 %s 生命周期规则失败, err= %v", bucketName, err)
+		return nil, err
+	}
+
+	rules := make([]LifecycleRule, 0, len(output.Rules))
+	for _, rule := range output.Rules {
+		rules = append(rules, lifecycleRuleFromTypes(rule))
+	}
+	return rules, nil
+}
+
+// 改 - 设置存储桶的生命周期规则, 会整个替换原来的规则
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	rules []LifecycleRule : 规则, 最多1000条
+返回值:
+	error: 错误, 规则校验没通过是 ErrInvalidLifecycleRule
+*/
+func (basics BucketBasics) BucketLifecyclePut(ctx context.Context, bucketName string, rules []LifecycleRule) error {
+	if len(rules) == 0 {
+		return fmt.Errorf("设置生命周期规则失败, 规则为空, 要删除全部规则请用 BucketLifecycleDelete: %w", ErrInvalidLifecycleRule)
+	}
+	typeRules := make([]types.LifecycleRule, 0, len(rules))
+	for i, rule := range rules {
+		typeRule, err := rule.toTypes()
+		if err != nil {
+			return fmt.Errorf("设置生命周期规则失败, 第 %d 条规则 [%s]: %w: %w", i+1, rule.ID, ErrInvalidLifecycleRule, err)
+		}
+		typeRules = append(typeRules, typeRule)
+	}
+
+	_, err := basics.clientFor(ctx, bucketName).PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucketName),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: typeRules},
+	})
+	if err != nil {
+		log.Errorf("设置存储桶 %s 生命周期规则失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("设置存储桶 %s 生命周期规则成功, 共 %d 条", bucketName, len(rules))
+	return nil
+}
+
+// 删 - 删除存储桶的所有生命周期规则
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketLifecycleDelete(ctx context.Context, bucketName string) error {
+	_, err := basics.clientFor(ctx, bucketName).DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		log.Errorf("删除存储桶 %s 生命周期规则失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("删除存储桶 %s 生命周期规则成功", bucketName)
+	return nil
+}
+
+// LifecycleRule -> types.LifecycleRule
+func (rule LifecycleRule) toTypes() (types.LifecycleRule, error) {
+	expires := rule.ExpirationDays > 0 || rule.ExpirationDate != nil
+	if !expires && !rule.ExpiredObjectDeleteMarker && rule.NoncurrentExpirationDays <= 0 && len(rule.Transitions) == 0 &&
+		len(rule.NoncurrentTransitions) == 0 && rule.AbortIncompleteMultipartDays <= 0 {
+		return types.LifecycleRule{}, errors.New("至少要设置一个动作: 过期、删除过期的删除标记、历史版本过期、转存储类型、清理分段上传")
+	}
+	if rule.ExpirationDays > 0 && rule.ExpirationDate != nil {
+		return types.LifecycleRule{}, errors.New("过期天数和过期日期只能填一个")
+	}
+	if rule.ExpiredObjectDeleteMarker && expires {
+		return types.LifecycleRule{}, errors.New("删除过期的删除标记不能和过期天数/日期一起用")
+	}
+	if (rule.AbortIncompleteMultipartDays > 0 || rule.ExpiredObjectDeleteMarker) && len(rule.Tags) > 0 {
+		return types.LifecycleRule{}, errors.New("清理分段上传、删除过期的删除标记不能和标签过滤一起用")
+	}
+
+	typeRule := types.LifecycleRule{
+		Status: types.ExpirationStatusDisabled,
+		Filter: rule.filter(),
+	}
+	if rule.ID != "" {
+		typeRule.ID = aws.String(rule.ID)
+	}
+	if rule.Enabled {
+		typeRule.Status = types.ExpirationStatusEnabled
+	}
+	switch {
+	case rule.ExpirationDays > 0:
+		typeRule.Expiration = &types.LifecycleExpiration{Days: aws.Int32(rule.ExpirationDays)}
+	case rule.ExpirationDate != nil:
+		typeRule.Expiration = &types.LifecycleExpiration{Date: aws.Time(rule.ExpirationDate.UTC())}
+	case rule.ExpiredObjectDeleteMarker:
+		typeRule.Expiration = &types.LifecycleExpiration{ExpiredObjectDeleteMarker: aws.Bool(true)}
+	}
+	if rule.NoncurrentExpirationDays > 0 {
+		typeRule.NoncurrentVersionExpiration = &types.NoncurrentVersionExpiration{NoncurrentDays: aws.Int32(rule.NoncurrentExpirationDays)}
+		if rule.NoncurrentNewerVersions > 0 {
+			typeRule.NoncurrentVersionExpiration.NewerNoncurrentVersions = aws.Int32(rule.NoncurrentNewerVersions)
+		}
+	}
+	for _, transition := range rule.Transitions {
+		if transition.StorageClass == "" {
+			return types.LifecycleRule{}, errors.New("转存储类型没填 storageClass")
+		}
+		typeTransition := types.Transition{StorageClass: transition.StorageClass}
+		if transition.Date != nil {
+			typeTransition.Date = aws.Time(transition.Date.UTC())
+		} else {
+			typeTransition.Days = aws.Int32(transition.Days)
+		}
+		typeRule.Transitions = append(typeRule.Transitions, typeTransition)
+	}
+	for _, transition := range rule.NoncurrentTransitions {
+		if transition.StorageClass == "" {
+			return types.LifecycleRule{}, errors.New("历史版本转存储类型没填 storageClass")
+		}
+		typeTransition := types.NoncurrentVersionTransition{
+			NoncurrentDays: aws.Int32(transition.Days),
+			StorageClass:   transition.StorageClass,
+		}
+		if transition.NewerVersions > 0 {
+			typeTransition.NewerNoncurrentVersions = aws.Int32(transition.NewerVersions)
+		}
+		typeRule.NoncurrentVersionTransitions = append(typeRule.NoncurrentVersionTransitions, typeTransition)
+	}
+	if rule.AbortIncompleteMultipartDays > 0 {
+		typeRule.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(rule.AbortIncompleteMultipartDays)}
+	}
+	return typeRule, nil
+}
+
+// 拼过滤条件: 只有一个条件 (前缀/一个标签/大小) 直接用, 多个条件要用 And; 没有条件是前缀为空
+func (rule LifecycleRule) filter() *types.LifecycleRuleFilter {
+	tagSet := make([]types.Tag, 0, len(rule.Tags))
+	for k, v := range rule.Tags {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	var greaterThan, lessThan *int64
+	if rule.ObjectSizeGreaterThan > 0 {
+		greaterThan = aws.Int64(rule.ObjectSizeGreaterThan)
+	}
+	if rule.ObjectSizeLessThan > 0 {
+		lessThan = aws.Int64(rule.ObjectSizeLessThan)
+	}
+	conditions := len(tagSet)
+	for _, set := range []bool{rule.Prefix != "", greaterThan != nil, lessThan != nil} {
+		if set {
+			conditions++
+		}
+	}
+
+	switch {
+	case conditions == 0:
+		return &types.LifecycleRuleFilter{Prefix: aws.String("")}
+	case conditions > 1:
+		and := &types.LifecycleRuleAndOperator{Tags: tagSet, ObjectSizeGreaterThan: greaterThan, ObjectSizeLessThan: lessThan}
+		if rule.Prefix != "" {
+			and.Prefix = aws.String(rule.Prefix)
+		}
+		return &types.LifecycleRuleFilter{And: and}
+	case len(tagSet) == 1:
+		return &types.LifecycleRuleFilter{Tag: &tagSet[0]}
+	case rule.Prefix != "":
+		return &types.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)}
+	default:
+		return &types.LifecycleRuleFilter{ObjectSizeGreaterThan: greaterThan, ObjectSizeLessThan: lessThan}
+	}
+}
+
+// types.LifecycleRule -> LifecycleRule
+func lifecycleRuleFromTypes(typeRule types.LifecycleRule) LifecycleRule {
+	rule := LifecycleRule{
+		ID:      aws.ToString(typeRule.ID),
+		Enabled: typeRule.Status == types.ExpirationStatusEnabled,
+		Prefix:  aws.ToString(typeRule.Prefix), // 老的写法, 前缀直接写在规则上
+		Tags:    map[string]string{},
+	}
+	if filter := typeRule.Filter; filter != nil {
+		if filter.Prefix != nil {
+			rule.Prefix = *filter.Prefix
+		}
+		if filter.Tag != nil {
+			rule.Tags[aws.ToString(filter.Tag.Key)] = aws.ToString(filter.Tag.Value)
+		}
+		rule.ObjectSizeGreaterThan = aws.ToInt64(filter.ObjectSizeGreaterThan)
+		rule.ObjectSizeLessThan = aws.ToInt64(filter.ObjectSizeLessThan)
+		if filter.And != nil {
+			rule.Prefix = aws.ToString(filter.And.Prefix)
+			rule.Tags = tagsToMap(filter.And.Tags)
+			rule.ObjectSizeGreaterThan = aws.ToInt64(filter.And.ObjectSizeGreaterThan)
+			rule.ObjectSizeLessThan = aws.ToInt64(filter.And.ObjectSizeLessThan)
+		}
+	}
+	if expiration := typeRule.Expiration; expiration != nil {
+		rule.ExpirationDays = aws.ToInt32(expiration.Days)
+		rule.ExpirationDate = expiration.Date
+		rule.ExpiredObjectDeleteMarker = aws.ToBool(expiration.ExpiredObjectDeleteMarker)
+	}
+	if typeRule.NoncurrentVersionExpiration != nil {
+		rule.NoncurrentExpirationDays = aws.ToInt32(typeRule.NoncurrentVersionExpiration.NoncurrentDays)
+		rule.NoncurrentNewerVersions = aws.ToInt32(typeRule.NoncurrentVersionExpiration.NewerNoncurrentVersions)
+	}
+	for _, transition := range typeRule.Transitions {
+		rule.Transitions = append(rule.Transitions, LifecycleTransition{
+			Days:         aws.ToInt32(transition.Days),
+			Date:         transition.Date,
+			StorageClass: transition.StorageClass,
+		})
+	}
+	for _, transition := range typeRule.NoncurrentVersionTransitions {
+		rule.NoncurrentTransitions = append(rule.NoncurrentTransitions, LifecycleNoncurrentTransition{
+			Days:          aws.ToInt32(transition.NoncurrentDays),
+			NewerVersions: aws.ToInt32(transition.NewerNoncurrentVersions),
+			StorageClass:  transition.StorageClass,
+		})
+	}
+	if typeRule.AbortIncompleteMultipartUpload != nil {
+		rule.AbortIncompleteMultipartDays = aws.ToInt32(typeRule.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+	}
+	return rule
+}
@@ -0,0 +1,114 @@
+package mys3
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestBucketLifecycle(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	if rules, err := basics.BucketLifecycleGet(ctx, testBucket); err != nil || len(rules) != 0 {
+		t.Fatalf("没配置过应该没有规则, rules= %+v, err= %v", rules, err)
+	}
+
+	// 没有动作的规则不能设置
+	if err := basics.BucketLifecyclePut(ctx, testBucket, []LifecycleRule{{ID: "empty", Enabled: true}}); !errors.Is(err, ErrInvalidLifecycleRule) {
+		t.Fatalf("没有动作的规则应该返回 ErrInvalidLifecycleRule, err= %v", err)
+	}
+
+	rules := []LifecycleRule{
+		{ID: "tmp", Enabled: true, Prefix: "tmp/", ExpirationDays: 1, AbortIncompleteMultipartDays: 1},
+		{
+			ID:          "orders",
+			Enabled:     false,
+			Prefix:      "orders/",
+			Tags:        map[string]string{"type": "receipt"},
+			Transitions: []LifecycleTransition{{Days: 30, StorageClass: types.TransitionStorageClassStandardIa}},
+		},
+		{ID: "tagged", Enabled: true, Tags: map[string]string{"temp": "true"}, NoncurrentExpirationDays: 7},
+	}
+	if err := basics.BucketLifecyclePut(ctx, testBucket, rules); err != nil {
+		t.Fatalf("设置生命周期规则失败: %v", err)
+	}
+	rules[0].Tags = map[string]string{} // 读回来的没有标签时是空 map
+	got, err := basics.BucketLifecycleGet(ctx, testBucket)
+	if err != nil || !reflect.DeepEqual(got, rules) {
+		t.Fatalf("读回来的规则不一样:\n got= %+v\nwant= %+v\n err= %v", got, rules, err)
+	}
+
+	if err = basics.BucketLifecycleDelete(ctx, testBucket); err != nil {
+		t.Fatalf("删除生命周期规则失败: %v", err)
+	}
+	if got, err = basics.BucketLifecycleGet(ctx, testBucket); err != nil || len(got) != 0 {
+		t.Fatalf("删除后应该没有规则, rules= %+v, err= %v", got, err)
+	}
+}
+
+// 别的工具设置的规则, 查出来再原样写回去不能丢设置
+func TestBucketLifecycleRoundTrip(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := basics.S3Client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(testBucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{
+			{
+				ID:         aws.String("delete-marker"),
+				Status:     types.ExpirationStatusEnabled,
+				Filter:     &types.LifecycleRuleFilter{Prefix: aws.String("")},
+				Expiration: &types.LifecycleExpiration{ExpiredObjectDeleteMarker: aws.Bool(true)},
+			},
+			{
+				ID:     aws.String("big-old-files"),
+				Status: types.ExpirationStatusEnabled,
+				Filter: &types.LifecycleRuleFilter{And: &types.LifecycleRuleAndOperator{
+					Prefix:                aws.String("comic/"),
+					ObjectSizeGreaterThan: aws.Int64(1024),
+					ObjectSizeLessThan:    aws.Int64(1024 * 1024),
+				}},
+				Expiration:                  &types.LifecycleExpiration{Date: aws.Time(date)},
+				Transitions:                 []types.Transition{{Date: aws.Time(date.AddDate(-1, 0, 0)), StorageClass: types.TransitionStorageClassGlacier}},
+				NoncurrentVersionExpiration: &types.NoncurrentVersionExpiration{NoncurrentDays: aws.Int32(30), NewerNoncurrentVersions: aws.Int32(3)},
+				NoncurrentVersionTransitions: []types.NoncurrentVersionTransition{
+					{NoncurrentDays: aws.Int32(7), NewerNoncurrentVersions: aws.Int32(1), StorageClass: types.TransitionStorageClassStandardIa},
+				},
+			},
+			{
+				ID:         aws.String("small-files"),
+				Status:     types.ExpirationStatusDisabled,
+				Filter:     &types.LifecycleRuleFilter{ObjectSizeLessThan: aws.Int64(128)},
+				Expiration: &types.LifecycleExpiration{Days: aws.Int32(1)},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("设置生命周期规则失败: %v", err)
+	}
+
+	rules, err := basics.BucketLifecycleGet(ctx, testBucket)
+	if err != nil || len(rules) != 3 {
+		t.Fatalf("查询生命周期规则失败, rules= %+v, err= %v", rules, err)
+	}
+	marker, big, small := rules[0], rules[1], rules[2]
+	if !marker.ExpiredObjectDeleteMarker ||
+		big.ObjectSizeGreaterThan != 1024 || big.ObjectSizeLessThan != 1024*1024 || big.ExpirationDate == nil || !big.ExpirationDate.Equal(date) ||
+		len(big.Transitions) != 1 || big.Transitions[0].Date == nil || big.NoncurrentNewerVersions != 3 ||
+		len(big.NoncurrentTransitions) != 1 || big.NoncurrentTransitions[0].NewerVersions != 1 ||
+		small.ObjectSizeLessThan != 128 {
+		t.Fatalf("读出来的规则丢了设置: %+v", rules)
+	}
+
+	// 原样写回去再查, 应该一模一样
+	if err = basics.BucketLifecyclePut(ctx, testBucket, rules); err != nil {
+		t.Fatalf("写回生命周期规则失败: %v", err)
+	}
+	got, err := basics.BucketLifecycleGet(ctx, testBucket)
+	if err != nil || !reflect.DeepEqual(got, rules) {
+		t.Fatalf("写回后规则变了:\n got= %+v\nwant= %+v\n err= %v", got, rules, err)
+	}
+}
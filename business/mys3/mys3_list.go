@@ -0,0 +1,141 @@
+// 功能: 按前缀/分隔符分页查询对象, 适合对象很多的存储桶
+package mys3
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"study-aws-api-go/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 变量
+const maxListKeys = 1000 // ListObjectsV2 一页最多 1000 个
+
+// 查询选项
+type ListOptions struct {
+	Prefix            string // 前缀, 如 "亲家四姊妹/"
+	Delimiter         string // 分隔符, 一般是 "/", 填了就会把下一级 "目录" 放进 CommonPrefixes
+	MaxKeys           int32  // 每页最多几个 (对象+目录一起算), <=0 或 >1000 按 1000
+	ContinuationToken string // 上一页返回的 NextContinuationToken, 第一页不填
+}
+
+// 一页查询结果
+type ListPage struct {
+	Objects               []types.Object // 对象
+	CommonPrefixes        []string       // 下一级 "目录", 只有填了 Delimiter 才有
+	NextContinuationToken string         // 下一页的 token, 没有下一页为 ""
+	IsTruncated           bool           // 是否还有下一页
+}
+
+// 迭代器里的一项, Object 和 Prefix 二选一
+type ListEntry struct {
+	Object *types.Object // 对象, 是目录时为 nil
+	Prefix string        // 目录, 是对象时为 ""
+}
+
+// 查 - 分页, 一次只查一页
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	opts ListOptions : 前缀、分隔符、每页个数、token
+返回值:
+	*ListPage: 一页结果, 有下一页时 NextContinuationToken 不为空
+	error: 错误
+*/
+func (basics BucketBasics) ObjectList(ctx context.Context, bucketName string, opts ListOptions) (*ListPage, error) {
+	output, err := basics.clientFor(ctx, bucketName).ListObjectsV2(ctx, opts.input(bucketName))
+	if err != nil {
+		var noBucket *types.NoSuchBucket
+		if errors.As(err, &noBucket) {
+			log.Errorf("存储桶bucekt %s 不存在", bucketName)
+			err = noBucket
+		}
+		log.Errorf("分页查询存储桶bucket %s 对象失败, prefix= %s. reason: %v", bucketName, opts.Prefix, err)
+		return nil, err
+	}
+	return newListPage(output), nil
+}
+
+// 查 - 迭代器, 自动翻页, 可以随时 break
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	opts ListOptions : 前缀、分隔符、每页个数、从哪个 token 开始
+返回值:
+	iter.Seq2[ListEntry, error]: 每次返回一个对象或目录; 出错时返回一次 error 然后结束
+使用方式：
+	for entry, err := range s3Basic.ObjectIter(ctx, "sexcomic", mys3.ListOptions{Prefix: "亲家四姊妹/", Delimiter: "/"}) {
+		if err != nil {
+			return err
+		}
+		if entry.Object == nil {
+			log.Info("目录: ", entry.Prefix)
+			continue
+		}
+		log.Info("对象: ", *entry.Object.Key)
+	}
+*/
+func (basics BucketBasics) ObjectIter(ctx context.Context, bucketName string, opts ListOptions) iter.Seq2[ListEntry, error] {
+	return func(yield func(ListEntry, error) bool) {
+		paginator := s3.NewListObjectsV2Paginator(basics.clientFor(ctx, bucketName), opts.input(bucketName))
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(ctx)
+			if err != nil {
+				log.Errorf("迭代查询存储桶bucket %s 对象失败, prefix= %s. reason: %v", bucketName, opts.Prefix, err)
+				yield(ListEntry{}, err)
+				return
+			}
+			for _, prefix := range output.CommonPrefixes {
+				if !yield(ListEntry{Prefix: aws.ToString(prefix.Prefix)}, nil) {
+					return
+				}
+			}
+			for i := range output.Contents {
+				if !yield(ListEntry{Object: &output.Contents[i]}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// 选项 -> ListObjectsV2Input
+func (opts ListOptions) input(bucketName string) *s3.ListObjectsV2Input {
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 || maxKeys > maxListKeys {
+		maxKeys = maxListKeys
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucketName),
+		MaxKeys: aws.Int32(maxKeys),
+	}
+	if opts.Prefix != "" {
+		input.Prefix = aws.String(opts.Prefix)
+	}
+	if opts.Delimiter != "" {
+		input.Delimiter = aws.String(opts.Delimiter)
+	}
+	if opts.ContinuationToken != "" {
+		input.ContinuationToken = aws.String(opts.ContinuationToken)
+	}
+	return input
+}
+
+// ListObjectsV2Output -> ListPage
+func newListPage(output *s3.ListObjectsV2Output) *ListPage {
+	page := &ListPage{
+		Objects:               output.Contents,
+		NextContinuationToken: aws.ToString(output.NextContinuationToken),
+		IsTruncated:           aws.ToBool(output.IsTruncated),
+	}
+	for _, prefix := range output.CommonPrefixes {
+		page.CommonPrefixes = append(page.CommonPrefixes, aws.ToString(prefix.Prefix))
+	}
+	return page
+}
@@ -0,0 +1,509 @@
+// 功能: 可断点续传的分段上传, 以及未完成的分段上传的查询、清理
+package mys3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"study-aws-api-go/log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 变量
+const (
+	maxUploadParts       = 10000            // s3 一个分段上传最多 10000 段
+	checkpointFileSuffix = ".s3upload.json" // 断点文件默认后缀, 和上传文件放在一起
+)
+
+// 断点续传上传选项
+type ResumableOptions struct {
+	PartSize       int64  // 每段大小(字节), <=0 用默认值 manager.DefaultUploadPartSize (5MB), 最小5MB
+	Concurrency    int    // 并发数, <=0 用默认值 manager.DefaultUploadConcurrency (5)
+	CheckpointFile string // 断点文件路径, 不填默认 上传文件名 + ".s3upload.json"
+}
+
+// 断点文件内容, json 格式存在磁盘上
+type uploadCheckpoint struct {
+	Bucket   string           `json:"bucket"`   // 存储桶
+	Key      string           `json:"key"`      // 对象key
+	FileName string           `json:"fileName"` // 本地文件
+	FileSize int64            `json:"fileSize"` // 本地文件大小, 变了就不能续传
+	ModTime  time.Time        `json:"modTime"`  // 本地文件修改时间, 变了就不能续传
+	PartSize int64            `json:"partSize"` // 每段大小
+	UploadId string           `json:"uploadId"` // 分段上传id
+	Parts    []checkpointPart `json:"parts"`    // 已经上传成功的分段
+
+	Options checkpointOptions `json:"options"` // 创建分段上传时用的上传选项, 变了就不能续传
+}
+
+// 断点文件里的上传选项, SSE-C 只存密钥的 md5, 不存密钥, 续传时要再传一次同一个密钥
+type checkpointOptions struct {
+	EncryptionMode     EncryptionMode     `json:"encryptionMode,omitempty"`
+	KMSKeyId           string             `json:"kmsKeyId,omitempty"`
+	BucketKeyEnabled   bool               `json:"bucketKeyEnabled,omitempty"`
+	CustomerKeyMD5     string             `json:"customerKeyMD5,omitempty"`
+	Metadata           map[string]string  `json:"metadata,omitempty"`
+	Tags               map[string]string  `json:"tags,omitempty"`
+	StorageClass       types.StorageClass `json:"storageClass,omitempty"`
+	ContentType        string             `json:"contentType,omitempty"`
+	CacheControl       string             `json:"cacheControl,omitempty"`
+	ContentDisposition string             `json:"contentDisposition,omitempty"`
+	ContentEncoding    string             `json:"contentEncoding,omitempty"`
+}
+
+// 上传选项 -> 断点文件里的上传选项, 空 map 当成 nil, 和从 json 读出来的一样
+func newCheckpointOptions(opt UploadOptions) checkpointOptions {
+	cpOpts := checkpointOptions{
+		EncryptionMode:     opt.Encryption.Mode,
+		KMSKeyId:           opt.Encryption.KMSKeyId,
+		BucketKeyEnabled:   opt.Encryption.BucketKeyEnabled,
+		StorageClass:       opt.StorageClass,
+		ContentType:        opt.ContentType,
+		CacheControl:       opt.CacheControl,
+		ContentDisposition: opt.ContentDisposition,
+		ContentEncoding:    opt.ContentEncoding,
+	}
+	if _, _, keyMD5 := opt.Encryption.sseC(); keyMD5 != nil {
+		cpOpts.CustomerKeyMD5 = *keyMD5
+	}
+	if len(opt.Metadata) > 0 {
+		cpOpts.Metadata = opt.Metadata
+	}
+	if len(opt.Tags) > 0 {
+		cpOpts.Tags = opt.Tags
+	}
+	return cpOpts
+}
+
+// 已经上传成功的一段
+type checkpointPart struct {
+	PartNumber    int32  `json:"partNumber"`
+	ETag          string `json:"etag"`
+	ChecksumCRC32 string `json:"checksumCRC32"`
+}
+
+// 上传 - 可断点续传的分段上传
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key (文件key).使用时 xx【objectKey】
+	uploadFileName string : 要上传的文件名。可以是相对路径/绝对路径，一般是绝对路径
+	opts ResumableOptions : 分段大小、并发数、断点文件路径
+	uploadOpts ...UploadOptions : 可选, 用到 Encryption 服务端加密、Metadata 元数据、Tags 标签、StorageClass 存储类型、内容相关http头;
+		分段大小和并发数以 opts 为准, 校验固定用 CRC32, 不支持 ClientEncrypt
+返回值:
+	*UploadResult: 上传结果
+	error: 错误。出错时断点文件会保留, 再调用一次同样的上传就从断点继续 (SSE-C 要传同一个密钥)
+说明:
+	上传选项存在断点文件里 (SSE-C 只存密钥的 md5), 续传时选项变了就取消旧的分段上传重新传
+思路:
+	1. 准备, 读取本地文件信息
+	2. 读取断点文件, 能续传就用 ListParts 核对已经传完的分段; 不能续传就新建分段上传
+	3. 并发上传剩下的分段, 每传完一段就写一次断点文件
+	4. 合并分段, 完成上传
+	5. 删除断点文件, 返回
+*/
+func (basics BucketBasics) ObjectUploadResumable(ctx context.Context, bucketName string, awsFileName string, uploadFileName string, opts ResumableOptions, uploadOpts ...UploadOptions) (*UploadResult, error) {
+	// 1. 准备, 读取本地文件信息
+	var uploadOpt UploadOptions
+	if len(uploadOpts) > 0 {
+		uploadOpt = uploadOpts[0]
+	}
+	if err := uploadOpt.Encryption.validate(); err != nil {
+		return nil, err
+	}
+	if uploadOpt.ClientEncrypt {
+		return nil, fmt.Errorf("断点续传上传 %s:%s 失败, 不支持客户端加密", bucketName, awsFileName)
+	}
+	cpOpts := newCheckpointOptions(uploadOpt)
+	file, err := os.Open(uploadFileName)
+	if err != nil {
+		log.Errorf("打开文件: %s 失败, err= %v", uploadFileName, err)
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	checkpointFile := opts.CheckpointFile
+	if checkpointFile == "" {
+		checkpointFile = uploadFileName + checkpointFileSuffix
+	}
+	partSize := resumablePartSize(info.Size(), opts.PartSize)
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = manager.DefaultUploadConcurrency
+	}
+
+	// 2. 读取断点文件, 判断能不能续传
+	cp, corrupt := loadCheckpoint(checkpointFile)
+	if corrupt || (cp != nil && (cp.Bucket != bucketName || cp.Key != awsFileName || cp.FileSize != info.Size() ||
+		!cp.ModTime.Equal(info.ModTime()) || cp.PartSize != partSize || !reflect.DeepEqual(cp.Options, cpOpts))) {
+		log.Infof("断点文件 %s 损坏或和本次上传不一致, 重新上传", checkpointFile)
+		basics.discardCheckpoint(ctx, checkpointFile, cp) // 旧的分段不取消会一直收存储费
+		cp = nil
+	}
+	if cp != nil {
+		cp.Parts, err = basics.listUploadedParts(ctx, cp, uploadOpt.Encryption)
+		if err != nil {
+			var noUpload *types.NoSuchUpload
+			if !errors.As(err, &noUpload) {
+				return nil, err
+			}
+			log.Infof("分段上传 %s 已经不存在了(可能被清理了), 重新上传", cp.UploadId)
+			basics.discardCheckpoint(ctx, checkpointFile, nil) // 分段上传已经没了, 不用取消
+			cp = nil
+		}
+	}
+	if cp == nil {
+		contentType := uploadOpt.ContentType
+		if contentType == "" {
+			contentType, _ = detectContentType(awsFileName, file, uploadOpt.ContentEncoding) // 文件能 Seek, 检测完还是原来的位置
+		}
+		createInput := &s3.CreateMultipartUploadInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(awsFileName),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+			ContentType:       aws.String(contentType),
+			Metadata:          uploadOpt.Metadata,
+			StorageClass:      uploadOpt.StorageClass,
+		}
+		if uploadOpt.CacheControl != "" {
+			createInput.CacheControl = aws.String(uploadOpt.CacheControl)
+		}
+		if uploadOpt.ContentDisposition != "" {
+			createInput.ContentDisposition = aws.String(uploadOpt.ContentDisposition)
+		}
+		if uploadOpt.ContentEncoding != "" {
+			createInput.ContentEncoding = aws.String(uploadOpt.ContentEncoding)
+		}
+		if len(uploadOpt.Tags) > 0 {
+			createInput.Tagging = aws.String(encodeTags(uploadOpt.Tags))
+		}
+		createInput.ServerSideEncryption, createInput.SSEKMSKeyId, createInput.BucketKeyEnabled = uploadOpt.Encryption.sse()
+		createInput.SSECustomerAlgorithm, createInput.SSECustomerKey, createInput.SSECustomerKeyMD5 = uploadOpt.Encryption.sseC()
+		createOut, err := basics.clientFor(ctx, bucketName).CreateMultipartUpload(ctx, createInput)
+		if err != nil {
+			log.Errorf("创建分段上传 %s:%s 失败, err= %v", bucketName, awsFileName, err)
+			return nil, err
+		}
+		cp = &uploadCheckpoint{
+			Bucket:   bucketName,
+			Key:      awsFileName,
+			FileName: uploadFileName,
+			FileSize: info.Size(),
+			ModTime:  info.ModTime(),
+			PartSize: partSize,
+			UploadId: aws.ToString(createOut.UploadId),
+			Options:  cpOpts,
+		}
+	} else {
+		log.Infof("从断点继续上传 %s:%s, uploadId= %s, 已完成 %d 段", bucketName, awsFileName, cp.UploadId, len(cp.Parts))
+	}
+	if err = saveCheckpoint(checkpointFile, cp); err != nil {
+		return nil, err
+	}
+
+	// 3. 并发上传剩下的分段
+	partCount := int32((info.Size() + partSize - 1) / partSize)
+	if partCount == 0 {
+		partCount = 1 // 空文件也要传一段
+	}
+	done := make(map[int32]bool, len(cp.Parts))
+	for _, part := range cp.Parts {
+		done[part.PartNumber] = true
+	}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		partChan = make(chan int32)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partChan {
+				offset := int64(partNumber-1) * partSize
+				size := min(partSize, info.Size()-offset)
+				partInput := &s3.UploadPartInput{
+					Bucket:            aws.String(bucketName),
+					Key:               aws.String(awsFileName),
+					UploadId:          aws.String(cp.UploadId),
+					PartNumber:        aws.Int32(partNumber),
+					Body:              io.NewSectionReader(file, offset, size),
+					ContentLength:     aws.Int64(size),
+					ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+				}
+				partInput.SSECustomerAlgorithm, partInput.SSECustomerKey, partInput.SSECustomerKeyMD5 = uploadOpt.Encryption.sseC()
+				partOut, err := basics.clientFor(ctx, bucketName).UploadPart(ctx, partInput)
+
+				mu.Lock()
+				if err != nil {
+					log.Errorf("上传第 %d 段失败 %s:%s, err= %v", partNumber, bucketName, awsFileName, err)
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					cp.Parts = append(cp.Parts, checkpointPart{
+						PartNumber:    partNumber,
+						ETag:          aws.ToString(partOut.ETag),
+						ChecksumCRC32: aws.ToString(partOut.ChecksumCRC32),
+					})
+					// 每传完一段就写一次断点文件, 进程挂了下次能接着传
+					if err = saveCheckpoint(checkpointFile, cp); err != nil && firstErr == nil {
+						firstErr = err
+					}
+					log.Debugf("上传第 %d/%d 段成功 %s:%s", partNumber, partCount, bucketName, awsFileName)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for partNumber := int32(1); partNumber <= partCount; partNumber++ {
+		if done[partNumber] {
+			continue
+		}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			break // 出错了就不再派发新的分段, 保留断点
+		}
+		partChan <- partNumber
+	}
+	close(partChan)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		log.Errorf("分段上传 %s:%s 中断, 断点已保存到 %s, 重新执行即可续传", bucketName, awsFileName, checkpointFile)
+		return nil, firstErr
+	}
+
+	// 4. 合并分段, 完成上传
+	sort.Slice(cp.Parts, func(i, j int) bool { return cp.Parts[i].PartNumber < cp.Parts[j].PartNumber })
+	completedParts := make([]types.CompletedPart, 0, len(cp.Parts))
+	for _, part := range cp.Parts {
+		completedPart := types.CompletedPart{
+			PartNumber: aws.Int32(part.PartNumber),
+			ETag:       aws.String(part.ETag),
+		}
+		if part.ChecksumCRC32 != "" {
+			completedPart.ChecksumCRC32 = aws.String(part.ChecksumCRC32)
+		}
+		completedParts = append(completedParts, completedPart)
+	}
+	completeInput := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(awsFileName),
+		UploadId:        aws.String(cp.UploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completedParts},
+	}
+	completeInput.SSECustomerAlgorithm, completeInput.SSECustomerKey, completeInput.SSECustomerKeyMD5 = uploadOpt.Encryption.sseC()
+	completeOut, err := basics.clientFor(ctx, bucketName).CompleteMultipartUpload(ctx, completeInput)
+	if err != nil {
+		log.Errorf("合并分段 %s:%s 失败, uploadId= %s, err= %v", bucketName, awsFileName, cp.UploadId, err)
+		return nil, err
+	}
+
+	// 5. 删除断点文件, 返回
+	if err = os.Remove(checkpointFile); err != nil && !os.IsNotExist(err) {
+		log.Warnf("删除断点文件 %s 失败, err= %v", checkpointFile, err)
+	}
+	log.Infof("断点续传上传文件%s 到 %s:%s 成功, 共 %d 段", uploadFileName, bucketName, awsFileName, partCount)
+	return &UploadResult{
+		Key:               awsFileName,
+		Location:          aws.ToString(completeOut.Location),
+		ETag:              aws.ToString(completeOut.ETag),
+		VersionId:         aws.ToString(completeOut.VersionId),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		Checksum:          aws.ToString(completeOut.ChecksumCRC32),
+	}, nil
+}
+
+// 查 - 某个存储桶下未完成的分段上传
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	prefix string : 对象key前缀, "" 表示全部
+返回值:
+	[]types.MultipartUpload: 未完成的分段上传
+	error: 错误
+*/
+func (basics BucketBasics) MultipartUploadsList(ctx context.Context, bucketName string, prefix string) ([]types.MultipartUpload, error) {
+	var uploads []types.MultipartUpload
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(bucketName)}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	paginator := s3.NewListMultipartUploadsPaginator(basics.clientFor(ctx, bucketName), input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Errorf("查询存储桶 %s 未完成的分段上传失败. reason: %v", bucketName, err)
+			return uploads, err
+		}
+		uploads = append(uploads, output.Uploads...)
+	}
+	for _, upload := range uploads {
+		log.Debugf("未完成的分段上传 %s:%s, uploadId= %s, 开始时间: %v",
+			bucketName, aws.ToString(upload.Key), aws.ToString(upload.UploadId), aws.ToTime(upload.Initiated))
+	}
+	return uploads, nil
+}
+
+// 删 - 清理某个存储桶下未完成的分段上传, 免得一直收存储费
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	prefix string : 对象key前缀, "" 表示全部
+	olderThan time.Duration : 只清理开始时间早于 now-olderThan 的, 0 表示全部清理
+返回值:
+	int: 清理了几个
+	error: 错误
+*/
+func (basics BucketBasics) MultipartUploadsAbort(ctx context.Context, bucketName string, prefix string, olderThan time.Duration) (int, error) {
+	uploads, err := basics.MultipartUploadsList(ctx, bucketName, prefix)
+	if err != nil {
+		return 0, err
+	}
+	aborted := 0
+	deadline := time.Now().Add(-olderThan)
+	for _, upload := range uploads {
+		if olderThan > 0 && upload.Initiated != nil && upload.Initiated.After(deadline) {
+			continue // 还比较新, 可能正在传, 不动
+		}
+		_, err = basics.clientFor(ctx, bucketName).AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucketName),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err != nil {
+			log.Errorf("清理分段上传 %s:%s 失败, uploadId= %s, err= %v",
+				bucketName, aws.ToString(upload.Key), aws.ToString(upload.UploadId), err)
+			return aborted, err
+		}
+		aborted++
+	}
+	log.Infof("清理存储桶 %s 未完成的分段上传 %d 个", bucketName, aborted)
+	return aborted, nil
+}
+
+// 用 ListParts 查已经上传成功的分段, 以 s3 上的为准 (断点文件可能比 s3 少最后几段)
+// SSE-C 要带密钥, 不然 s3 不返回分段的校验值
+func (basics BucketBasics) listUploadedParts(ctx context.Context, cp *uploadCheckpoint, enc Encryption) ([]checkpointPart, error) {
+	var parts []checkpointPart
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(cp.Bucket),
+		Key:      aws.String(cp.Key),
+		UploadId: aws.String(cp.UploadId),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = enc.sseC()
+	paginator := s3.NewListPartsPaginator(basics.clientFor(ctx, cp.Bucket), input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range output.Parts {
+			partNumber := aws.ToInt32(part.PartNumber)
+			// 只认大小对得上的段, 最后一段可能比 PartSize 小
+			expectSize := min(cp.PartSize, cp.FileSize-int64(partNumber-1)*cp.PartSize)
+			if aws.ToInt64(part.Size) != expectSize {
+				continue
+			}
+			parts = append(parts, checkpointPart{
+				PartNumber:    partNumber,
+				ETag:          aws.ToString(part.ETag),
+				ChecksumCRC32: aws.ToString(part.ChecksumCRC32),
+			})
+		}
+	}
+	return parts, nil
+}
+
+// 计算分段大小: 不能小于5MB, 分段数不能超过 10000, 超过了就自动调大
+func resumablePartSize(fileSize int64, partSize int64) int64 {
+	if partSize < manager.MinUploadPartSize {
+		partSize = manager.MinUploadPartSize
+	}
+	if fileSize/partSize >= maxUploadParts {
+		partSize = fileSize/(maxUploadParts-1) + 1
+	}
+	return partSize
+}
+
+// 读取断点文件
+/*
+返回值:
+	*uploadCheckpoint: 断点, 没有断点文件为 nil; 损坏了但还能读出 uploadId 也返回, 用来取消旧的分段上传
+	bool: 断点文件是不是损坏了, 损坏了不能续传
+*/
+func loadCheckpoint(checkpointFile string) (*uploadCheckpoint, bool) {
+	data, err := os.ReadFile(checkpointFile)
+	if err != nil {
+		return nil, false
+	}
+	cp := &uploadCheckpoint{}
+	if err = json.Unmarshal(data, cp); err != nil || cp.UploadId == "" || cp.Bucket == "" || cp.Key == "" {
+		log.Warnf("断点文件 %s 损坏, 忽略, err= %v", checkpointFile, err)
+		if cp.UploadId == "" || cp.Bucket == "" || cp.Key == "" {
+			return nil, true
+		}
+		return cp, true
+	}
+	return cp, false
+}
+
+// 丢掉不能续传的断点: 取消断点里的分段上传 (已经传的分段会删掉, 不再收费), 再删掉断点文件
+// 取消失败只记日志, 不影响重新上传, 剩下的可以用 MultipartUploadsAbort 清理
+func (basics BucketBasics) discardCheckpoint(ctx context.Context, checkpointFile string, cp *uploadCheckpoint) {
+	if cp != nil {
+		_, err := basics.clientFor(ctx, cp.Bucket).AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(cp.Bucket),
+			Key:      aws.String(cp.Key),
+			UploadId: aws.String(cp.UploadId),
+		})
+		if err != nil {
+			log.Warnf("取消旧的分段上传 %s:%s 失败, uploadId= %s, err= %v", cp.Bucket, cp.Key, cp.UploadId, err)
+		} else {
+			log.Infof("取消旧的分段上传 %s:%s, uploadId= %s", cp.Bucket, cp.Key, cp.UploadId)
+		}
+	}
+	if err := os.Remove(checkpointFile); err != nil && !os.IsNotExist(err) {
+		log.Warnf("删除断点文件 %s 失败, err= %v", checkpointFile, err)
+	}
+}
+
+// 写断点文件, 先写临时文件再改名, 防止写一半进程挂了把断点文件弄坏
+func saveCheckpoint(checkpointFile string, cp *uploadCheckpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpName := checkpointFile + ".tmp"
+	if err = os.WriteFile(tmpName, data, 0644); err != nil {
+		return fmt.Errorf("写断点文件 %s 失败: %w", tmpName, err)
+	}
+	if err = os.Rename(tmpName, checkpointFile); err != nil {
+		return fmt.Errorf("写断点文件 %s 失败: %w", checkpointFile, err)
+	}
+	return nil
+}
@@ -2,23 +2,130 @@
 package mys3
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"study-aws-api-go/errorutil"
+	"strings"
 	"study-aws-api-go/log"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 )
 
+// 变量
+const (
+	maxDeleteKeys         = 1000 // DeleteObjects 一次最多删 1000 个
+	defaultDeleteParallel = 4    // 批量删除默认并发批数
+
+	downloadTempSuffix = ".download" // 下载临时文件的后缀, 临时文件名是 ".文件名.随机数字.download"
+)
+
+// 下载选项, 给分段并发下载用
+type DownloadOptions struct {
+	PartSize    int64 // 每段大小(字节), <=0 用默认值 manager.DefaultDownloadPartSize (5MB)
+	Concurrency int   // 并发数, <=0 用默认值 manager.DefaultDownloadConcurrency (5)
+
+	Encryption Encryption   // 加密选项, 只有 SSE-C 要传, 要和上传时的密钥一样
+	Verify     ChecksumMode // 下载校验, 边下边算和 s3 上保存的比对, 不一致返回 *IntegrityError, 不填不校验
+}
+
+// 把选项设置到 manager.Downloader 上, 只影响本次下载
+func (opts DownloadOptions) apply(d *manager.Downloader) {
+	if opts.PartSize > 0 {
+		d.PartSize = opts.PartSize
+	}
+	if opts.Concurrency > 0 {
+		d.Concurrency = opts.Concurrency
+	}
+}
+
+// 上传选项, 给流式分段上传用
+type UploadOptions struct {
+	PartSize          int64                   // 每段大小(字节), <=0 用默认值 manager.DefaultUploadPartSize (5MB), 最小5MB
+	Concurrency       int                     // 并发数, <=0 用默认值 manager.DefaultUploadConcurrency (5)
+	ChecksumAlgorithm types.ChecksumAlgorithm // 校验算法, 不填默认 SHA256
+	Verify            ChecksumMode            // 上传校验, 边传边算和 s3 返回的比对, 不一致返回 *IntegrityError, 不填不校验 (ObjectUpload 默认 SHA256)
+	Encryption        Encryption              // 服务端加密, 不填用存储桶默认加密
+	ClientEncrypt     bool                    // 客户端加密, 上传前先用 AES-GCM 加密, 要配置主密钥 BucketBasics.CSEMasterKey
+	Metadata          map[string]string       // 用户元数据 x-amz-meta-*, 如 {"comic-id": "1024"}, 放在http头里, 中文要先 url 编码
+	Tags              map[string]string       // 标签, 最多10个, 可以用来做生命周期过滤
+	StorageClass      types.StorageClass      // 存储类型, 如 STANDARD_IA / INTELLIGENT_TIERING / GLACIER_IR / DEEP_ARCHIVE, 不填是 STANDARD
+
+	ContentType        string // Content-Type, 不填按扩展名和内容自动检测
+	CacheControl       string // Cache-Control, 如 "public, max-age=31536000, immutable"
+	ContentDisposition string // Content-Disposition, 中文文件名用 ContentDisposition("inline", 文件名) 生成
+	ContentEncoding    string // Content-Encoding, 如 gzip, 内容是自己压缩好的才填
+}
+
+// 把内容相关的http头设置到 PutObjectInput 上, Content-Type 没填就自动检测, 返回后续要用的数据流
+func (opts UploadOptions) applyContent(input *s3.PutObjectInput, body io.Reader) io.Reader {
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType, body = detectContentType(aws.ToString(input.Key), body, opts.ContentEncoding)
+	}
+	input.ContentType = aws.String(contentType)
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	return body
+}
+
+// 把选项设置到 manager.Uploader 上, 只影响本次上传
+func (opts UploadOptions) apply(u *manager.Uploader) {
+	if opts.PartSize > 0 {
+		u.PartSize = opts.PartSize
+	}
+	if opts.Concurrency > 0 {
+		u.Concurrency = opts.Concurrency
+	}
+}
+
+// 上传结果
+type UploadResult struct {
+	Key               string                  // 对象key
+	Location          string                  // 对象url
+	ETag              string                  // 对象ETag
+	VersionId         string                  // 版本id, 存储桶没开版本控制时为空
+	ChecksumAlgorithm types.ChecksumAlgorithm // 校验算法
+	Checksum          string                  // 校验值 (base64), 分段上传时是 "xxx-分段数" 形式的组合校验值
+}
+
+// 批量删除选项
+type BatchDeleteOptions struct {
+	BypassGovernance bool // s3 的管理策略开关 , false -> 就是关
+	WaitForAbsence   bool // 删除后是否等待文件确实不存在了(每个key都要 HeadObject, 很慢), 默认不等
+	Concurrency      int  // 并发批数, <=0 默认 4
+}
+
+// 批量删除结果
+type BatchDeleteResult struct {
+	Deleted []DeleteKeyResult // 删除成功的
+	Failed  []DeleteKeyResult // 删除失败的, 有错误码和错误信息
+}
+
+// 批量删除中一个key的结果
+type DeleteKeyResult struct {
+	Key          string // 对象key
+	VersionId    string // 版本id
+	DeleteMarker bool   // 是否是删除标记 (开了版本控制, 不带版本删除会生成删除标记)
+	Code         string // 失败时的错误码, 如 AccessDenied
+	Message      string // 失败时的错误信息
+}
+
 // 增 - 没sdk api接口
 
 // 删
@@ -51,7 +158,7 @@ func (basics BucketBasics) ObjectDelete(ctx context.Context, bucketName string,
 	}
 
 	// 2. 删除文件
-	_, err := basics.S3Client.DeleteObject(ctx, input)
+	_, err := basics.clientFor(ctx, bucketName).DeleteObject(ctx, input)
 
 	// 3. 判断错误
 	if err != nil {
@@ -72,7 +179,7 @@ func (basics BucketBasics) ObjectDelete(ctx context.Context, bucketName string,
 	}
 
 	// 4. 等待文件确实成功,默认1分钟
-	err = s3.NewObjectNotExistsWaiter(basics.S3Client).Wait(ctx, &s3.HeadObjectInput{
+	err = s3.NewObjectNotExistsWaiter(basics.clientFor(ctx, bucketName)).Wait(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(awsFileName),
 	}, time.Minute)
@@ -91,28 +198,77 @@ func (basics BucketBasics) ObjectDelete(ctx context.Context, bucketName string,
 // 参数:
 // - ctx context.Contex
 // - bucketName string        桶名称
-// - objs  []types.ObjectIdentifier      对象key数组 (文件key)
-// - bypassGovernance  bool    s3 的管理策略开关 , false -> 就是关
+// - objs  []types.ObjectIdentifier      对象key数组 (文件key), 超过1000个会自动分批
+// - opts BatchDeleteOptions   s3 的管理策略开关、是否等待文件确实删除、并发数
 // 返回值:
-// - bool // 是否删除成功
-// - error
+// - *BatchDeleteResult // 每个key是删除成功还是失败
+// - error // 有任何一个失败就返回错误, 详细看 BatchDeleteResult.Failed
 // 思路：
-// 1. 准备
-// 2. 删除文件
-// 3. 判断错误
-// 4. 等待文件确实成功,默认1分钟
-func (basics BucketBasics) ObjectDeleteBatch(ctx context.Context, bucketName string, objs []types.ObjectIdentifier, bypassGovernance bool) error {
+// 1. 准备, 按1000个一批分好
+// 2. 并发删除每一批
+// 3. 整理每个key的结果
+// 4. (可选)等待文件确实删除,默认1分钟
+func (basics BucketBasics) ObjectDeleteBatch(ctx context.Context, bucketName string, objs []types.ObjectIdentifier, opts BatchDeleteOptions) (*BatchDeleteResult, error) {
 	// 1. 准备
 	// 判断数组是否空
 	if len(objs) == 0 {
-		return fmt.Errorf("批量删除错误。%s:%v ,err = objs 数组为空", bucketName, objs)
+		return nil, fmt.Errorf("批量删除错误。%s:%v ,err = objs 数组为空", bucketName, objs)
+	}
+	var chunks [][]types.ObjectIdentifier
+	for start := 0; start < len(objs); start += maxDeleteKeys {
+		chunks = append(chunks, objs[start:min(start+maxDeleteKeys, len(objs))])
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultDeleteParallel
 	}
 
+	// 2. 并发删除每一批
+	result := &BatchDeleteResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency) // 控制并发数
+	for _, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(chunk []types.ObjectIdentifier) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			deleted, failed := basics.deleteChunk(ctx, bucketName, chunk, opts.BypassGovernance)
+			mu.Lock()
+			result.Deleted = append(result.Deleted, deleted...)
+			result.Failed = append(result.Failed, failed...)
+			mu.Unlock()
+		}(chunk)
+	}
+	wg.Wait()
+
+	// 4. (可选)等待文件确实删除,默认1分钟
+	if opts.WaitForAbsence && len(result.Deleted) > 0 {
+		result.Deleted = basics.waitDeleted(ctx, bucketName, result, concurrency)
+	}
+
+	// 5. 返回
+	for _, failed := range result.Failed {
+		log.Errorf("批量删除文件失败, 删除某一条 %s:%s 失败。code= %s, err= %s", bucketName, failed.Key, failed.Code, failed.Message)
+	}
+	for _, deleted := range result.Deleted {
+		log.Debugf("批量删除文件成功。文件%s:%s", bucketName, deleted.Key)
+	}
+	log.Infof("批量删除存储桶 %s 文件, 成功 %d 个, 失败 %d 个", bucketName, len(result.Deleted), len(result.Failed))
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("批量删除 %s 文件, %d 个失败, 第一个: %s %s", bucketName, len(result.Failed), result.Failed[0].Key, result.Failed[0].Code)
+	}
+	return result, nil
+}
+
+// 删除一批 (<=1000个), 返回 成功的 和 失败的
+func (basics BucketBasics) deleteChunk(ctx context.Context, bucketName string, chunk []types.ObjectIdentifier, bypassGovernance bool) ([]DeleteKeyResult, []DeleteKeyResult) {
 	input := s3.DeleteObjectsInput{
 		Bucket: aws.String(bucketName),
 		Delete: &types.Delete{
-			Objects: objs,
-			Quiet:   aws.Bool(true),
+			Objects: chunk,
+			Quiet:   aws.Bool(false), // 要拿到每个key的结果
 		},
 	}
 
@@ -121,51 +277,93 @@ func (basics BucketBasics) ObjectDeleteBatch(ctx context.Context, bucketName str
 		input.BypassGovernanceRetention = aws.Bool(true)
 	}
 
-	// 2. 删除文件
-	delOut, err := basics.S3Client.DeleteObjects(ctx, &input)
+	delOut, err := basics.clientFor(ctx, bucketName).DeleteObjects(ctx, &input)
 
-	// 3. 判断错误
-	// objs 数组都错愕了，或者其中一个删除错了
+	// 3. 整理每个key的结果
+	// 整批都失败了, 每个key都记成失败
 	if err != nil {
+		code := "RequestError"
 		var noBucket *types.NoSuchBucket // 没有桶错误
+		var apiErr smithy.APIError
 		if errors.As(err, &noBucket) {
 			log.Error("批量删除文件失败。err= 没有存储桶 ", bucketName)
-			err = noBucket
+			code = "NoSuchBucket"
+		} else if errors.As(err, &apiErr) {
+			code = apiErr.ErrorCode()
 		}
-		log.Error("批量删除文件失败。err= ", err)
-		return err
-	}
-
-	// objs 数组都其中一个删除错了
-	if len(delOut.Errors) > 0 {
-		for i, outErr := range delOut.Errors {
-			log.Errorf("批量删除文件失败, 删除某一条 %s 失败。err= %s", *outErr.Key, *outErr.Message)
-			err = fmt.Errorf("%s", *delOut.Errors[i].Message)
-			return err
+		failed := make([]DeleteKeyResult, 0, len(chunk))
+		for _, obj := range chunk {
+			failed = append(failed, DeleteKeyResult{
+				Key:       aws.ToString(obj.Key),
+				VersionId: aws.ToString(obj.VersionId),
+				Code:      code,
+				Message:   err.Error(),
+			})
 		}
-
+		return nil, failed
 	}
 
-	// 4. 等待文件确实成功,默认1分钟
+	deleted := make([]DeleteKeyResult, 0, len(delOut.Deleted))
 	for _, delObj := range delOut.Deleted {
-		err = s3.NewObjectNotExistsWaiter(basics.S3Client).Wait(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(*delObj.Key),
-		}, time.Minute)
-		if err != nil {
-			log.Errorf("等待失败。删除文件%s:%v 失败. reason: %v", bucketName, delObj, err)
-			return err
-		}
+		deleted = append(deleted, DeleteKeyResult{
+			Key:          aws.ToString(delObj.Key),
+			VersionId:    aws.ToString(delObj.VersionId),
+			DeleteMarker: aws.ToBool(delObj.DeleteMarker),
+		})
 	}
+	failed := make([]DeleteKeyResult, 0, len(delOut.Errors))
+	for _, outErr := range delOut.Errors {
+		failed = append(failed, DeleteKeyResult{
+			Key:       aws.ToString(outErr.Key),
+			VersionId: aws.ToString(outErr.VersionId),
+			Code:      aws.ToString(outErr.Code),
+			Message:   aws.ToString(outErr.Message),
+		})
+	}
+	return deleted, failed
+}
 
-	// 5 删除成功
-	for _, obj := range objs {
-		log.Infof("批量删除文件成功。文件%s:%+v", bucketName, obj.Key)
+// 并发等待删除成功的文件确实不存在了, 等待失败的挪到 Failed 里, 返回剩下确实删除的
+func (basics BucketBasics) waitDeleted(ctx context.Context, bucketName string, result *BatchDeleteResult, concurrency int) []DeleteKeyResult {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	confirmed := make([]DeleteKeyResult, 0, len(result.Deleted))
+	for _, deleted := range result.Deleted {
+		if deleted.VersionId != "" {
+			// 删的是指定版本, HeadObject 查的是当前版本, 没法等, 直接算成功
+			mu.Lock()
+			confirmed = append(confirmed, deleted)
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(deleted DeleteKeyResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := s3.NewObjectNotExistsWaiter(basics.clientFor(ctx, bucketName)).Wait(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(bucketName),
+				Key:    aws.String(deleted.Key),
+			}, time.Minute)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Errorf("等待失败。删除文件%s:%s 失败. reason: %v", bucketName, deleted.Key, err)
+				deleted.Code = "WaitFailed"
+				deleted.Message = err.Error()
+				result.Failed = append(result.Failed, deleted)
+				return
+			}
+			confirmed = append(confirmed, deleted)
+		}(deleted)
 	}
-	return err
+	wg.Wait()
+	return confirmed
 }
 
-// 改
+// 改 - 复制、移动、改名, 见 mys3_copy.go
+
 // 查 某个bucket下所有
 /*
 参数:
@@ -190,8 +388,8 @@ func (basics BucketBasics) ObjectQueryAll(ctx context.Context, bucketName string
 	var objects []types.Object
 
 	// 2. 查询
-	objectPaginator := s3.NewListObjectsV2Paginator(basics.S3Client, input) // 分页器
-	for objectPaginator.HasMorePages() {                                    // 循环
+	objectPaginator := s3.NewListObjectsV2Paginator(basics.clientFor(ctx, bucketName), input) // 分页器
+	for objectPaginator.HasMorePages() {                                                      // 循环
 		output, err = objectPaginator.NextPage(ctx) // 查询
 		// 3. 处理错误
 		if err != nil {
@@ -217,6 +415,7 @@ func (basics BucketBasics) ObjectQueryAll(ctx context.Context, bucketName string
 // - bucketName string        桶名称
 // - awsFileName  string         对象key (文件key).使用时 xx【objectKey】
 // - fileName string          文件名，看情况使用相对路径/绝对路径，看起来要常用绝对路径
+// - opts ...UploadOptions    可选, 用到 Encryption 服务端加密、Metadata 元数据、Tags 标签、内容相关http头
 // 返回值:
 // - error
 // func (basics BucketBasics) BucketQuery(ctx context.Context, s3Client *s3.Client) error { // 这种写法不够灵活
@@ -225,18 +424,39 @@ func (basics BucketBasics) ObjectQueryAll(ctx context.Context, bucketName string
 // 2. 上传文件
 // 3. 判断错误
 // 4. 等待文件确实上传成功,默认1分钟
-func (basics BucketBasics) FileUploadLowApi(ctx context.Context, bucketName string, awsFileName string, fileName string) error {
+func (basics BucketBasics) FileUploadLowApi(ctx context.Context, bucketName string, awsFileName string, fileName string, opts ...UploadOptions) error {
+	var opt UploadOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if err := opt.Encryption.validate(); err != nil {
+		return err
+	}
+	if err := validateTags(opt.Tags); err != nil {
+		return err
+	}
+
 	// 1. 打开文件
 	file, err := os.Open(fileName)
+	if err != nil {
+		log.Errorf("打开文件: %s 失败, err= %v", fileName, err)
+		return err
+	}
 	defer file.Close()
-	errorutil.ErrorPrintf(err, "打开文件: %s 失败", fileName)
 
 	// 2. 上传文件
-	_, err = basics.S3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(awsFileName),
-		Body:   file,
-	})
+	input := &s3.PutObjectInput{
+		Bucket:       aws.String(bucketName),
+		Key:          aws.String(awsFileName),
+		Metadata:     opt.Metadata,
+		StorageClass: opt.StorageClass,
+	}
+	input.Body = opt.applyContent(input, file) // Content-Type 等, 没填就自动检测
+	if len(opt.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(opt.Tags))
+	}
+	opt.Encryption.applyPut(input)
+	_, err = basics.clientFor(ctx, bucketName).PutObject(ctx, input)
 
 	// 3. 判断错误
 	if err != nil {
@@ -248,13 +468,16 @@ func (basics BucketBasics) FileUploadLowApi(ctx context.Context, bucketName stri
 				"or the multipart upload API (5TB max).", bucketName)
 		}
 		log.Errorf("上传文件%s 到 %s:%s 失败. reason: %v", fileName, bucketName, awsFileName, err)
+		return err
 	}
 
 	// 4. 等待文件确实上传成功,默认1分钟
-	err = s3.NewObjectExistsWaiter(basics.S3Client).Wait(ctx, &s3.HeadObjectInput{
+	headInput := &s3.HeadObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(awsFileName),
-	}, time.Minute)
+	}
+	opt.Encryption.applyHead(headInput)
+	err = s3.NewObjectExistsWaiter(basics.clientFor(ctx, bucketName)).Wait(ctx, headInput, time.Minute)
 	if err != nil {
 		log.Errorf("等待失败。上传文件%s 到 %s:%s 失败. reason: %v", fileName, bucketName, awsFileName, err)
 		return err
@@ -271,124 +494,491 @@ func (basics BucketBasics) FileUploadLowApi(ctx context.Context, bucketName stri
 // - bucketName string        桶名称
 // - awsFileName  string         对象key (文件key).使用时 xx【objectKey】
 // - uploadFileName string    要上传的文件名。可以是相对路径/绝对路径，一般是绝对路径
-// - contents string  - delete        文件上下文. 也就算上传的文件路径。原本是io形式类型的contents，
+// - opts ...UploadOptions    可选, 分段大小、并发数、校验算法、加密、元数据、标签、内容相关http头
 // 返回值:
-// - string  ?啥东西
+// - string  上传后的对象key
 // - error
 // 思路：
-// 1. 准备
-// 2. 上传文件
-// 3. 判断错误
-// 4. 等待文件确实上传成功,默认1分钟
-// func (basics BucketBasics) ObjectUpload(ctx context.Context, bucketName string, awsFileName string, contents string) (string, error) { // 官方推荐写法
-func (basics BucketBasics) ObjectUpload(ctx context.Context, bucketName string, awsFileName string, uploadFileName string) (string, error) {
+// 1. 打开文件 (不再 os.ReadFile 整个读进内存)
+// 2. 交给 ObjectUploadStream 流式分段上传
+// 3. 返回
+func (basics BucketBasics) ObjectUpload(ctx context.Context, bucketName string, awsFileName string, uploadFileName string, opts ...UploadOptions) (string, error) {
+	var opt UploadOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Verify == ChecksumModeNone && (opt.ChecksumAlgorithm == "" || opt.ChecksumAlgorithm == types.ChecksumAlgorithmSha256) {
+		opt.Verify = ChecksumModeSHA256 // 默认校验上传结果
+	}
+
+	// 1. 打开文件
+	file, err := os.Open(uploadFileName)
+	if err != nil {
+		log.Errorf("打开文件: %s 失败, err= %v", uploadFileName, err)
+		return "", err
+	}
+	defer file.Close()
+
+	// 2. 交给 ObjectUploadStream 流式分段上传
+	result, err := basics.ObjectUploadStream(ctx, bucketName, awsFileName, file, opt)
+	if err != nil {
+		log.Errorf("上传文件%s 到 %s:%s 失败. reason: %v", uploadFileName, bucketName, awsFileName, err)
+		return "", err
+	}
+
+	// 3. 返回
+	log.Infof("上传文件%s 到 %s:%s 成功", uploadFileName, bucketName, awsFileName)
+	return result.Key, nil
+}
+
+// 上传 - 流式分段上传, 数据来源可以是文件、http body、管道等任意 io.Reader
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key (文件key).使用时 xx【objectKey】
+	body io.Reader : 要上传的数据流。传输管理器每次只缓存 PartSize*Concurrency 大小, 不会整个读进内存
+	opts UploadOptions : 分段大小、并发数、校验算法、加密、元数据、标签、内容相关http头, 不填用默认值
+返回值:
+	*UploadResult: 上传结果, 有 ETag、VersionId、校验值
+	error: 错误
+思路:
+	1. 准备
+	2. 上传文件
+	3. 判断错误
+	4. 等待文件确实上传成功,默认1分钟
+	5. 整理返回结果
+*/
+func (basics BucketBasics) ObjectUploadStream(ctx context.Context, bucketName string, awsFileName string, body io.Reader, opts UploadOptions) (*UploadResult, error) {
 	// 1. 准备
-	var outKey string // 返回值
-	contents, err := os.ReadFile(uploadFileName)
+	if err := opts.Encryption.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateTags(opts.Tags); err != nil {
+		return nil, err
+	}
+	if err := opts.Verify.validate(); err != nil {
+		return nil, err
+	}
+	checksumAlgorithm := opts.ChecksumAlgorithm
+	if alg := opts.Verify.algorithm(); alg != "" { // 校验方式要和校验算法一致
+		if checksumAlgorithm != "" && checksumAlgorithm != alg {
+			return nil, fmt.Errorf("上传到 %s:%s 失败, 校验方式 %s 和校验算法 %s 不一致", bucketName, awsFileName, opts.Verify, checksumAlgorithm)
+		}
+		checksumAlgorithm = alg
+	}
+	if opts.Verify == ChecksumModeMD5 && (opts.Encryption.Mode == EncryptionSSEKMS || opts.Encryption.Mode == EncryptionSSEC) {
+		return nil, fmt.Errorf("上传到 %s:%s 失败, SSE-KMS/SSE-C 加密的对象 ETag 不是 MD5, 不能用 MD5 校验", bucketName, awsFileName)
+	}
+	if checksumAlgorithm == "" {
+		checksumAlgorithm = types.ChecksumAlgorithmSha256 // 默认校验算法
+	}
+	uploader := basics.uploaderFor(basics.clientFor(ctx, bucketName)) // 存储桶所在区域的上传器
 	input := &s3.PutObjectInput{
 		Bucket:            aws.String(bucketName),
 		Key:               aws.String(awsFileName),
-		Body:              bytes.NewReader([]byte(contents)),
-		ChecksumAlgorithm: types.ChecksumAlgorithmSha256, // 校验算法
+		ChecksumAlgorithm: checksumAlgorithm, // 校验算法
+		StorageClass:      opts.StorageClass,
+	}
+	body = opts.applyContent(input, body) // Content-Type 等, 没填就自动检测, 要在加密前看明文
+	if opts.Verify != ChecksumModeNone {
+		// 分段大小要自己定好, 算组合校验值要用; 包了 TeeReader 后传输管理器就不知道总大小, 不会再调整分段大小
+		opts.PartSize = uploadPartSize(body, opts.PartSize, uploader.PartSize)
+	}
+	var cseMetadata map[string]string
+	if opts.ClientEncrypt { // 客户端加密, 上传的是密文流
+		var err error
+		body, cseMetadata, err = newEncryptReader(basics.CSEMasterKey, body)
+		if err != nil {
+			log.Errorf("上传到 %s:%s 失败, err= %v", bucketName, awsFileName, err)
+			return nil, err
+		}
+	}
+	var hasher *checksumHasher
+	if opts.Verify != ChecksumModeNone { // 边传边算, 算的是真正上传的数据 (客户端加密的就是密文)
+		hasher = newChecksumHasher(opts.Verify, opts.PartSize)
+		body = io.TeeReader(body, hasher)
 	}
+	input.Body = body
+	input.Metadata = mergeMetadata(opts.Metadata, cseMetadata) // 用户元数据 + 客户端加密的数据密钥和IV
+	if len(opts.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+	opts.Encryption.applyPut(input)
 
 	// 2. 上传文件
-	output, err := basics.S3Manager.Upload(ctx, input)
+	output, err := uploader.Upload(ctx, input, opts.apply)
 
 	// 3. 判断错误
 	if err != nil {
 		var noBucket *types.NoSuchBucket // 无存储桶 错误
+		var multiErr manager.MultiUploadFailure
 		if errors.As(err, &noBucket) {
 			log.Errorf("存储桶 %s 不存在", bucketName)
 			err = noBucket
+		} else if errors.As(err, &multiErr) {
+			log.Errorf("分段上传 %s:%s 失败, uploadId= %s", bucketName, awsFileName, multiErr.UploadID())
 		}
-		return "", err
+		return nil, err
 	}
 
 	// 4. 等待文件确实上传成功,默认1分钟
-	err = s3.NewObjectExistsWaiter(basics.S3Client).Wait(ctx, &s3.HeadObjectInput{
+	headInput := &s3.HeadObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(awsFileName),
-	}, time.Minute)
-
+	}
+	opts.Encryption.applyHead(headInput)
+	err = s3.NewObjectExistsWaiter(basics.clientFor(ctx, bucketName)).Wait(ctx, headInput, time.Minute)
 	if err != nil {
-		log.Errorf("等待失败。上传文件%s 到 %s:%s 失败. reason: %v", uploadFileName, bucketName, awsFileName, err)
-		return "", err
+		log.Errorf("等待失败。上传到 %s:%s 失败. reason: %v", bucketName, awsFileName, err)
+		return nil, err
 	}
 
-	// 上传成功
-	outKey = *output.Key
-	log.Infof("上传文件%s 到 %s:%s 成功", uploadFileName, bucketName, awsFileName)
-	return outKey, nil
+	// 校验: 本地算的和 s3 返回的比对
+	if hasher != nil {
+		if opts.Verify == ChecksumModeMD5 && (output.ServerSideEncryption == types.ServerSideEncryptionAwsKms || output.ServerSideEncryption == types.ServerSideEncryptionAwsKmsDsse) {
+			log.Warnf("%s:%s 被存储桶默认加密成了 SSE-KMS, ETag 不是 MD5, 跳过 MD5 校验", bucketName, awsFileName)
+		} else if err = hasher.verify(bucketName, awsFileName, opts.Verify.expected(output.ETag, output.ChecksumCRC32C, output.ChecksumSHA256)); err != nil {
+			log.Errorf("上传到 %s:%s 校验失败, err= %v", bucketName, awsFileName, err)
+			return nil, err
+		}
+	}
+
+	// 5. 整理返回结果
+	result := &UploadResult{
+		Key:               awsFileName,
+		Location:          output.Location,
+		ETag:              aws.ToString(output.ETag),
+		VersionId:         aws.ToString(output.VersionID),
+		ChecksumAlgorithm: checksumAlgorithm,
+	}
+	if output.Key != nil {
+		result.Key = *output.Key
+	}
+	switch checksumAlgorithm {
+	case types.ChecksumAlgorithmCrc32:
+		result.Checksum = aws.ToString(output.ChecksumCRC32)
+	case types.ChecksumAlgorithmCrc32c:
+		result.Checksum = aws.ToString(output.ChecksumCRC32C)
+	case types.ChecksumAlgorithmCrc64nvme:
+		result.Checksum = aws.ToString(output.ChecksumCRC64NVME)
+	case types.ChecksumAlgorithmSha1:
+		result.Checksum = aws.ToString(output.ChecksumSHA1)
+	case types.ChecksumAlgorithmSha256:
+		result.Checksum = aws.ToString(output.ChecksumSHA256)
+	}
+	log.Debugf("上传到 %s:%s 成功, etag= %s, versionId= %s, checksum(%s)= %s",
+		bucketName, result.Key, result.ETag, result.VersionId, result.ChecksumAlgorithm, result.Checksum)
+	return result, nil
 }
 
-// 下载
+// 下载 - 流式写入磁盘, 不把整个文件读进内存
 /*
 参数:
 	ctx context.Contex : 上下文
 	bucketName string : 存储桶名称
 	awsFileName string : 对象key (文件key).使用时 xx【objectKey】
 	downloadFileName string : 下载的文件名。可以是相对路径/绝对路径，一般是绝对路径
+	opts ...DownloadOptions : 可选, 用到 Encryption (SSE-C 对象要传密钥) 和 Verify (下载校验)
 返回值:
 	error: 错误
 思路:
 	1. 准备
 	2. 处理错误
-	3. 如果目录不存在，就创建
-	4. 下载
-	5. 默认成功
-	6. 返回
+	3. 下载: 边读边写到临时文件, 校验大小后再改名
+	4. 默认成功
+	5. 返回
 */
-func (basics BucketBasics) ObjectDownload(ctx context.Context, bucketName string, awsFileName string, downloadFileName string) error {
+func (basics BucketBasics) ObjectDownload(ctx context.Context, bucketName string, awsFileName string, downloadFileName string, opts ...DownloadOptions) error {
 	// 1. 准备
+	var opt DownloadOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if err := opt.Encryption.validate(); err != nil {
+		return err
+	}
+	if err := opt.Verify.validate(); err != nil {
+		return err
+	}
 	// 读取aws文件
-	result, err := basics.S3Client.GetObject(ctx, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(awsFileName),
-	})
+	}
+	if opt.Verify != ChecksumModeNone {
+		input.ChecksumMode = types.ChecksumModeEnabled // 开了才会返回 SHA256/CRC32C 校验值
+	}
+	opt.Encryption.applyGet(input)
+	result, err := basics.clientFor(ctx, bucketName).GetObject(ctx, input)
 
 	// 2. 处理错误
 	if err != nil {
 		var noKey *types.NoSuchKey
+		var stateErr *types.InvalidObjectState
 		if errors.As(err, &noKey) {
 			log.Errorf("文件下载失败-文件不存在。%s: %s 不存在", bucketName, awsFileName)
 			err = noKey
+		} else if errors.As(err, &stateErr) {
+			log.Errorf("文件下载失败-归档存储 %s。%s: %s 要先 ObjectRestore 恢复, ObjectRestoreStatus 查到 Ready 后再下载", stateErr.StorageClass, bucketName, awsFileName)
 		}
 		log.Errorf("文件下载失败- %s: %s 无法下载, err = %v", bucketName, awsFileName, err)
 		return err
 	}
+	defer result.Body.Close() // 关闭aws 文件
 
-	// 3. 如果目录不存在，就创建
-	// 获取文件的目录
-	downloadDir := filepath.Dir(downloadFileName)
-	// 如果目录不存在，则创建
-	if _, err := os.Stat(downloadDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(downloadDir, 0755); err != nil {
-			log.Errorf("创建目录失败 %s, err= %v", downloadDir, err)
+	// 3. 下载: 边读边写到临时文件, 校验大小后再改名
+	expectSize := int64(-1) // -1 表示不知道大小, 不校验
+	if result.ContentLength != nil {
+		expectSize = *result.ContentLength
+	}
+	var body io.Reader = result.Body
+	var hasher *checksumHasher
+	var expected string
+	if opt.Verify != ChecksumModeNone { // 边下边算, 算的是 s3 上保存的数据 (客户端加密的就是密文)
+		hasher, expected, err = basics.downloadVerifier(ctx, bucketName, awsFileName, opt,
+			result.ETag, result.ChecksumCRC32C, result.ChecksumSHA256, result.ServerSideEncryption)
+		if err != nil {
+			log.Errorf("下载aws文件 [%s] 失败, err= %v", awsFileName, err)
 			return err
 		}
+		body = io.TeeReader(body, hasher)
+	}
+	if isClientEncrypted(result.Metadata) { // 客户端加密的, 边读边解密, 大小和密文不一样, 由 GCM 校验完整性
+		body, err = newDecryptReader(basics.CSEMasterKey, body, result.Metadata)
+		if err != nil {
+			log.Errorf("下载aws文件 [%s] 失败, err= %v", awsFileName, err)
+			return err
+		}
+		expectSize = -1
+	}
+	written, err := downloadToFile(downloadFileName, expectSize, func(file *os.File) (int64, error) {
+		n, err := io.Copy(file, body)
+		if err == nil && hasher != nil {
+			err = hasher.verify(bucketName, awsFileName, expected) // 不一致返回 *IntegrityError, 临时文件会被删掉
+		}
+		return n, err
+	})
+	if err != nil {
+		log.Errorf("下载aws文件 [%s] 到 -> [%s] 失败, err= %v", awsFileName, downloadFileName, err)
+		return err
 	}
 
-	// 4. 下载
-	defer result.Body.Close() // 关闭aws 文件
-	downloadFile, err := os.Create(downloadFileName)
+	// 4. 默认成功
+	log.Infof("下载aws文件 [%s] 到 -> [%s] 成功, 大小: %d", awsFileName, downloadFileName, written)
+
+	// 5. 返回
+	return nil
+}
+
+// 下载 - 返回数据流, 不写文件, 给要自己转发数据的调用方用 (如 storage 包、gin 直接返回)
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	enc ...Encryption : 可选, SSE-C 对象要传密钥
+返回值:
+	*s3.GetObjectOutput: GetObject 的响应, Body 用完要 Close; 客户端加密的对象 Body 是密文, 要解密用 ObjectDownload
+	error: 错误, 对象不存在时是 *types.NoSuchKey
+*/
+func (basics BucketBasics) ObjectGet(ctx context.Context, bucketName string, awsFileName string, enc ...Encryption) (*s3.GetObjectOutput, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	}
+	if len(enc) > 0 {
+		if err := enc[0].validate(); err != nil {
+			return nil, err
+		}
+		enc[0].applyGet(input)
+	}
+	output, err := basics.clientFor(ctx, bucketName).GetObject(ctx, input) // 用存储桶所在区域的客户端
 	if err != nil {
-		log.Errorf("创建文件失败 %s, err= %v", downloadFileName, err)
+		log.Errorf("读取 %s:%s 失败, err= %v", bucketName, awsFileName, err)
+		return nil, err
+	}
+	return output, nil
+}
+
+// 下载 - 并发分段下载, 用传输管理器 manager.Downloader, 适合大文件
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key (文件key).使用时 xx【objectKey】
+	downloadFileName string : 下载的文件名。可以是相对路径/绝对路径，一般是绝对路径
+	opts DownloadOptions : 分段大小、并发数、SSE-C 密钥、下载校验, 不填用默认值
+返回值:
+	error: 错误
+思路:
+	1. 准备, 先 HeadObject 拿到文件大小 (顺便判断文件存不存在)
+	2. 按字节范围并发下载到临时文件 (WriteAt 写到各自的位置)
+	3. 校验大小后改名
+	4. 返回
+*/
+func (basics BucketBasics) ObjectDownloadParallel(ctx context.Context, bucketName string, awsFileName string, downloadFileName string, opts DownloadOptions) error {
+	// 1. 准备
+	if err := opts.Encryption.validate(); err != nil {
 		return err
 	}
-	defer downloadFile.Close()                    // 关闭下载文件
-	awsFileStream, err := io.ReadAll(result.Body) // 读取aws文件内容
+	if err := opts.Verify.validate(); err != nil {
+		return err
+	}
+	headInput := &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	}
+	if opts.Verify != ChecksumModeNone {
+		headInput.ChecksumMode = types.ChecksumModeEnabled
+	}
+	opts.Encryption.applyHead(headInput)
+	head, err := basics.clientFor(ctx, bucketName).HeadObject(ctx, headInput)
 	if err != nil {
-		log.Errorf("读取aws文件流失败 %s, err= %v", awsFileName, err)
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			log.Errorf("文件下载失败-文件不存在。%s: %s 不存在", bucketName, awsFileName)
+			err = notFound
+		}
+		log.Errorf("文件下载失败- %s: %s 无法下载, err = %v", bucketName, awsFileName, err)
 		return err
 	}
-	_, err = downloadFile.Write(awsFileStream) // 写入下载文件
+	expectSize := int64(-1)
+	if head.ContentLength != nil {
+		expectSize = *head.ContentLength
+	}
+	if isClientEncrypted(head.Metadata) { // 分段乱序写没法流式解密, 改成顺序下载边读边解密
+		log.Infof("%s:%s 是客户端加密的, 改用顺序下载解密", bucketName, awsFileName)
+		return basics.ObjectDownload(ctx, bucketName, awsFileName, downloadFileName, opts)
+	}
+	var hasher *checksumHasher
+	var expected string
+	if opts.Verify != ChecksumModeNone {
+		hasher, expected, err = basics.downloadVerifier(ctx, bucketName, awsFileName, opts,
+			head.ETag, head.ChecksumCRC32C, head.ChecksumSHA256, head.ServerSideEncryption)
+		if err != nil {
+			log.Errorf("分段下载aws文件 [%s] 失败, err= %v", awsFileName, err)
+			return err
+		}
+	}
+
+	downloader := basics.downloaderFor(basics.clientFor(ctx, bucketName)) // 存储桶所在区域的下载器
+
+	// 2. 按字节范围并发下载到临时文件
+	written, err := downloadToFile(downloadFileName, expectSize, func(file *os.File) (int64, error) {
+		input := &s3.GetObjectInput{
+			Bucket:  aws.String(bucketName),
+			Key:     aws.String(awsFileName),
+			IfMatch: head.ETag, // 每一段都要是 HEAD 时的那个版本, 中途被覆盖会返回 412, 不会拼出新旧混在一起的文件
+		}
+		opts.Encryption.applyGet(input) // 每一段 GetObject 都会带上 SSE-C 密钥
+		n, err := downloader.Download(ctx, file, input, opts.apply)
+		if err != nil || hasher == nil {
+			return n, err
+		}
+		// 分段是乱序写的, 没法边下边算, 下完再从头读一遍临时文件
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return n, err
+		}
+		if _, err = io.Copy(hasher, file); err != nil {
+			return n, err
+		}
+		return n, hasher.verify(bucketName, awsFileName, expected)
+	})
+
+	// 3. 校验大小后改名 (downloadToFile 里做了)
 	if err != nil {
-		log.Errorf("写入下载文件失败 %s, err= %v", downloadFileName, err)
+		log.Errorf("分段下载aws文件 [%s] 到 -> [%s] 失败, err= %v", awsFileName, downloadFileName, err)
 		return err
 	}
 
-	// 5. 默认成功
-	log.Infof("下载aws文件 [%s] 到 -> [%s] 成功", awsFileName, downloadFileName)
-	return err
+	// 4. 返回
+	log.Infof("分段下载aws文件 [%s] 到 -> [%s] 成功, 大小: %d", awsFileName, downloadFileName, written)
+	return nil
+}
+
+// 下载到本地文件, 先写临时文件, 写完+校验大小后再改名成最终文件名
+/*
+参数:
+	fileName string : 最终文件名
+	expectSize int64 : 期望大小, 一般是 ContentLength。-1 表示不校验
+	write func(file *os.File) (int64, error) : 真正写数据的函数, 如 io.Copy / Downloader.Download
+返回值:
+	int64: 实际写入的字节数
+	error: 错误
+思路:
+	1. 如果目录不存在，就创建
+	2. 在同一目录下创建临时文件 (同目录才能保证 rename 是原子的)
+	3. 调用 write 写数据
+	4. 校验大小, 改权限, 刷盘, 关闭
+	5. 改名成最终文件名。中间任何一步失败都删掉临时文件, 保证最终文件名下不会有半截文件
+*/
+func downloadToFile(fileName string, expectSize int64, write func(file *os.File) (int64, error)) (int64, error) {
+	// 1. 如果目录不存在，就创建
+	downloadDir := filepath.Dir(fileName)
+	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+		log.Errorf("创建目录失败 %s, err= %v", downloadDir, err)
+		return 0, err
+	}
+
+	// 2. 在同一目录下创建临时文件
+	tmpFile, err := os.CreateTemp(downloadDir, "."+filepath.Base(fileName)+".*"+downloadTempSuffix)
+	if err != nil {
+		log.Errorf("创建临时文件失败 %s, err= %v", fileName, err)
+		return 0, err
+	}
+	tmpName := tmpFile.Name()
+	done := false // 是否已经改名成功
+	defer func() {
+		if !done {
+			tmpFile.Close()
+			os.Remove(tmpName) // 失败了, 删掉临时文件
+		}
+	}()
+
+	// 3. 调用 write 写数据
+	written, err := write(tmpFile)
+	if err != nil {
+		return written, fmt.Errorf("写入临时文件 %s 失败: %w", tmpName, err)
+	}
+	// 4. 校验大小, 改权限, 刷盘, 关闭
+	if expectSize >= 0 && written != expectSize {
+		return written, fmt.Errorf("文件大小不一致, 期望 %d, 实际写入 %d", expectSize, written)
+	}
+	if err = tmpFile.Chmod(0644); err != nil { // CreateTemp 建的是 0600, 改成和 os.Create 一样别人能读
+		return written, fmt.Errorf("修改临时文件权限失败 %s: %w", tmpName, err)
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return written, fmt.Errorf("刷盘失败 %s: %w", tmpName, err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return written, fmt.Errorf("关闭临时文件失败 %s: %w", tmpName, err)
+	}
+
+	// 5. 改名成最终文件名
+	if err = os.Rename(tmpName, fileName); err != nil {
+		os.Remove(tmpName)
+		done = true // 已经关闭+删除了
+		return written, fmt.Errorf("改名 %s -> %s 失败: %w", tmpName, fileName, err)
+	}
+	done = true
+	return written, nil
+}
+
+// 是不是 downloadToFile 创建的临时文件: ".文件名.随机数字.download"
+func isDownloadTempFile(name string) bool {
+	rest, ok := strings.CutSuffix(name, downloadTempSuffix)
+	if !ok || !strings.HasPrefix(rest, ".") {
+		return false
+	}
+	i := strings.LastIndex(rest, ".")
+	if i <= 1 || i == len(rest)-1 { // 前面要有文件名, 后面要有随机数字
+		return false
+	}
+	for _, c := range rest[i+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,241 @@
+package mys3
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestObjectUploadAndDownload(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	data := []byte("充满各种变态行为的家")
+	opts := UploadOptions{
+		Metadata:     map[string]string{"comic-id": "1024"},
+		Tags:         map[string]string{"country": "kr"},
+		CacheControl: "max-age=60",
+	}
+
+	if _, err := basics.ObjectUpload(ctx, testBucket, "comic/1.txt", writeTempFile(t, "1.txt", data), opts); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+	metadata, err := basics.ObjectMetadataGet(ctx, testBucket, "comic/1.txt")
+	if err != nil {
+		t.Fatalf("查询元数据失败: %v", err)
+	}
+	if metadata.Size != int64(len(data)) || !strings.HasPrefix(metadata.ContentType, "text/plain") ||
+		metadata.Metadata["comic-id"] != "1024" || metadata.StorageClass != types.StorageClassStandard {
+		t.Fatalf("元数据不对: %+v", metadata)
+	}
+	if tags, err := basics.ObjectTagsGet(ctx, testBucket, "comic/1.txt"); err != nil || tags["country"] != "kr" {
+		t.Fatalf("标签不对: %v, err= %v", tags, err)
+	}
+
+	downloadFileName := filepath.Join(t.TempDir(), "download.txt")
+	if err = basics.ObjectDownload(ctx, testBucket, "comic/1.txt", downloadFileName, DownloadOptions{Verify: ChecksumModeSHA256}); err != nil {
+		t.Fatalf("下载失败: %v", err)
+	}
+	if got, _ := os.ReadFile(downloadFileName); !bytes.Equal(got, data) {
+		t.Fatalf("下载的内容不对: %q", got)
+	}
+	info, err := os.Stat(downloadFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0644 {
+		t.Fatalf("下载的文件权限应该是 0644, 现在是 %v", info.Mode())
+	}
+
+	var notFound *types.NotFound
+	if _, err = basics.ObjectMetadataGet(ctx, testBucket, "comic/2.txt"); !errors.As(err, &notFound) {
+		t.Fatalf("不存在的对象应该返回 NotFound, err= %v", err)
+	}
+}
+
+func TestObjectUploadStreamMultipart(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	data := randomBytes(t, 11*1024*1024) // 5MB + 5MB + 1MB, 三段
+
+	result, err := basics.ObjectUploadStream(ctx, testBucket, "big.bin", bytes.NewBuffer(data),
+		UploadOptions{PartSize: 5 * 1024 * 1024, Verify: ChecksumModeSHA256})
+	if err != nil {
+		t.Fatalf("分段上传失败: %v", err)
+	}
+	if !strings.HasSuffix(result.Checksum, "-3") || !strings.HasSuffix(result.ETag, `-3"`) {
+		t.Fatalf("分段上传应该是3段的组合校验值, result= %+v", result)
+	}
+
+	localFileName := writeTempFile(t, "big.bin", data)
+	if err = basics.ObjectVerify(ctx, testBucket, "big.bin", localFileName, ChecksumModeSHA256); err != nil {
+		t.Fatalf("SHA256 校验失败: %v", err)
+	}
+	if err = basics.ObjectVerify(ctx, testBucket, "big.bin", localFileName, ChecksumModeMD5); err != nil {
+		t.Fatalf("MD5 校验失败: %v", err)
+	}
+
+	downloadFileName := filepath.Join(t.TempDir(), "download.bin")
+	err = basics.ObjectDownloadParallel(ctx, testBucket, "big.bin", downloadFileName,
+		DownloadOptions{PartSize: 5 * 1024 * 1024, Verify: ChecksumModeSHA256})
+	if err != nil {
+		t.Fatalf("并发下载失败: %v", err)
+	}
+	if got, _ := os.ReadFile(downloadFileName); !bytes.Equal(got, data) {
+		t.Fatal("并发下载的内容不对")
+	}
+}
+
+func TestObjectDownloadCorrupted(t *testing.T) {
+	ctx, basics, srv := newTestBucket(t)
+	localFileName := writeTempFile(t, "a.bin", randomBytes(t, 1024))
+	if _, err := basics.ObjectUpload(ctx, testBucket, "a.bin", localFileName); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+	srv.CorruptObject(testBucket, "a.bin", randomBytes(t, 1024))
+
+	downloadFileName := filepath.Join(t.TempDir(), "download.bin")
+	if err := basics.ObjectDownload(ctx, testBucket, "a.bin", downloadFileName, DownloadOptions{Verify: ChecksumModeMD5}); err == nil {
+		t.Fatal("内容被改过, 下载校验应该失败")
+	}
+	if _, err := os.Stat(downloadFileName); !os.IsNotExist(err) {
+		t.Fatal("校验失败不应该留下下载文件")
+	}
+	var integrityErr *IntegrityError
+	otherFileName := writeTempFile(t, "b.bin", randomBytes(t, 1024))
+	if err := basics.ObjectVerify(ctx, testBucket, "a.bin", otherFileName, ChecksumModeSHA256); !errors.As(err, &integrityErr) {
+		t.Fatalf("本地文件和 s3 上的不一样, 应该返回 IntegrityError, err= %v", err)
+	}
+}
+
+func TestObjectClientEncrypt(t *testing.T) {
+	ctx, basics, srv := newTestBucket(t)
+	basics.CSEMasterKey = randomBytes(t, 32)
+	data := randomBytes(t, 200*1024)
+
+	if _, err := basics.ObjectUpload(ctx, testBucket, "secret.bin", writeTempFile(t, "secret.bin", data), UploadOptions{ClientEncrypt: true}); err != nil {
+		t.Fatalf("客户端加密上传失败: %v", err)
+	}
+	if stored, _ := srv.ObjectData(testBucket, "secret.bin"); bytes.Contains(stored, data[:1024]) {
+		t.Fatal("s3 上存的应该是密文")
+	}
+
+	downloadFileName := filepath.Join(t.TempDir(), "secret.bin")
+	if err := basics.ObjectDownload(ctx, testBucket, "secret.bin", downloadFileName); err != nil {
+		t.Fatalf("客户端解密下载失败: %v", err)
+	}
+	if got, _ := os.ReadFile(downloadFileName); !bytes.Equal(got, data) {
+		t.Fatal("解密后的内容不对")
+	}
+
+	basics.CSEMasterKey = randomBytes(t, 32)
+	if err := basics.ObjectDownload(ctx, testBucket, "secret.bin", filepath.Join(t.TempDir(), "wrong.bin")); err == nil {
+		t.Fatal("主密钥不对, 下载应该失败")
+	}
+}
+
+func TestObjectSSEC(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	enc := Encryption{Mode: EncryptionSSEC, CustomerKey: randomBytes(t, 32)}
+	if err := basics.FileUploadLowApi(ctx, testBucket, "ssec.txt", writeTempFile(t, "ssec.txt", []byte("hello")), UploadOptions{Encryption: enc}); err != nil {
+		t.Fatalf("SSE-C 上传失败: %v", err)
+	}
+
+	downloadFileName := filepath.Join(t.TempDir(), "ssec.txt")
+	if err := basics.ObjectDownload(ctx, testBucket, "ssec.txt", downloadFileName); err == nil {
+		t.Fatal("SSE-C 对象不带密钥, 下载应该失败")
+	}
+	if err := basics.ObjectDownload(ctx, testBucket, "ssec.txt", downloadFileName, DownloadOptions{Encryption: enc}); err != nil {
+		t.Fatalf("SSE-C 下载失败: %v", err)
+	}
+	if got, _ := os.ReadFile(downloadFileName); string(got) != "hello" {
+		t.Fatalf("下载的内容不对: %q", got)
+	}
+}
+
+func TestFileUploadLowApiErrors(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	if err := basics.FileUploadLowApi(ctx, testBucket, "missing.txt", filepath.Join(t.TempDir(), "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("本地文件不存在应该返回打开文件的错误, err= %v", err)
+	}
+	if err := basics.FileUploadLowApi(ctx, "no-such-bucket", "a.txt", writeTempFile(t, "a.txt", []byte("a"))); errorCode(err) != "NoSuchBucket" {
+		t.Fatalf("存储桶不存在应该返回上传的错误, err= %v", err)
+	}
+}
+
+func TestObjectVerifySSEC(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	enc := Encryption{Mode: EncryptionSSEC, CustomerKey: randomBytes(t, 32)}
+	data := randomBytes(t, 11*1024*1024)
+	_, err := basics.ObjectUploadStream(ctx, testBucket, "ssec.bin", bytes.NewBuffer(data),
+		UploadOptions{PartSize: 5 * 1024 * 1024, Encryption: enc})
+	if err != nil {
+		t.Fatalf("SSE-C 分段上传失败: %v", err)
+	}
+
+	// HEAD 和查分段大小都要带密钥
+	localFileName := writeTempFile(t, "ssec.bin", data)
+	if err = basics.ObjectVerify(ctx, testBucket, "ssec.bin", localFileName, ChecksumModeSHA256); err == nil {
+		t.Fatal("SSE-C 对象不带密钥, 校验应该失败")
+	}
+	if err = basics.ObjectVerify(ctx, testBucket, "ssec.bin", localFileName, ChecksumModeSHA256, enc); err != nil {
+		t.Fatalf("SSE-C 校验失败: %v", err)
+	}
+}
+
+func TestObjectDelete(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	var objs []types.ObjectIdentifier
+	for _, key := range []string{"a.txt", "b.txt", "c.txt"} {
+		if _, err := basics.ObjectUpload(ctx, testBucket, key, writeTempFile(t, key, []byte(key))); err != nil {
+			t.Fatalf("上传 %s 失败: %v", key, err)
+		}
+		objs = append(objs, types.ObjectIdentifier{Key: aws.String(key)})
+	}
+
+	if deleted, err := basics.ObjectDelete(ctx, testBucket, "a.txt", "", false); !deleted || err != nil {
+		t.Fatalf("删除失败, deleted= %v, err= %v", deleted, err)
+	}
+	result, err := basics.ObjectDeleteBatch(ctx, testBucket, objs[1:], BatchDeleteOptions{WaitForAbsence: true})
+	if err != nil || len(result.Deleted) != 2 || len(result.Failed) != 0 {
+		t.Fatalf("批量删除不对, result= %+v, err= %v", result, err)
+	}
+	if objects, err := basics.ObjectQueryAll(ctx, testBucket); err != nil || len(objects) != 0 {
+		t.Fatalf("删除后应该没有对象了, objects= %v, err= %v", objects, err)
+	}
+}
+
+func TestObjectList(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	for _, key := range []string{"a/1.txt", "a/2.txt", "b/1.txt", "c.txt"} {
+		if err := basics.FileUploadLowApi(ctx, testBucket, key, writeTempFile(t, "f.txt", []byte(key))); err != nil {
+			t.Fatalf("上传 %s 失败: %v", key, err)
+		}
+	}
+
+	// 按目录分页: 第一页是 a/ b/, 第二页是 c.txt
+	page, err := basics.ObjectList(ctx, testBucket, ListOptions{Delimiter: "/", MaxKeys: 2})
+	if err != nil || !page.IsTruncated || len(page.Objects) != 0 || strings.Join(page.CommonPrefixes, ",") != "a/,b/" {
+		t.Fatalf("第一页不对: %+v, err= %v", page, err)
+	}
+	page, err = basics.ObjectList(ctx, testBucket, ListOptions{Delimiter: "/", MaxKeys: 2, ContinuationToken: page.NextContinuationToken})
+	if err != nil || page.IsTruncated || len(page.Objects) != 1 || *page.Objects[0].Key != "c.txt" {
+		t.Fatalf("第二页不对: %+v, err= %v", page, err)
+	}
+
+	// 迭代器自动翻页
+	var keys []string
+	for entry, err := range basics.ObjectIter(ctx, testBucket, ListOptions{Prefix: "a/", MaxKeys: 1}) {
+		if err != nil {
+			t.Fatalf("迭代失败: %v", err)
+		}
+		keys = append(keys, *entry.Object.Key)
+	}
+	if strings.Join(keys, ",") != "a/1.txt,a/2.txt" {
+		t.Fatalf("迭代结果不对: %v", keys)
+	}
+}
@@ -0,0 +1,212 @@
+// 功能: 存储桶权限管理, 存储桶策略(bucket policy)、阻止公共访问(public access block)、ACL
+package mys3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"study-aws-api-go/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// 全部阻止公共访问, 私有存储桶用
+var privatePublicAccessBlock = types.PublicAccessBlockConfiguration{
+	BlockPublicAcls:       aws.Bool(true),
+	IgnorePublicAcls:      aws.Bool(true),
+	BlockPublicPolicy:     aws.Bool(true),
+	RestrictPublicBuckets: aws.Bool(true),
+}
+
+// 查 - 存储桶策略
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	string: 策略 json, 没有设置时为 ""
+	error: 错误
+*/
+func (basics BucketBasics) BucketPolicyGet(ctx context.Context, bucketName string) (string, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucketPolicy" {
+			log.Debugf("存储桶 %s 没有存储桶策略", bucketName)
+			return "", nil
+		}
+		log.Errorf("查询存储桶 %s 策略失败, err= %v", bucketName, err)
+		return "", err
+	}
+	return aws.ToString(output.Policy), nil
+}
+
+// 改 - 设置存储桶策略
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	policy string : 策略 json, 如 {"Version":"2012-10-17","Statement":[...]}
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketPolicyPut(ctx context.Context, bucketName string, policy string) error {
+	if !json.Valid([]byte(policy)) {
+		return errors.New("设置存储桶策略失败, 策略不是合法的 json")
+	}
+	_, err := basics.clientFor(ctx, bucketName).PutBucketPolicy(ctx, &s3.PutBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+		Policy: aws.String(policy),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDenied" {
+			log.Errorf("设置存储桶 %s 策略被拒绝, 可能是开了阻止公共访问(BlockPublicPolicy), 而策略是公开的", bucketName)
+		}
+		log.Errorf("设置存储桶 %s 策略失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("设置存储桶 %s 策略成功", bucketName)
+	return nil
+}
+
+// 删 - 删除存储桶策略
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketPolicyDelete(ctx context.Context, bucketName string) error {
+	_, err := basics.clientFor(ctx, bucketName).DeleteBucketPolicy(ctx, &s3.DeleteBucketPolicyInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		log.Errorf("删除存储桶 %s 策略失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("删除存储桶 %s 策略成功", bucketName)
+	return nil
+}
+
+// 查 - 阻止公共访问设置
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	*types.PublicAccessBlockConfiguration: 4个开关, 没有设置时全是 false
+	error: 错误
+*/
+func (basics BucketBasics) BucketPublicAccessBlockGet(ctx context.Context, bucketName string) (*types.PublicAccessBlockConfiguration, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchPublicAccessBlockConfiguration" {
+			log.Debugf("存储桶 %s 没有设置阻止公共访问", bucketName)
+			return &types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(false),
+				IgnorePublicAcls:      aws.Bool(false),
+				BlockPublicPolicy:     aws.Bool(false),
+				RestrictPublicBuckets: aws.Bool(false),
+			}, nil
+		}
+		log.Errorf("查询存储桶 %s 阻止公共访问设置失败, err= %v", bucketName, err)
+		return nil, err
+	}
+	return output.PublicAccessBlockConfiguration, nil
+}
+
+// 改 - 设置阻止公共访问
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	config types.PublicAccessBlockConfiguration : 4个开关, 全开就是完全私有
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketPublicAccessBlockPut(ctx context.Context, bucketName string, config types.PublicAccessBlockConfiguration) error {
+	_, err := basics.clientFor(ctx, bucketName).PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+		Bucket:                         aws.String(bucketName),
+		PublicAccessBlockConfiguration: &config,
+	})
+	if err != nil {
+		log.Errorf("设置存储桶 %s 阻止公共访问失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Infof("设置存储桶 %s 阻止公共访问成功, BlockPublicAcls=%v, IgnorePublicAcls=%v, BlockPublicPolicy=%v, RestrictPublicBuckets=%v",
+		bucketName, aws.ToBool(config.BlockPublicAcls), aws.ToBool(config.IgnorePublicAcls),
+		aws.ToBool(config.BlockPublicPolicy), aws.ToBool(config.RestrictPublicBuckets))
+	return nil
+}
+
+// 删 - 删除阻止公共访问设置 (存储桶可以被设成公开的了, 小心)
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketPublicAccessBlockDelete(ctx context.Context, bucketName string) error {
+	_, err := basics.clientFor(ctx, bucketName).DeletePublicAccessBlock(ctx, &s3.DeletePublicAccessBlockInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		log.Errorf("删除存储桶 %s 阻止公共访问设置失败, err= %v", bucketName, err)
+		return err
+	}
+	log.Warnf("删除存储桶 %s 阻止公共访问设置成功, 存储桶现在可以被设成公开的了", bucketName)
+	return nil
+}
+
+// 查 - 存储桶 ACL
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	*s3.GetBucketAclOutput: 拥有者和授权列表
+	error: 错误
+*/
+func (basics BucketBasics) BucketAclGet(ctx context.Context, bucketName string) (*s3.GetBucketAclOutput, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetBucketAcl(ctx, &s3.GetBucketAclInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		log.Errorf("查询存储桶 %s ACL失败, err= %v", bucketName, err)
+		return nil, err
+	}
+	for _, grant := range output.Grants {
+		if grant.Grantee != nil {
+			log.Debugf("存储桶 %s ACL: %s %s%s -> %s", bucketName, grant.Grantee.Type,
+				aws.ToString(grant.Grantee.ID), aws.ToString(grant.Grantee.URI), grant.Permission)
+		}
+	}
+	return output, nil
+}
+
+// 改 - 设置存储桶预设 ACL (canned ACL)
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	acl types.BucketCannedACL : 预设 ACL, 如 private
+返回值:
+	error: 错误。存储桶开了 "强制存储桶拥有者"(BucketOwnerEnforced) 时 ACL 被禁用, 会报 AccessControlListNotSupported
+*/
+func (basics BucketBasics) BucketAclPut(ctx context.Context, bucketName string, acl types.BucketCannedACL) error {
+	_, err := basics.clientFor(ctx, bucketName).PutBucketAcl(ctx, &s3.PutBucketAclInput{
+		Bucket: aws.String(bucketName),
+		ACL:    acl,
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessControlListNotSupported" {
+			log.Errorf("存储桶 %s 禁用了ACL(BucketOwnerEnforced), 权限请用存储桶策略控制", bucketName)
+		}
+		log.Errorf("设置存储桶 %s ACL为 %s 失败, err= %v", bucketName, acl, err)
+		return err
+	}
+	log.Infof("设置存储桶 %s ACL为 %s 成功", bucketName, acl)
+	return nil
+}
@@ -0,0 +1,71 @@
+package mys3
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestBucketPolicy(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	if policy, err := basics.BucketPolicyGet(ctx, testBucket); err != nil || policy != "" {
+		t.Fatalf("没设置过应该没有策略, policy= %s, err= %v", policy, err)
+	}
+	if err := basics.BucketPolicyPut(ctx, testBucket, "{not json"); err == nil {
+		t.Fatal("不是 json 的策略应该报错")
+	}
+
+	policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*","Resource":"arn:aws:s3:::mys3-test/*","Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`
+	if err := basics.BucketPolicyPut(ctx, testBucket, policy); err != nil {
+		t.Fatalf("设置存储桶策略失败: %v", err)
+	}
+	got, err := basics.BucketPolicyGet(ctx, testBucket)
+	if err != nil || got != policy {
+		t.Fatalf("读回来的策略不一样, policy= %s, err= %v", got, err)
+	}
+
+	if err = basics.BucketPolicyDelete(ctx, testBucket); err != nil {
+		t.Fatalf("删除存储桶策略失败: %v", err)
+	}
+	if got, err = basics.BucketPolicyGet(ctx, testBucket); err != nil || got != "" {
+		t.Fatalf("删除后应该没有策略, policy= %s, err= %v", got, err)
+	}
+}
+
+func TestBucketPublicAccessBlock(t *testing.T) {
+	ctx, basics, _ := newTestBasics(t)
+
+	// 私有存储桶建好就全部阻止公共访问
+	if err := basics.BucketAdd(ctx, testBucket, "ap-northeast-1", BucketAddOptions{Private: true}); err != nil {
+		t.Fatalf("创建私有存储桶失败: %v", err)
+	}
+	config, err := basics.BucketPublicAccessBlockGet(ctx, testBucket)
+	if err != nil || !allPublicAccessBlocked(config, true) {
+		t.Fatalf("私有存储桶应该全部阻止公共访问, config= %+v, err= %v", config, err)
+	}
+
+	// 删掉后查询是全 false
+	if err = basics.BucketPublicAccessBlockDelete(ctx, testBucket); err != nil {
+		t.Fatalf("删除阻止公共访问失败: %v", err)
+	}
+	if config, err = basics.BucketPublicAccessBlockGet(ctx, testBucket); err != nil || !allPublicAccessBlocked(config, false) {
+		t.Fatalf("删除后应该全是 false, config= %+v, err= %v", config, err)
+	}
+
+	// 只开一个
+	if err = basics.BucketPublicAccessBlockPut(ctx, testBucket, types.PublicAccessBlockConfiguration{BlockPublicPolicy: aws.Bool(true)}); err != nil {
+		t.Fatalf("设置阻止公共访问失败: %v", err)
+	}
+	if config, err = basics.BucketPublicAccessBlockGet(ctx, testBucket); err != nil ||
+		!aws.ToBool(config.BlockPublicPolicy) || aws.ToBool(config.BlockPublicAcls) || aws.ToBool(config.IgnorePublicAcls) || aws.ToBool(config.RestrictPublicBuckets) {
+		t.Fatalf("应该只开了 BlockPublicPolicy, config= %+v, err= %v", config, err)
+	}
+}
+
+// 4个开关是不是都等于 want
+func allPublicAccessBlocked(config *types.PublicAccessBlockConfiguration, want bool) bool {
+	return config != nil &&
+		aws.ToBool(config.BlockPublicAcls) == want && aws.ToBool(config.IgnorePublicAcls) == want &&
+		aws.ToBool(config.BlockPublicPolicy) == want && aws.ToBool(config.RestrictPublicBuckets) == want
+}
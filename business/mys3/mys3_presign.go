@@ -0,0 +1,168 @@
+// 功能: 生成预签名url, 给前端临时访问对象 (看图片、直传文件)
+package mys3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"study-aws-api-go/log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// 变量
+const (
+	defaultPresignExpires = 15 * time.Minute   // 预签名默认有效期
+	maxPresignExpires     = 7 * 24 * time.Hour // s3 预签名最长 7 天
+)
+
+// 预签名选项
+type PresignOptions struct {
+	Expires          time.Duration // 有效期, <=0 默认15分钟, 最长7天
+	ContentType      string        // GET: 响应的 Content-Type; PUT/POST: 限制上传的 Content-Type, 前端必须带上一样的
+	ContentLength    int64         // PUT: 限制上传大小必须等于这个值, <=0 不限制
+	MinContentLength int64         // POST: 上传大小下限
+	MaxContentLength int64         // POST: 上传大小上限, <=0 不限制
+}
+
+// 预签名结果, 直接给前端用
+type PresignResult struct {
+	URL     string            `json:"url"`              // 预签名url
+	Method  string            `json:"method"`           // http 方法, GET/PUT/POST
+	Header  http.Header       `json:"header,omitempty"` // GET/PUT: 前端请求时必须带上的头
+	Values  map[string]string `json:"values,omitempty"` // POST: 表单字段, 前端要原样放进 multipart/form-data, 文件字段 file 放最后
+	Expires time.Time         `json:"expires"`          // 过期时间
+}
+
+// 有效期, 没填用默认值, 超过7天按7天算
+func (opts PresignOptions) ExpiresOrDefault() time.Duration {
+	if opts.Expires <= 0 {
+		return defaultPresignExpires
+	}
+	return min(opts.Expires, maxPresignExpires)
+}
+
+// 预签名 - GET, 前端用来看/下载对象
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key (文件key)
+	opts PresignOptions : 有效期、响应的 Content-Type
+返回值:
+	*PresignResult: 预签名结果
+	error: 错误
+*/
+func (basics BucketBasics) ObjectPresignGet(ctx context.Context, bucketName string, awsFileName string, opts PresignOptions) (*PresignResult, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	}
+	if opts.ContentType != "" {
+		input.ResponseContentType = aws.String(opts.ContentType)
+	}
+
+	expires := opts.ExpiresOrDefault()
+	request, err := s3.NewPresignClient(basics.clientFor(ctx, bucketName)).PresignGetObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		log.Errorf("生成GET预签名url失败 %s:%s, err= %v", bucketName, awsFileName, err)
+		return nil, err
+	}
+	log.Debugf("生成GET预签名url成功 %s:%s, 有效期 %v", bucketName, awsFileName, expires)
+	return &PresignResult{
+		URL:     request.URL,
+		Method:  request.Method,
+		Header:  request.SignedHeader,
+		Expires: time.Now().Add(expires),
+	}, nil
+}
+
+// 预签名 - PUT, 前端用来直传对象
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key (文件key)
+	opts PresignOptions : 有效期、Content-Type、大小限制
+返回值:
+	*PresignResult: 预签名结果, Header 里的头前端上传时必须带上
+	error: 错误
+*/
+func (basics BucketBasics) ObjectPresignPut(ctx context.Context, bucketName string, awsFileName string, opts PresignOptions) (*PresignResult, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType) // 签进去了, 前端传别的类型会 403
+	}
+	if opts.ContentLength > 0 {
+		input.ContentLength = aws.Int64(opts.ContentLength) // 签进去了, 大小不对会 403
+	}
+
+	expires := opts.ExpiresOrDefault()
+	request, err := s3.NewPresignClient(basics.clientFor(ctx, bucketName)).PresignPutObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		log.Errorf("生成PUT预签名url失败 %s:%s, err= %v", bucketName, awsFileName, err)
+		return nil, err
+	}
+	log.Debugf("生成PUT预签名url成功 %s:%s, 有效期 %v", bucketName, awsFileName, expires)
+	return &PresignResult{
+		URL:     request.URL,
+		Method:  request.Method,
+		Header:  request.SignedHeader,
+		Expires: time.Now().Add(expires),
+	}, nil
+}
+
+// 预签名 - POST policy, 前端用表单直传对象, 可以限制大小范围
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key (文件key)
+	opts PresignOptions : 有效期、Content-Type、大小范围
+返回值:
+	*PresignResult: 预签名结果, Values 是表单字段
+	error: 错误
+*/
+func (basics BucketBasics) ObjectPresignPost(ctx context.Context, bucketName string, awsFileName string, opts PresignOptions) (*PresignResult, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	}
+
+	// policy 条件
+	var conditions []interface{}
+	if opts.ContentType != "" {
+		conditions = append(conditions, map[string]string{"Content-Type": opts.ContentType})
+	}
+	if opts.MaxContentLength > 0 {
+		if opts.MinContentLength > opts.MaxContentLength {
+			return nil, fmt.Errorf("生成POST预签名失败, 大小下限 %d 大于上限 %d", opts.MinContentLength, opts.MaxContentLength)
+		}
+		conditions = append(conditions, []interface{}{"content-length-range", max(opts.MinContentLength, 0), opts.MaxContentLength})
+	}
+
+	expires := opts.ExpiresOrDefault()
+	request, err := s3.NewPresignClient(basics.clientFor(ctx, bucketName)).PresignPostObject(ctx, input, func(o *s3.PresignPostOptions) {
+		o.Expires = expires
+		o.Conditions = conditions
+	})
+	if err != nil {
+		log.Errorf("生成POST预签名失败 %s:%s, err= %v", bucketName, awsFileName, err)
+		return nil, err
+	}
+	if opts.ContentType != "" {
+		request.Values["Content-Type"] = opts.ContentType // 表单里也要带上, 不然对不上 policy
+	}
+	log.Debugf("生成POST预签名成功 %s:%s, 有效期 %v", bucketName, awsFileName, expires)
+	return &PresignResult{
+		URL:     request.URL,
+		Method:  http.MethodPost,
+		Values:  request.Values,
+		Expires: time.Now().Add(expires),
+	}, nil
+}
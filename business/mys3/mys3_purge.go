@@ -0,0 +1,168 @@
+// 功能: 按前缀删除一批对象 ("删目录"), 清空存储桶, 清空后删除存储桶
+package mys3
+
+import (
+	"context"
+	"errors"
+	"study-aws-api-go/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 清空选项
+type PurgeOptions struct {
+	DryRun           bool // true: 只列出要删的, 不真删
+	BypassGovernance bool // s3 的管理策略开关 , false -> 就是关
+}
+
+// 清空结果
+/*
+说明:
+	不是演练时不记每个删掉的key, 只计数, 删上百万个对象也不占内存; 失败的才记下来
+*/
+type PurgeResult struct {
+	DryRun         bool                     // 是否只是演练
+	Objects        []types.ObjectIdentifier // 要删的对象, 包括所有历史版本和删除标记, 只有 DryRun 时才有
+	ObjectCount    int                      // 找到几个对象版本, 包括删除标记
+	Uploads        []types.MultipartUpload  // 要清理的未完成分段上传
+	DeletedCount   int                      // 删除成功几个, DryRun 时为 0
+	Failed         []DeleteKeyResult        // 删除失败的, 有错误码和错误信息
+	AbortedUploads int                      // 清理了几个分段上传
+}
+
+// 删 - 删除某个前缀下的所有对象, 相当于删目录
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	prefix string : 前缀, 如 "亲家四姊妹/"。不能为空, 要清空整个存储桶用 BucketEmpty
+	opts PurgeOptions : 是否只演练
+返回值:
+	*PurgeResult: 要删的/删了的对象和分段上传
+	error: 错误
+*/
+func (basics BucketBasics) ObjectDeletePrefix(ctx context.Context, bucketName string, prefix string, opts PurgeOptions) (*PurgeResult, error) {
+	if prefix == "" {
+		return nil, errors.New("按前缀删除失败, 前缀不能为空, 要清空整个存储桶请用 BucketEmpty")
+	}
+	return basics.purge(ctx, bucketName, prefix, opts)
+}
+
+// 删 - 清空存储桶, 包括所有历史版本、删除标记、未完成的分段上传
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	opts PurgeOptions : 是否只演练
+返回值:
+	*PurgeResult: 要删的/删了的对象和分段上传
+	error: 错误
+*/
+func (basics BucketBasics) BucketEmpty(ctx context.Context, bucketName string, opts PurgeOptions) (*PurgeResult, error) {
+	return basics.purge(ctx, bucketName, "", opts)
+}
+
+// 删 - 先清空再删除存储桶, BucketDelete 只能删空的存储桶
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	opts PurgeOptions : 是否只演练, 演练时不删除存储桶
+返回值:
+	*PurgeResult: 要删的/删了的对象和分段上传
+	error: 错误
+*/
+func (basics BucketBasics) BucketEmptyAndDelete(ctx context.Context, bucketName string, opts PurgeOptions) (*PurgeResult, error) {
+	result, err := basics.BucketEmpty(ctx, bucketName, opts)
+	if err != nil || opts.DryRun {
+		return result, err
+	}
+	return result, basics.BucketDelete(ctx, bucketName)
+}
+
+// 清空前缀下的所有东西
+/*
+思路:
+	1. 查未完成的分段上传, 不是演练就清理掉
+	2. 用 ListObjectVersions 一页一页查所有版本和删除标记 (没开版本控制也能用, 版本id是 "null")
+	3. 不是演练就每查一页删一页, 不用把上百万个key全放内存里再删
+*/
+func (basics BucketBasics) purge(ctx context.Context, bucketName string, prefix string, opts PurgeOptions) (*PurgeResult, error) {
+	result := &PurgeResult{DryRun: opts.DryRun}
+
+	// 1. 查未完成的分段上传, 不是演练就清理掉
+	uploads, err := basics.MultipartUploadsList(ctx, bucketName, prefix)
+	if err != nil {
+		return result, err
+	}
+	result.Uploads = uploads
+	for _, upload := range uploads {
+		if opts.DryRun {
+			log.Infof("[演练] 将清理分段上传 %s:%s, uploadId= %s", bucketName, aws.ToString(upload.Key), aws.ToString(upload.UploadId))
+			continue
+		}
+		_, err = basics.clientFor(ctx, bucketName).AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucketName),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err != nil {
+			log.Errorf("清理分段上传 %s:%s 失败, err= %v", bucketName, aws.ToString(upload.Key), err)
+			return result, err
+		}
+		result.AbortedUploads++
+	}
+
+	// 2. 一页一页查所有版本和删除标记
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(bucketName)}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	var deleteErr error
+	paginator := s3.NewListObjectVersionsPaginator(basics.clientFor(ctx, bucketName), input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Errorf("查询存储桶 %s 对象版本失败, prefix= %s. reason: %v", bucketName, prefix, err)
+			return result, err
+		}
+		var ids []types.ObjectIdentifier
+		for _, version := range output.Versions {
+			ids = append(ids, types.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, marker := range output.DeleteMarkers {
+			ids = append(ids, types.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+		}
+		result.ObjectCount += len(ids)
+		if len(ids) == 0 {
+			continue
+		}
+		if opts.DryRun {
+			result.Objects = append(result.Objects, ids...)
+			for _, id := range ids {
+				log.Infof("[演练] 将删除 %s:%s, versionId= %s", bucketName, aws.ToString(id.Key), aws.ToString(id.VersionId))
+			}
+			continue
+		}
+
+		// 3. 每查一页删一页
+		deleted, err := basics.ObjectDeleteBatch(ctx, bucketName, ids, BatchDeleteOptions{BypassGovernance: opts.BypassGovernance})
+		if deleted != nil {
+			result.DeletedCount += len(deleted.Deleted)
+			result.Failed = append(result.Failed, deleted.Failed...)
+		}
+		if err != nil && deleteErr == nil {
+			deleteErr = err // 继续删后面的, 最后再返回错误
+		}
+	}
+
+	if opts.DryRun {
+		log.Infof("[演练] 存储桶 %s 前缀 [%s] 下将删除 %d 个对象版本, 清理 %d 个分段上传", bucketName, prefix, result.ObjectCount, len(result.Uploads))
+		return result, nil
+	}
+	log.Infof("清空存储桶 %s 前缀 [%s], 删除 %d 个对象版本, 失败 %d 个, 清理 %d 个分段上传",
+		bucketName, prefix, result.DeletedCount, len(result.Failed), result.AbortedUploads)
+	return result, deleteErr
+}
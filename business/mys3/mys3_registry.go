@@ -0,0 +1,342 @@
+// 功能: 多账号多区域的 s3 客户端, 自动查存储桶所在区域并缓存, 每个操作用存储桶所在区域的客户端
+package mys3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"study-aws-api-go/log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// 变量
+const DefaultAccount = "default" // 默认账号, BucketBasics.Account 为空时用
+
+var regionErrorTTL = time.Minute // 查存储桶区域失败的结果缓存多久, 这段时间内不再查
+
+// 客户端注册表
+/*
+说明:
+	一个账号一套凭证, 每个 账号+区域 一个客户端, 用到的时候才创建
+	存储桶 -> 区域 查过一次就缓存, BucketAdd/BucketDelete 会自动更新
+	查失败了 (如存储桶不存在) 也缓存 regionErrorTTL, 免得每个操作都重新查一遍
+*/
+type ClientRegistry struct {
+	defaultRegion string // 默认区域, 查存储桶区域、ListBuckets 用
+
+	mu            sync.RWMutex
+	accounts      map[string]registryAccount // 账号 -> 凭证和客户端配置
+	clients       map[clientKey]*s3.Client   // 账号+区域 -> 客户端
+	bucketRegions map[bucketKey]string       // 账号+存储桶 -> 区域
+	regionErrors  map[bucketKey]regionError  // 账号+存储桶 -> 查区域失败的结果
+}
+
+// 查区域失败的结果
+type regionError struct {
+	err     error
+	expires time.Time // 过期时间, 过了再查
+}
+
+// 账号配置
+type registryAccount struct {
+	credentials aws.CredentialsProvider
+	optFns      []func(*s3.Options)
+}
+
+type clientKey struct {
+	account string
+	region  string
+}
+
+type bucketKey struct {
+	account string
+	bucket  string
+}
+
+// 创建客户端注册表
+/*
+参数:
+	defaultRegion string : 默认区域, 如 ap-northeast-1
+返回值:
+	*ClientRegistry: 客户端注册表, 要先 AccountAdd 再用
+*/
+func NewClientRegistry(defaultRegion string) *ClientRegistry {
+	return &ClientRegistry{
+		defaultRegion: defaultRegion,
+		accounts:      map[string]registryAccount{},
+		clients:       map[clientKey]*s3.Client{},
+		bucketRegions: map[bucketKey]string{},
+		regionErrors:  map[bucketKey]regionError{},
+	}
+}
+
+// 加账号, 同名账号会替换, 以前建的客户端和存储桶区域缓存都清掉
+/*
+参数:
+	account string : 账号名, 为空是默认账号
+	credentials aws.CredentialsProvider : 凭证, 用 NewCredentialsProvider 创建
+	optFns ...func(*s3.Options) : 可选, 修改客户端配置, 如 WithEndpoint
+*/
+func (r *ClientRegistry) AccountAdd(account string, credentials aws.CredentialsProvider, optFns ...func(*s3.Options)) {
+	account = accountName(account)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.accounts[account] = registryAccount{credentials: credentials, optFns: optFns}
+	for key := range r.clients {
+		if key.account == account {
+			delete(r.clients, key)
+		}
+	}
+	for key := range r.bucketRegions {
+		if key.account == account {
+			delete(r.bucketRegions, key)
+		}
+	}
+	for key := range r.regionErrors {
+		if key.account == account {
+			delete(r.regionErrors, key)
+		}
+	}
+}
+
+// 账号+区域的客户端, 没有就创建
+/*
+参数:
+	account string : 账号名, 为空是默认账号
+	region string : 区域, 为空用默认区域
+返回值:
+	*s3.Client: 客户端
+	error: 账号没加过
+*/
+func (r *ClientRegistry) Client(account string, region string) (*s3.Client, error) {
+	key := clientKey{account: accountName(account), region: region}
+	if key.region == "" {
+		key.region = r.defaultRegion
+	}
+	r.mu.RLock()
+	client, ok := r.clients[key]
+	r.mu.RUnlock()
+	if ok {
+		return client, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if client, ok = r.clients[key]; ok {
+		return client, nil
+	}
+	acc, ok := r.accounts[key.account]
+	if !ok {
+		return nil, fmt.Errorf("账号 %s 没有加到客户端注册表", key.account)
+	}
+	client = s3.New(s3.Options{Region: key.region, Credentials: acc.credentials}, acc.optFns...)
+	r.clients[key] = client
+	log.Infof("创建 s3 客户端, 账号= %s, 区域= %s", key.account, key.region)
+	return client, nil
+}
+
+// 存储桶所在区域, 先看缓存, 没有再查
+/*
+参数:
+	ctx context.Context : 上下文
+	account string : 账号名, 为空是默认账号
+	bucketName string : 存储桶名称
+返回值:
+	string: 区域, 如 us-west-2
+	error: 错误, 存储桶不存在时是 manager.BucketNotFound
+思路:
+	1. HeadBucket, 不管成功还是 301 重定向, 响应头 x-amz-bucket-region 都有区域
+	2. 有的兼容 s3 的服务不返回这个头, 再用 GetBucketLocation
+	3. 查失败了缓存 regionErrorTTL, 这段时间内直接返回上次的错误
+*/
+func (r *ClientRegistry) BucketRegion(ctx context.Context, account string, bucketName string) (string, error) {
+	key := bucketKey{account: accountName(account), bucket: bucketName}
+	r.mu.RLock()
+	region, ok := r.bucketRegions[key]
+	regionErr, failed := r.regionErrors[key]
+	r.mu.RUnlock()
+	if ok {
+		return region, nil
+	}
+	if failed && time.Now().Before(regionErr.expires) {
+		return "", regionErr.err
+	}
+
+	client, err := r.Client(key.account, r.defaultRegion)
+	if err != nil {
+		return "", err
+	}
+
+	// 1. HeadBucket
+	region, err = manager.GetBucketRegion(ctx, client, bucketName)
+	var notFound manager.BucketNotFound
+	if errors.As(err, &notFound) {
+		r.regionErrorSet(key, err)
+		return "", err
+	}
+
+	// 2. GetBucketLocation
+	if region == "" {
+		output, locErr := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucketName)})
+		if locErr != nil {
+			log.Errorf("查存储桶 %s 的区域失败, %v 内不再查, head err= %v, location err= %v", bucketName, regionErrorTTL, err, locErr)
+			r.regionErrorSet(key, locErr)
+			return "", locErr
+		}
+		region = locationRegion(output.LocationConstraint)
+	}
+
+	r.BucketRegionSet(key.account, bucketName, region)
+	log.Debugf("存储桶 %s 在区域 %s", bucketName, region)
+	return region, nil
+}
+
+// 记下存储桶所在区域, BucketAdd 建好桶后调用, 不用再查
+func (r *ClientRegistry) BucketRegionSet(account string, bucketName string, region string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := bucketKey{account: accountName(account), bucket: bucketName}
+	r.bucketRegions[key] = region
+	delete(r.regionErrors, key)
+}
+
+// 缓存查区域失败的结果, ctx 取消的不缓存, 下次还要查
+func (r *ClientRegistry) regionErrorSet(key bucketKey, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.regionErrors[key] = regionError{err: err, expires: time.Now().Add(regionErrorTTL)}
+}
+
+// 忘掉存储桶所在区域, BucketDelete 删掉桶后调用, 同名的桶可能在别的区域重建
+func (r *ClientRegistry) BucketRegionForget(account string, bucketName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := bucketKey{account: accountName(account), bucket: bucketName}
+	delete(r.bucketRegions, key)
+	delete(r.regionErrors, key)
+}
+
+// 存储桶所在区域的客户端
+/*
+参数:
+	ctx context.Context : 上下文
+	account string : 账号名, 为空是默认账号
+	bucketName string : 存储桶名称
+返回值:
+	*s3.Client: 客户端
+	error: 错误, 查不到区域或账号没加过
+*/
+func (r *ClientRegistry) ClientForBucket(ctx context.Context, account string, bucketName string) (*s3.Client, error) {
+	region, err := r.BucketRegion(ctx, account, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return r.Client(account, region)
+}
+
+// 存储桶所在区域的客户端, 没有 Registry 用 S3Client
+/*
+说明:
+	1. 存储桶不存在时用 S3Client 发请求, 由 s3 返回真正的错误 (NoSuchBucket、NotFound 等)
+	2. 别的原因查不到区域 (网络、权限等), 返回的客户端每个请求都直接失败, 错误就是查区域的错误,
+	   不会用默认区域的客户端去访问别的区域的存储桶
+*/
+func (basics BucketBasics) clientFor(ctx context.Context, bucketName string) *s3.Client {
+	if basics.Registry == nil || bucketName == "" {
+		return basics.S3Client
+	}
+	client, err := basics.Registry.ClientForBucket(ctx, basics.Account, bucketName)
+	if err == nil {
+		return client
+	}
+	var notFound manager.BucketNotFound
+	if errors.As(err, &notFound) {
+		return basics.S3Client
+	}
+	return failingClient(basics.S3Client, fmt.Errorf("查存储桶 %s 所在区域失败: %w", bucketName, err))
+}
+
+// 每个请求都直接返回 err 的客户端, 不发请求
+func failingClient(client *s3.Client, err error) *s3.Client {
+	return s3.New(client.Options(), func(o *s3.Options) {
+		o.Retryer = aws.NopRetryer{}
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RegionLookupError",
+				func(context.Context, middleware.InitializeInput, middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, err
+				}), middleware.Before)
+		})
+	})
+}
+
+// 指定区域的客户端, 建存储桶用; 没有 Registry 用 S3Client
+func (basics BucketBasics) regionClient(region string) (*s3.Client, error) {
+	if basics.Registry == nil {
+		return basics.S3Client, nil
+	}
+	return basics.Registry.Client(basics.Account, region)
+}
+
+// 默认区域, 建存储桶不填区域时用; 有 Registry 用它的默认区域, 没有用 S3Client 的区域
+func (basics BucketBasics) defaultRegion() string {
+	if basics.Registry != nil {
+		return basics.Registry.defaultRegion
+	}
+	return basics.S3Client.Options().Region
+}
+
+// 用这个客户端的上传器, 分段大小、并发数和 S3Manager 一样
+func (basics BucketBasics) uploaderFor(client *s3.Client) *manager.Uploader {
+	if basics.S3Manager == nil { // 没初始化, 临时建一个
+		return manager.NewUploader(client)
+	}
+	if basics.S3Manager.S3 == client {
+		return basics.S3Manager
+	}
+	uploader := *basics.S3Manager
+	uploader.S3 = client
+	return &uploader
+}
+
+// 用这个客户端的下载器, 分段大小、并发数和 S3Downloader 一样
+func (basics BucketBasics) downloaderFor(client *s3.Client) *manager.Downloader {
+	if basics.S3Downloader == nil { // 没初始化, 临时建一个
+		return manager.NewDownloader(client)
+	}
+	if basics.S3Downloader.S3 == client {
+		return basics.S3Downloader
+	}
+	downloader := *basics.S3Downloader
+	downloader.S3 = client
+	return &downloader
+}
+
+// LocationConstraint -> 区域: 空是 us-east-1, EU 是 eu-west-1 (老的写法)
+func locationRegion(location types.BucketLocationConstraint) string {
+	switch location {
+	case "":
+		return "us-east-1"
+	case types.BucketLocationConstraintEu:
+		return "eu-west-1"
+	default:
+		return string(location)
+	}
+}
+
+// 账号名, 为空是默认账号
+func accountName(account string) string {
+	if account == "" {
+		return DefaultAccount
+	}
+	return account
+}
@@ -0,0 +1,183 @@
+package mys3
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"study-aws-api-go/business/mys3/mys3test"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// 连内存版 s3 的客户端注册表, 默认区域 ap-northeast-1
+func newTestRegistry(srv *mys3test.Server) *ClientRegistry {
+	registry := NewClientRegistry("ap-northeast-1")
+	registry.AccountAdd("", aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("test", "test", "")), WithEndpoint(srv.URL, true, true))
+	return registry
+}
+
+func TestClientRegistryRouting(t *testing.T) {
+	ctx, basics, srv := newTestBasics(t)
+	basics.Registry = newTestRegistry(srv)
+
+	// 建桶时记下区域, 后面的操作都用 us-west-2 的客户端
+	if err := basics.BucketAdd(ctx, "west-bucket", "us-west-2"); err != nil {
+		t.Fatalf("创建存储桶失败: %v", err)
+	}
+	if region := basics.clientFor(ctx, "west-bucket").Options().Region; region != "us-west-2" {
+		t.Fatalf("应该用 us-west-2 的客户端, 现在是 %s", region)
+	}
+	if _, err := basics.ObjectUpload(ctx, "west-bucket", "a.txt", writeTempFile(t, "a.txt", []byte("hello"))); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+	downloadFileName := filepath.Join(t.TempDir(), "a.txt")
+	if err := basics.ObjectDownloadParallel(ctx, "west-bucket", "a.txt", downloadFileName, DownloadOptions{}); err != nil {
+		t.Fatalf("下载失败: %v", err)
+	}
+	if got, _ := os.ReadFile(downloadFileName); string(got) != "hello" {
+		t.Fatalf("下载的内容不对: %q", got)
+	}
+
+	// 同一个账号同一个区域只建一个客户端
+	west1, _ := basics.Registry.Client("", "us-west-2")
+	west2, _ := basics.Registry.Client(DefaultAccount, "us-west-2")
+	if west1 != west2 || west1 != basics.clientFor(ctx, "west-bucket") {
+		t.Fatal("同一个账号同一个区域应该是同一个客户端")
+	}
+	if _, err := basics.Registry.Client("other", "us-west-2"); err == nil {
+		t.Fatal("没加过的账号应该报错")
+	}
+
+	// 删桶后忘掉区域, 再查就是不存在
+	if _, err := basics.ObjectDelete(ctx, "west-bucket", "a.txt", "", false); err != nil {
+		t.Fatalf("删除对象失败: %v", err)
+	}
+	if err := basics.BucketDelete(ctx, "west-bucket"); err != nil {
+		t.Fatalf("删除存储桶失败: %v", err)
+	}
+	if _, err := basics.Registry.BucketRegion(ctx, "", "west-bucket"); err == nil {
+		t.Fatal("删掉的存储桶不应该还有区域")
+	}
+}
+
+func TestClientRegistryDetectRegion(t *testing.T) {
+	ctx, basics, srv := newTestBasics(t)
+	for bucketName, region := range map[string]string{"west-bucket": "us-west-2", "east-bucket": "us-east-1", "default-bucket": ""} {
+		if err := basics.BucketAdd(ctx, bucketName, region); err != nil {
+			t.Fatalf("创建存储桶 %s 失败: %v", bucketName, err)
+		}
+	}
+
+	// HeadBucket 的 x-amz-bucket-region
+	registry := newTestRegistry(srv)
+	if region, err := registry.BucketRegion(ctx, "", "west-bucket"); err != nil || region != "us-west-2" {
+		t.Fatalf("HeadBucket 查区域不对: %s, err= %v", region, err)
+	}
+
+	// 没有 x-amz-bucket-region, 用 GetBucketLocation, 空是 us-east-1
+	srv.NoRegionHeader = true
+	registry = newTestRegistry(srv)
+	if region, err := registry.BucketRegion(ctx, "", "west-bucket"); err != nil || region != "us-west-2" {
+		t.Fatalf("GetBucketLocation 查区域不对: %s, err= %v", region, err)
+	}
+	if region, err := registry.BucketRegion(ctx, "", "east-bucket"); err != nil || region != "us-east-1" {
+		t.Fatalf("没有 LocationConstraint 应该是 us-east-1: %s, err= %v", region, err)
+	}
+	// 建桶不填区域是默认区域, 不是 us-east-1
+	if region, err := registry.BucketRegion(ctx, "", "default-bucket"); err != nil || region != "ap-northeast-1" {
+		t.Fatalf("不填区域应该建在默认区域 ap-northeast-1: %s, err= %v", region, err)
+	}
+}
+
+func TestClientRegistryBucketAddDefaultRegion(t *testing.T) {
+	ctx, basics, srv := newTestBasics(t)
+	basics.Registry = NewClientRegistry("eu-west-1")
+	basics.Registry.AccountAdd("", aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("test", "test", "")), WithEndpoint(srv.URL, true, true))
+
+	// 不填区域用 Registry 的默认区域建, 缓存的也是这个区域
+	if err := basics.BucketAdd(ctx, "default-bucket", ""); err != nil {
+		t.Fatalf("创建存储桶失败: %v", err)
+	}
+	if region := basics.clientFor(ctx, "default-bucket").Options().Region; region != "eu-west-1" {
+		t.Fatalf("应该用 eu-west-1 的客户端, 现在是 %s", region)
+	}
+	if region, err := newTestRegistry(srv).BucketRegion(ctx, "", "default-bucket"); err != nil || region != "eu-west-1" {
+		t.Fatalf("存储桶应该建在 eu-west-1: %s, err= %v", region, err)
+	}
+}
+
+// 每个请求都失败, 记下发了几个请求
+type failingHTTPClient struct {
+	requests atomic.Int32
+}
+
+var errNetwork = errors.New("网络不通")
+
+func (c *failingHTTPClient) Do(*http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return nil, errNetwork
+}
+
+func TestClientRegistryRegionError(t *testing.T) {
+	ctx, basics, srv := newTestBasics(t)
+	httpClient := &failingHTTPClient{}
+	basics.Registry = NewClientRegistry("ap-northeast-1")
+	basics.Registry.AccountAdd("", aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("test", "test", "")), WithEndpoint(srv.URL, true, true), func(o *s3.Options) {
+		o.HTTPClient = httpClient
+		o.Retryer = aws.NopRetryer{}
+	})
+
+	// 查不到区域, 返回查区域的错误, 不用默认客户端发请求
+	if _, err := basics.BucketExists(ctx, "far-bucket"); !errors.Is(err, errNetwork) {
+		t.Fatalf("应该返回查区域的错误, err= %v", err)
+	}
+	requests := httpClient.requests.Load()
+	if requests == 0 {
+		t.Fatal("应该查过区域")
+	}
+
+	// 失败的结果缓存起来, 不再重复查
+	if _, err := basics.BucketExists(ctx, "far-bucket"); !errors.Is(err, errNetwork) {
+		t.Fatalf("应该返回缓存的查区域错误, err= %v", err)
+	}
+	if got := httpClient.requests.Load(); got != requests {
+		t.Fatalf("缓存期间不应该再查区域, 请求数 %d -> %d", requests, got)
+	}
+
+	// 记下区域后不再用缓存的错误
+	basics.Registry.BucketRegionSet("", "far-bucket", "us-west-2")
+	if region := basics.clientFor(ctx, "far-bucket").Options().Region; region != "us-west-2" {
+		t.Fatalf("应该用 us-west-2 的客户端, 现在是 %s", region)
+	}
+}
+
+func TestClientRegistryBucketNotFound(t *testing.T) {
+	ctx, basics, srv := newTestBasics(t)
+	basics.Registry = newTestRegistry(srv)
+
+	// 存储桶不存在, 用默认客户端发请求, 返回 s3 的错误
+	for range 2 {
+		if _, err := basics.ObjectGet(ctx, "no-such-bucket", "a.txt"); errorCode(err) != "NoSuchBucket" {
+			t.Fatalf("应该返回 NoSuchBucket, err= %v", err)
+		}
+	}
+	basics.Registry.mu.RLock()
+	_, cached := basics.Registry.regionErrors[bucketKey{account: DefaultAccount, bucket: "no-such-bucket"}]
+	basics.Registry.mu.RUnlock()
+	if !cached {
+		t.Fatal("存储桶不存在也应该缓存起来")
+	}
+
+	// 建桶后忘掉缓存的错误
+	if err := basics.BucketAdd(ctx, "no-such-bucket", "us-west-2"); err != nil {
+		t.Fatalf("创建存储桶失败: %v", err)
+	}
+	if region := basics.clientFor(ctx, "no-such-bucket").Options().Region; region != "us-west-2" {
+		t.Fatalf("应该用 us-west-2 的客户端, 现在是 %s", region)
+	}
+}
@@ -0,0 +1,203 @@
+// 功能: 存储类型, 修改已有对象的存储类型, Glacier 归档对象的恢复(取回)
+package mys3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"study-aws-api-go/log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// 变量
+const defaultRestorePollInterval = 5 * time.Minute // 等待恢复默认查询间隔, 标准恢复要3-5小时, 不用查太勤
+
+// x-amz-restore 头, 如: ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+var (
+	restoreOngoingRegexp = regexp.MustCompile(`ongoing-request="(true|false)"`)
+	restoreExpiryRegexp  = regexp.MustCompile(`expiry-date="([^"]+)"`)
+)
+
+// 对象的恢复状态
+type RestoreStatus struct {
+	StorageClass types.StorageClass `json:"storageClass"` // 存储类型
+	Archived     bool               `json:"archived"`     // 是否是归档存储, 要先恢复才能下载 (GLACIER、DEEP_ARCHIVE、智能分层的归档层)
+	InProgress   bool               `json:"inProgress"`   // 是否正在恢复
+	Ready        bool               `json:"ready"`        // 是否可以用 ObjectDownload 下载了
+	ExpiryDate   time.Time          `json:"expiryDate"`   // 恢复出来的临时副本什么时候过期, 没恢复过为零值
+}
+
+// 改 - 修改已有对象的存储类型 (复制到自己身上, 元数据和标签保留)
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	storageClass types.StorageClass : 新的存储类型, 如 STANDARD_IA / INTELLIGENT_TIERING / GLACIER_IR / DEEP_ARCHIVE
+	enc ...Encryption : 可选, SSE-C 对象要传密钥, 复制前后用同一个密钥
+返回值:
+	*CopyResult: 复制结果, 开了版本控制会生成一个新版本
+	error: 错误。GLACIER/DEEP_ARCHIVE 的对象要先 ObjectRestore 恢复了才能改
+说明:
+	加密方式保留: SSE-S3/SSE-KMS(含 KMS 密钥id) 从 HEAD 拿, SSE-C 用传进来的密钥
+*/
+func (basics BucketBasics) ObjectStorageClassSet(ctx context.Context, bucketName string, awsFileName string, storageClass types.StorageClass, enc ...Encryption) (*CopyResult, error) {
+	metadata, err := basics.ObjectMetadataGet(ctx, bucketName, awsFileName, enc...)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.StorageClass == storageClass {
+		log.Infof("%s:%s 已经是 %s, 不用改", bucketName, awsFileName, storageClass)
+		return &CopyResult{ETag: metadata.ETag, VersionId: metadata.VersionId}, nil
+	}
+
+	opts := CopyOptions{StorageClass: storageClass, Encryption: metadata.Encryption}
+	if metadata.Encryption.Mode == EncryptionSSEC { // 能 HEAD 成功说明传了密钥
+		opts.SourceEncryption, opts.Encryption = enc[0], enc[0]
+	}
+	result, err := basics.ObjectCopy(ctx, bucketName, awsFileName, bucketName, awsFileName, opts)
+	if err != nil {
+		var stateErr *types.InvalidObjectState
+		if errors.As(err, &stateErr) {
+			log.Errorf("%s:%s 是归档存储 %s, 要先 ObjectRestore 恢复了才能改存储类型", bucketName, awsFileName, metadata.StorageClass)
+		}
+		log.Errorf("修改 %s:%s 存储类型 %s -> %s 失败, err= %v", bucketName, awsFileName, metadata.StorageClass, storageClass, err)
+		return nil, err
+	}
+	log.Infof("修改 %s:%s 存储类型 %s -> %s 成功", bucketName, awsFileName, metadata.StorageClass, storageClass)
+	return result, nil
+}
+
+// 恢复 - 开始恢复 Glacier 归档对象, 恢复出一个临时副本, 过期后自动删掉, 归档的原对象不变
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	days int32 : 临时副本保留多少天
+	tier types.Tier : 恢复速度, Expedited(1-5分钟, DEEP_ARCHIVE 不支持) / Standard(3-5小时) / Bulk(5-12小时, 最便宜), 不填默认 Standard
+返回值:
+	error: 错误。已经在恢复中不算错误
+*/
+func (basics BucketBasics) ObjectRestore(ctx context.Context, bucketName string, awsFileName string, days int32, tier types.Tier) error {
+	if days <= 0 {
+		return fmt.Errorf("恢复 %s:%s 失败, 保留天数要大于0", bucketName, awsFileName)
+	}
+	if tier == "" {
+		tier = types.TierStandard
+	}
+	_, err := basics.clientFor(ctx, bucketName).RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(days),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: tier},
+		},
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "RestoreAlreadyInProgress":
+				log.Infof("%s:%s 已经在恢复中了", bucketName, awsFileName)
+				return nil
+			case "InvalidObjectState":
+				log.Errorf("%s:%s 不是归档存储, 不用恢复, 可以直接下载", bucketName, awsFileName)
+			}
+		}
+		log.Errorf("恢复 %s:%s 失败, err= %v", bucketName, awsFileName, err)
+		return err
+	}
+	log.Infof("开始恢复 %s:%s, 速度= %s, 保留 %d 天", bucketName, awsFileName, tier, days)
+	return nil
+}
+
+// 查 - 对象的恢复状态
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+返回值:
+	*RestoreStatus: 恢复状态, Ready=true 就可以 ObjectDownload 了
+	error: 错误
+*/
+func (basics BucketBasics) ObjectRestoreStatus(ctx context.Context, bucketName string, awsFileName string) (*RestoreStatus, error) {
+	head, err := basics.clientFor(ctx, bucketName).HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	})
+	if err != nil {
+		log.Errorf("查询 %s:%s 的恢复状态失败, err= %v", bucketName, awsFileName, err)
+		return nil, err
+	}
+
+	status := &RestoreStatus{StorageClass: head.StorageClass}
+	if status.StorageClass == "" {
+		status.StorageClass = types.StorageClassStandard
+	}
+	status.Archived = status.StorageClass == types.StorageClassGlacier ||
+		status.StorageClass == types.StorageClassDeepArchive ||
+		head.ArchiveStatus != "" // 智能分层的归档层
+	if restore := aws.ToString(head.Restore); restore != "" {
+		if m := restoreOngoingRegexp.FindStringSubmatch(restore); m != nil {
+			status.InProgress = m[1] == "true"
+		}
+		if m := restoreExpiryRegexp.FindStringSubmatch(restore); m != nil {
+			if expiry, err := time.Parse(time.RFC1123, m[1]); err == nil {
+				status.ExpiryDate = expiry
+			}
+		}
+		status.Ready = !status.InProgress
+	}
+	if !status.Archived {
+		status.Ready = true
+	}
+	log.Debugf("%s:%s 恢复状态: 存储类型= %s, 归档= %v, 恢复中= %v, 可下载= %v, 过期时间= %v",
+		bucketName, awsFileName, status.StorageClass, status.Archived, status.InProgress, status.Ready, status.ExpiryDate)
+	return status, nil
+}
+
+// 等待 - 轮询直到恢复完成可以下载
+/*
+参数:
+	ctx context.Contex : 上下文, 用超时/取消控制最多等多久
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	interval time.Duration : 查询间隔, <=0 默认5分钟
+返回值:
+	*RestoreStatus: 最后一次查到的状态
+	error: 错误。归档对象没开始恢复会直接报错, 不会一直等
+*/
+func (basics BucketBasics) ObjectRestoreWait(ctx context.Context, bucketName string, awsFileName string, interval time.Duration) (*RestoreStatus, error) {
+	if interval <= 0 {
+		interval = defaultRestorePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := basics.ObjectRestoreStatus(ctx, bucketName, awsFileName)
+		if err != nil {
+			return nil, err
+		}
+		if status.Ready {
+			log.Infof("%s:%s 恢复完成, 可以下载了, 过期时间: %v", bucketName, awsFileName, status.ExpiryDate)
+			return status, nil
+		}
+		if !status.InProgress {
+			return status, fmt.Errorf("%s:%s 是归档存储, 还没开始恢复, 请先 ObjectRestore", bucketName, awsFileName)
+		}
+		log.Debugf("%s:%s 恢复中, %v 后再查", bucketName, awsFileName, interval)
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
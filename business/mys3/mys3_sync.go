@@ -0,0 +1,421 @@
+// 功能: 本地目录 和 s3 前缀 双向同步, 类似 aws s3 sync
+package mys3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"study-aws-api-go/log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 比较方式, 决定哪些文件要同步
+type SyncCompareMode string
+
+const (
+	SyncCompareSize     SyncCompareMode = "size"     // 只比大小
+	SyncCompareMtime    SyncCompareMode = "mtime"    // 比大小 + 修改时间, 源更新就同步 (默认, 和 aws s3 sync 一样)
+	SyncCompareChecksum SyncCompareMode = "checksum" // 比大小 + md5(和 ETag 比), 分段上传的对象 ETag 不是 md5, 退回比修改时间
+)
+
+// 变量
+const defaultSyncParallel = 8 // 同步默认并发数
+
+// 同步选项
+type SyncOptions struct {
+	CompareBy   SyncCompareMode // 比较方式, 不填默认 mtime
+	Delete      bool            // true: 删除目标端有、源端没有的文件
+	Include     []string        // 只同步匹配的文件, 如 "*.jpg"、"亲家四姊妹/*", 不填表示全部
+	Exclude     []string        // 不同步匹配的文件, 如 "*.tmp"。目标端被排除的文件也不会被删除
+	Concurrency int             // 并发数, <=0 默认8
+	DryRun      bool            // true: 只列出要做的, 不真做
+}
+
+// 同步结果, 都是相对路径
+type SyncResult struct {
+	Copied  []string          // 上传/下载了的
+	Deleted []string          // 删除了的
+	Skipped []string          // 一样的, 没动
+	Failed  map[string]string // 失败的, 相对路径 -> 错误信息
+}
+
+// 同步时一个文件的信息
+type syncFile struct {
+	Rel     string    // 相对路径, 用 "/" 分隔
+	Size    int64     // 大小
+	ModTime time.Time // 本地: 修改时间; s3: LastModified
+	ETag    string    // 只有 s3 上的有
+}
+
+// 同步 - 本地目录 -> s3 前缀
+/*
+参数:
+	ctx context.Contex : 上下文
+	localDir string : 本地目录, 如 "C://home/manhua/亲家四姊妹"
+	bucketName string : 存储桶名称
+	prefix string : s3 前缀, 如 "亲家四姊妹/", 为空表示存储桶根目录
+	opts SyncOptions : 比较方式、是否删除、过滤、并发数、演练
+返回值:
+	*SyncResult: 同步结果
+	error: 错误, 有任何文件失败都会返回
+思路:
+	1. 列出本地文件 和 s3 对象
+	2. 比较, 找出要上传的 和 要删除的
+	3. 并发上传
+	4. (可选)删除 s3 上多余的对象
+*/
+func (basics BucketBasics) SyncLocalToS3(ctx context.Context, localDir string, bucketName string, prefix string, opts SyncOptions) (*SyncResult, error) {
+	prefix = normalizeSyncPrefix(prefix)
+
+	// 1. 列出本地文件 和 s3 对象
+	localFiles, err := listLocalFiles(localDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	remoteFiles, err := basics.listRemoteFiles(ctx, bucketName, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 比较
+	result := &SyncResult{Failed: map[string]string{}}
+	var toCopy []syncFile
+	for rel, local := range localFiles {
+		remote, ok := remoteFiles[rel]
+		if ok && !syncNeeded(local, remote, opts.CompareBy, filepath.Join(localDir, filepath.FromSlash(rel)), true) {
+			result.Skipped = append(result.Skipped, rel)
+			continue
+		}
+		toCopy = append(toCopy, local)
+	}
+	var toDelete []string
+	if opts.Delete {
+		for rel := range remoteFiles {
+			if _, ok := localFiles[rel]; !ok {
+				toDelete = append(toDelete, rel)
+			}
+		}
+	}
+
+	// 3. 并发上传
+	basics.runSyncTasks(ctx, toCopy, opts, result, func(file syncFile) error {
+		localFile, err := os.Open(filepath.Join(localDir, filepath.FromSlash(file.Rel)))
+		if err != nil {
+			return err
+		}
+		defer localFile.Close()
+		_, err = basics.ObjectUploadStream(ctx, bucketName, prefix+file.Rel, localFile, UploadOptions{})
+		return err
+	})
+
+	// 4. (可选)删除 s3 上多余的对象
+	if len(toDelete) > 0 {
+		if opts.DryRun {
+			for _, rel := range toDelete {
+				log.Infof("[演练] 将删除 %s:%s", bucketName, prefix+rel)
+			}
+			result.Deleted = append(result.Deleted, toDelete...)
+		} else {
+			objs := make([]types.ObjectIdentifier, 0, len(toDelete))
+			for _, rel := range toDelete {
+				objs = append(objs, types.ObjectIdentifier{Key: aws.String(prefix + rel)})
+			}
+			deleted, _ := basics.ObjectDeleteBatch(ctx, bucketName, objs, BatchDeleteOptions{})
+			if deleted != nil {
+				for _, d := range deleted.Deleted {
+					result.Deleted = append(result.Deleted, strings.TrimPrefix(d.Key, prefix))
+				}
+				for _, f := range deleted.Failed {
+					result.Failed[strings.TrimPrefix(f.Key, prefix)] = f.Code + ": " + f.Message
+				}
+			}
+		}
+	}
+
+	return result, result.logAndErr(fmt.Sprintf("%s -> %s:%s", localDir, bucketName, prefix), opts.DryRun)
+}
+
+// 同步 - s3 前缀 -> 本地目录
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	prefix string : s3 前缀, 如 "亲家四姊妹/", 为空表示存储桶根目录
+	localDir string : 本地目录, 不存在会自动创建
+	opts SyncOptions : 比较方式、是否删除、过滤、并发数、演练
+返回值:
+	*SyncResult: 同步结果
+	error: 错误, 有任何文件失败都会返回
+思路:
+	1. 列出 s3 对象 和 本地文件
+	2. 比较, 找出要下载的 和 要删除的
+	3. 并发下载, 下载后把本地修改时间设成 s3 的 LastModified, 下次比较 mtime 就一样了
+	4. (可选)删除本地多余的文件
+*/
+func (basics BucketBasics) SyncS3ToLocal(ctx context.Context, bucketName string, prefix string, localDir string, opts SyncOptions) (*SyncResult, error) {
+	prefix = normalizeSyncPrefix(prefix)
+
+	// 1. 列出 s3 对象 和 本地文件
+	remoteFiles, err := basics.listRemoteFiles(ctx, bucketName, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	localFiles := map[string]syncFile{}
+	if _, err = os.Stat(localDir); err == nil { // 本地目录不存在就当成空的, 下载时自动创建
+		if localFiles, err = listLocalFiles(localDir, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. 比较
+	result := &SyncResult{Failed: map[string]string{}}
+	var toCopy []syncFile
+	for rel, remote := range remoteFiles {
+		if !filepath.IsLocal(filepath.FromSlash(rel)) { // key 里有 ".."、绝对路径等, 拼出来会写到 localDir 外面去
+			log.Warnf("对象 %s:%s 的路径不安全, 不下载", bucketName, prefix+rel)
+			result.Failed[rel] = "路径不安全, 会写到本地目录外面"
+			continue
+		}
+		local, ok := localFiles[rel]
+		if ok && !syncNeeded(remote, local, opts.CompareBy, filepath.Join(localDir, filepath.FromSlash(rel)), false) {
+			result.Skipped = append(result.Skipped, rel)
+			continue
+		}
+		toCopy = append(toCopy, remote)
+	}
+
+	// 3. 并发下载
+	basics.runSyncTasks(ctx, toCopy, opts, result, func(file syncFile) error {
+		localPath := filepath.Join(localDir, filepath.FromSlash(file.Rel))
+		if err := basics.ObjectDownload(ctx, bucketName, prefix+file.Rel, localPath); err != nil {
+			return err
+		}
+		return os.Chtimes(localPath, file.ModTime, file.ModTime)
+	})
+
+	// 4. (可选)删除本地多余的文件
+	if opts.Delete {
+		for rel := range localFiles {
+			if _, ok := remoteFiles[rel]; ok {
+				continue
+			}
+			if opts.DryRun {
+				log.Infof("[演练] 将删除本地文件 %s", rel)
+			} else if err := os.Remove(filepath.Join(localDir, filepath.FromSlash(rel))); err != nil {
+				result.Failed[rel] = err.Error()
+				continue
+			}
+			result.Deleted = append(result.Deleted, rel)
+		}
+	}
+
+	return result, result.logAndErr(fmt.Sprintf("%s:%s -> %s", bucketName, prefix, localDir), opts.DryRun)
+}
+
+// 并发执行上传/下载任务, 结果写进 result
+func (basics BucketBasics) runSyncTasks(ctx context.Context, files []syncFile, opts SyncOptions, result *SyncResult, transfer func(file syncFile) error) {
+	if opts.DryRun {
+		for _, file := range files {
+			log.Infof("[演练] 将同步 %s", file.Rel)
+			result.Copied = append(result.Copied, file.Rel)
+		}
+		return
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultSyncParallel
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	fileChan := make(chan syncFile)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range fileChan {
+				err := transfer(file)
+				mu.Lock()
+				if err != nil {
+					result.Failed[file.Rel] = err.Error()
+				} else {
+					result.Copied = append(result.Copied, file.Rel)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		fileChan <- file
+	}
+	close(fileChan)
+	wg.Wait()
+}
+
+// 打印同步结果, 有失败的就返回错误
+func (result *SyncResult) logAndErr(direction string, dryRun bool) error {
+	tag := ""
+	if dryRun {
+		tag = "[演练] "
+	}
+	for rel, msg := range result.Failed {
+		log.Errorf("%s同步 %s 失败, err= %s", tag, rel, msg)
+	}
+	log.Infof("%s同步 %s 完成, 同步 %d 个, 删除 %d 个, 跳过 %d 个, 失败 %d 个",
+		tag, direction, len(result.Copied), len(result.Deleted), len(result.Skipped), len(result.Failed))
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("同步 %s, %d 个文件失败", direction, len(result.Failed))
+	}
+	return nil
+}
+
+// 列出本地目录下的所有文件 (相对路径 -> 文件信息), 跳过下载临时文件和断点文件
+func listLocalFiles(localDir string, opts SyncOptions) (map[string]syncFile, error) {
+	files := map[string]syncFile{}
+	err := filepath.WalkDir(localDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		name := entry.Name()
+		if isDownloadTempFile(name) || strings.HasSuffix(name, checkpointFileSuffix) || strings.HasSuffix(name, checkpointFileSuffix+".tmp") {
+			return nil // 下载的临时文件、断点续传的断点文件和正在写的断点临时文件, 不同步
+		}
+		rel, err := filepath.Rel(localDir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !syncMatch(rel, opts) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files[rel] = syncFile{Rel: rel, Size: info.Size(), ModTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("列出本地目录 %s 失败, err= %v", localDir, err)
+	}
+	return files, err
+}
+
+// 列出 s3 前缀下的所有对象 (相对路径 -> 文件信息), 跳过 "目录" 占位对象
+func (basics BucketBasics) listRemoteFiles(ctx context.Context, bucketName string, prefix string, opts SyncOptions) (map[string]syncFile, error) {
+	files := map[string]syncFile{}
+	for entry, err := range basics.ObjectIter(ctx, bucketName, ListOptions{Prefix: prefix}) {
+		if err != nil {
+			return nil, err
+		}
+		key := aws.ToString(entry.Object.Key)
+		rel := strings.TrimPrefix(key, prefix)
+		if rel == "" || strings.HasSuffix(rel, "/") || !syncMatch(rel, opts) {
+			continue
+		}
+		files[rel] = syncFile{
+			Rel:     rel,
+			Size:    aws.ToInt64(entry.Object.Size),
+			ModTime: aws.ToTime(entry.Object.LastModified),
+			ETag:    strings.Trim(aws.ToString(entry.Object.ETag), `"`),
+		}
+	}
+	return files, nil
+}
+
+// 判断要不要同步
+/*
+参数:
+	src, dst syncFile : 源文件、目标文件
+	mode SyncCompareMode : 比较方式
+	localPath string : 本地文件路径, 比较 md5 时用
+	srcIsLocal bool : true 上传, false 下载
+*/
+func syncNeeded(src syncFile, dst syncFile, mode SyncCompareMode, localPath string, srcIsLocal bool) bool {
+	if src.Size != dst.Size {
+		return true
+	}
+	switch mode {
+	case SyncCompareSize:
+		return false
+	case SyncCompareChecksum:
+		etag := dst.ETag
+		if !srcIsLocal {
+			etag = src.ETag
+		}
+		if etag != "" && !strings.Contains(etag, "-") { // 单次上传的对象 ETag 就是 md5
+			sum, err := fileMD5(localPath)
+			if err != nil {
+				return true
+			}
+			return sum != etag
+		}
+		// 分段上传的对象 ETag 不是 md5, 退回比修改时间
+	}
+	// mtime: 源比目标新就同步 (精确到秒, s3 的 LastModified 只到秒)
+	return src.ModTime.Truncate(time.Second).After(dst.ModTime.Truncate(time.Second))
+}
+
+// 判断相对路径是否通过 Include/Exclude 过滤
+func syncMatch(rel string, opts SyncOptions) bool {
+	if len(opts.Include) > 0 && !matchAnyPattern(rel, opts.Include) {
+		return false
+	}
+	return !matchAnyPattern(rel, opts.Exclude)
+}
+
+// glob 匹配: 既匹配整个相对路径, 也匹配文件名; "xx/**" 匹配 xx 目录下的所有文件
+func matchAnyPattern(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok && strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 前缀统一成 "xx/" 形式, 空的还是空
+func normalizeSyncPrefix(prefix string) string {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// 计算本地文件 md5 (hex), 和 s3 的 ETag 比较用
+func fileMD5(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
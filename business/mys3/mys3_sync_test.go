@@ -0,0 +1,45 @@
+package mys3
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSyncS3ToLocalRejectsTraversal(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	for _, key := range []string{"sync/a.txt", "sync/../../escape.txt"} {
+		if _, err := basics.ObjectUpload(ctx, testBucket, key, writeTempFile(t, "f.txt", []byte(key))); err != nil {
+			t.Fatalf("上传 %s 失败: %v", key, err)
+		}
+	}
+
+	// key 带 ".." 的不能写到 localDir 外面
+	root := t.TempDir()
+	localDir := filepath.Join(root, "a", "b")
+	result, err := basics.SyncS3ToLocal(ctx, testBucket, "sync/", localDir, SyncOptions{})
+	if err == nil || result.Failed["../../escape.txt"] == "" || !slices.Equal(result.Copied, []string{"a.txt"}) {
+		t.Fatalf("带 .. 的 key 应该失败, 别的正常下载, result= %+v, err= %v", result, err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "escape.txt")); !os.IsNotExist(err) {
+		t.Fatal("不应该写到本地目录外面")
+	}
+}
+
+func TestSyncLocalToS3SkipsOnlyDownloadTempFiles(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	localDir := t.TempDir()
+	for _, name := range []string{"a.txt", "foo.download", ".a.txt.123456.download", "a.txt" + checkpointFileSuffix, "a.txt" + checkpointFileSuffix + ".tmp"} {
+		if err := os.WriteFile(filepath.Join(localDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 用户自己的 foo.download 要同步, 下载临时文件和断点文件不同步
+	result, err := basics.SyncLocalToS3(ctx, localDir, testBucket, "sync/", SyncOptions{})
+	slices.Sort(result.Copied)
+	if err != nil || !slices.Equal(result.Copied, []string{"a.txt", "foo.download"}) {
+		t.Fatalf("同步的文件不对, result= %+v, err= %v", result, err)
+	}
+}
@@ -0,0 +1,192 @@
+// 功能: 对象标签和元数据的读写, 标记图片属于哪个漫画、国家、分类
+package mys3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"study-aws-api-go/log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 变量
+const maxObjectTags = 10 // 一个对象最多10个标签
+
+// 对象元数据, HeadObject 的结果
+type ObjectMetadata struct {
+	Key          string             `json:"key"`          // 对象key
+	Size         int64              `json:"size"`         // 大小
+	ETag         string             `json:"etag"`         // ETag
+	ContentType  string             `json:"contentType"`  // Content-Type
+	StorageClass types.StorageClass `json:"storageClass"` // 存储类型, 标准存储 HEAD 不返回, 这里补成 STANDARD
+	LastModified time.Time          `json:"lastModified"` // 修改时间
+	VersionId    string             `json:"versionId"`    // 版本id, 没开版本控制时为空
+	Metadata     map[string]string  `json:"metadata"`     // 用户元数据 x-amz-meta-*, key 都是小写
+	Encryption   Encryption         `json:"-"`            // 服务端加密, SSE-C 只有 Mode 没有密钥
+}
+
+// 查 - 对象标签
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+返回值:
+	map[string]string: 标签, 没有标签时为空
+	error: 错误
+*/
+func (basics BucketBasics) ObjectTagsGet(ctx context.Context, bucketName string, awsFileName string) (map[string]string, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	})
+	if err != nil {
+		log.Errorf("查询 %s:%s 的标签失败, err= %v", bucketName, awsFileName, err)
+		return nil, err
+	}
+	tags := tagsToMap(output.TagSet)
+	log.Debugf("%s:%s 的标签: %v", bucketName, awsFileName, tags)
+	return tags, nil
+}
+
+// 改 - 设置对象标签, 会整个替换原来的标签
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	tags map[string]string : 标签, 如 {"comic": "亲家四姊妹", "country": "kr"}, 最多10个
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) ObjectTagsPut(ctx context.Context, bucketName string, awsFileName string, tags map[string]string) error {
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+	tagSet := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err := basics.clientFor(ctx, bucketName).PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucketName),
+		Key:     aws.String(awsFileName),
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		log.Errorf("设置 %s:%s 的标签失败, err= %v", bucketName, awsFileName, err)
+		return err
+	}
+	log.Infof("设置 %s:%s 的标签成功, 共 %d 个", bucketName, awsFileName, len(tags))
+	return nil
+}
+
+// 删 - 删除对象的所有标签
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) ObjectTagsDelete(ctx context.Context, bucketName string, awsFileName string) error {
+	_, err := basics.clientFor(ctx, bucketName).DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	})
+	if err != nil {
+		log.Errorf("删除 %s:%s 的标签失败, err= %v", bucketName, awsFileName, err)
+		return err
+	}
+	log.Infof("删除 %s:%s 的标签成功", bucketName, awsFileName)
+	return nil
+}
+
+// 查 - 对象元数据 (HeadObject, 不下载内容)
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	enc ...Encryption : 可选, SSE-C 对象要传密钥, 不然 HEAD 会报 400
+返回值:
+	*ObjectMetadata: 大小、ETag、Content-Type、存储类型、用户元数据
+	error: 错误, 对象不存在时是 *types.NotFound
+*/
+func (basics BucketBasics) ObjectMetadataGet(ctx context.Context, bucketName string, awsFileName string, enc ...Encryption) (*ObjectMetadata, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(awsFileName),
+	}
+	if len(enc) > 0 {
+		if err := enc[0].validate(); err != nil {
+			return nil, err
+		}
+		enc[0].applyHead(input)
+	}
+	head, err := basics.clientFor(ctx, bucketName).HeadObject(ctx, input)
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			log.Errorf("查询元数据失败, %s:%s 不存在", bucketName, awsFileName)
+			return nil, notFound
+		}
+		log.Errorf("查询 %s:%s 的元数据失败, err= %v", bucketName, awsFileName, err)
+		return nil, err
+	}
+
+	metadata := &ObjectMetadata{
+		Key:          awsFileName,
+		Size:         aws.ToInt64(head.ContentLength),
+		ETag:         aws.ToString(head.ETag),
+		ContentType:  aws.ToString(head.ContentType),
+		StorageClass: head.StorageClass,
+		LastModified: aws.ToTime(head.LastModified),
+		VersionId:    aws.ToString(head.VersionId),
+		Metadata:     head.Metadata,
+		Encryption:   encryptionFromHead(head),
+	}
+	if metadata.StorageClass == "" {
+		metadata.StorageClass = types.StorageClassStandard
+	}
+	if metadata.Metadata == nil {
+		metadata.Metadata = map[string]string{}
+	}
+	log.Debugf("%s:%s 的元数据: 大小= %d, etag= %s, content-type= %s, 存储类型= %s, 用户元数据= %v",
+		bucketName, awsFileName, metadata.Size, metadata.ETag, metadata.ContentType, metadata.StorageClass, metadata.Metadata)
+	return metadata, nil
+}
+
+// 检查标签: 最多10个, key 最长128, value 最长256
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("标签最多 %d 个, 现在是 %d 个", maxObjectTags, len(tags))
+	}
+	for k, v := range tags {
+		if k == "" || len([]rune(k)) > 128 {
+			return fmt.Errorf("标签 key [%s] 不能为空, 最长128个字符", k)
+		}
+		if len([]rune(v)) > 256 {
+			return fmt.Errorf("标签 [%s] 的值最长256个字符", k)
+		}
+	}
+	return nil
+}
+
+// 合并元数据, 后面的覆盖前面的, 都为空时返回 nil
+func mergeMetadata(metadatas ...map[string]string) map[string]string {
+	var merged map[string]string
+	for _, metadata := range metadatas {
+		for k, v := range metadata {
+			if merged == nil {
+				merged = map[string]string{}
+			}
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,123 @@
+package mys3
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"study-aws-api-go/business/mys3/mys3test"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/smithy-go"
+)
+
+const testBucket = "mys3-test"
+
+// 启动内存版 s3, 返回连着它的 BucketBasics, 测试结束自动关闭
+func newTestBasics(t *testing.T) (context.Context, BucketBasics, *mys3test.Server) {
+	t.Helper()
+	srv := mys3test.NewServer()
+	t.Cleanup(srv.Close)
+	ctx, client := InitS3Client("ap-northeast-1", "test", "test", "", WithEndpoint(srv.URL, true, true))
+	basics := BucketBasics{
+		S3Client:     client,
+		S3Manager:    manager.NewUploader(client),
+		S3Downloader: manager.NewDownloader(client),
+	}
+	return ctx, basics, srv
+}
+
+// 同 newTestBasics, 再建好存储桶 testBucket
+func newTestBucket(t *testing.T) (context.Context, BucketBasics, *mys3test.Server) {
+	t.Helper()
+	ctx, basics, srv := newTestBasics(t)
+	if err := basics.BucketAdd(ctx, testBucket, "ap-northeast-1"); err != nil {
+		t.Fatalf("创建存储桶失败: %v", err)
+	}
+	return ctx, basics, srv
+}
+
+// 写一个临时文件, 返回路径
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// 错误码, 不是 api 错误返回 ""
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func TestBucketAddAndDelete(t *testing.T) {
+	ctx, basics, _ := newTestBasics(t)
+
+	if err := basics.BucketAdd(ctx, testBucket, "ap-northeast-1", BucketAddOptions{}); err != nil {
+		t.Fatalf("创建存储桶失败: %v", err)
+	}
+	if exists, err := basics.BucketExists(ctx, testBucket); !exists || err != nil {
+		t.Fatalf("存储桶应该存在, exists= %v, err= %v", exists, err)
+	}
+	buckets, err := basics.BucketQueryAll(ctx)
+	if err != nil || len(buckets) != 1 || *buckets[0].Name != testBucket {
+		t.Fatalf("查询所有存储桶不对: %v, err= %v", buckets, err)
+	}
+	if err = basics.BucketAdd(ctx, testBucket, "ap-northeast-1"); errorCode(err) != "BucketAlreadyOwnedByYou" {
+		t.Fatalf("重复创建应该返回 BucketAlreadyOwnedByYou, err= %v", err)
+	}
+
+	if err = basics.BucketDelete(ctx, testBucket); err != nil {
+		t.Fatalf("删除存储桶失败: %v", err)
+	}
+	if exists, _ := basics.BucketExists(ctx, testBucket); exists {
+		t.Fatal("删除后存储桶不应该存在")
+	}
+	if err = basics.BucketDelete(ctx, testBucket); errorCode(err) != "NoSuchBucket" {
+		t.Fatalf("删除不存在的存储桶应该返回 NoSuchBucket, err= %v", err)
+	}
+}
+
+func TestBucketEmptyAndDelete(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/c.txt"} {
+		if _, err := basics.ObjectUpload(ctx, testBucket, key, writeTempFile(t, "f.txt", []byte(key))); err != nil {
+			t.Fatalf("上传 %s 失败: %v", key, err)
+		}
+	}
+
+	if err := basics.BucketDelete(ctx, testBucket); errorCode(err) != "BucketNotEmpty" {
+		t.Fatalf("删除非空存储桶应该返回 BucketNotEmpty, err= %v", err)
+	}
+	result, err := basics.ObjectDeletePrefix(ctx, testBucket, "dir/", PurgeOptions{DryRun: true})
+	if err != nil || len(result.Objects) != 2 || result.DeletedCount != 0 {
+		t.Fatalf("演练应该列出2个, 不删, result= %+v, err= %v", result, err)
+	}
+	result, err = basics.ObjectDeletePrefix(ctx, testBucket, "dir/", PurgeOptions{})
+	if err != nil || result.DeletedCount != 2 || len(result.Objects) != 0 {
+		t.Fatalf("按前缀删除应该删2个, 不记key, result= %+v, err= %v", result, err)
+	}
+	if result, err = basics.BucketEmptyAndDelete(ctx, testBucket, PurgeOptions{}); err != nil || result.ObjectCount != 1 || result.DeletedCount != 1 {
+		t.Fatalf("清空并删除存储桶失败, result= %+v, err= %v", result, err)
+	}
+	if exists, _ := basics.BucketExists(ctx, testBucket); exists {
+		t.Fatal("清空并删除后存储桶不应该存在")
+	}
+}
@@ -0,0 +1,209 @@
+// 功能: 存储桶版本控制, 对象历史版本的查询、恢复 (误覆盖了可以找回来)
+package mys3
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"study-aws-api-go/log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// 对象的一个版本, 可能是普通版本, 也可能是删除标记
+type ObjectVersion struct {
+	Key            string    // 对象key
+	VersionId      string    // 版本id, 没开版本控制时上传的是 "null"
+	IsLatest       bool      // 是否是当前版本
+	IsDeleteMarker bool      // 是否是删除标记
+	LastModified   time.Time // 修改时间
+	Size           int64     // 大小, 删除标记为0
+	ETag           string    // ETag, 删除标记为空
+}
+
+// 改 - 开启/暂停存储桶版本控制
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	enabled bool : true 开启, false 暂停 (开过版本控制的存储桶不能再关掉, 只能暂停)
+返回值:
+	error: 错误
+*/
+func (basics BucketBasics) BucketVersioningSet(ctx context.Context, bucketName string, enabled bool) error {
+	status := types.BucketVersioningStatusSuspended
+	if enabled {
+		status = types.BucketVersioningStatusEnabled
+	}
+	_, err := basics.clientFor(ctx, bucketName).PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucketName),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: status},
+	})
+	if err != nil {
+		log.Errorf("设置存储桶 %s 版本控制为 %s 失败, err= %v", bucketName, status, err)
+		return err
+	}
+	log.Infof("设置存储桶 %s 版本控制为 %s 成功", bucketName, status)
+	return nil
+}
+
+// 查 - 存储桶版本控制状态
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+返回值:
+	types.BucketVersioningStatus: Enabled / Suspended, 从没开过为 ""
+	error: 错误
+*/
+func (basics BucketBasics) BucketVersioningGet(ctx context.Context, bucketName string) (types.BucketVersioningStatus, error) {
+	output, err := basics.clientFor(ctx, bucketName).GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		log.Errorf("查询存储桶 %s 版本控制状态失败, err= %v", bucketName, err)
+		return "", err
+	}
+	log.Debugf("存储桶 %s 版本控制状态: [%s]", bucketName, output.Status)
+	return output.Status, nil
+}
+
+// 查 - 对象的所有版本和删除标记, 按 key 排序, 同一个 key 新的在前
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	keyOrPrefix string : 对象key 或 前缀
+	exactKey bool : true 只查 key 等于 keyOrPrefix 的; false 查前缀下所有的
+返回值:
+	[]ObjectVersion: 所有版本
+	error: 错误
+*/
+func (basics BucketBasics) ObjectVersionsList(ctx context.Context, bucketName string, keyOrPrefix string, exactKey bool) ([]ObjectVersion, error) {
+	var versions []ObjectVersion
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(bucketName)}
+	if keyOrPrefix != "" {
+		input.Prefix = aws.String(keyOrPrefix)
+	}
+	paginator := s3.NewListObjectVersionsPaginator(basics.clientFor(ctx, bucketName), input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Errorf("查询 %s:%s 的历史版本失败, err= %v", bucketName, keyOrPrefix, err)
+			return versions, err
+		}
+		for _, v := range output.Versions {
+			if exactKey && aws.ToString(v.Key) != keyOrPrefix {
+				continue
+			}
+			versions = append(versions, ObjectVersion{
+				Key:          aws.ToString(v.Key),
+				VersionId:    aws.ToString(v.VersionId),
+				IsLatest:     aws.ToBool(v.IsLatest),
+				LastModified: aws.ToTime(v.LastModified),
+				Size:         aws.ToInt64(v.Size),
+				ETag:         aws.ToString(v.ETag),
+			})
+		}
+		for _, m := range output.DeleteMarkers {
+			if exactKey && aws.ToString(m.Key) != keyOrPrefix {
+				continue
+			}
+			versions = append(versions, ObjectVersion{
+				Key:            aws.ToString(m.Key),
+				VersionId:      aws.ToString(m.VersionId),
+				IsLatest:       aws.ToBool(m.IsLatest),
+				IsDeleteMarker: true,
+				LastModified:   aws.ToTime(m.LastModified),
+			})
+		}
+	}
+
+	// 版本和删除标记是分开返回的, 合在一起重新排一下
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key < versions[j].Key
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	for _, v := range versions {
+		log.Debugf("版本 %s:%s, versionId= %s, 当前版本= %v, 删除标记= %v, 修改时间: %v",
+			bucketName, v.Key, v.VersionId, v.IsLatest, v.IsDeleteMarker, v.LastModified)
+	}
+	return versions, nil
+}
+
+// 改 - 把某个历史版本恢复成当前版本 (撤销误覆盖/误删除)
+/*
+参数:
+	ctx context.Contex : 上下文
+	bucketName string : 存储桶名称
+	awsFileName string : 对象key
+	versionId string : 要恢复的版本id, 不能是删除标记
+	enc ...Encryption : 可选, 这个版本是 SSE-C 加密的要传密钥, 恢复后还用同一个密钥
+返回值:
+	*CopyResult: 复制结果, VersionId 是新生成的当前版本id
+	error: 错误
+思路:
+	1. 检查版本是否存在, 是不是删除标记
+	2. HEAD 这个版本, 拿加密方式和存储类型
+	3. 把这个版本复制到同一个key上, 生成一个新的当前版本, 历史版本都还在, 可以反复撤销
+说明:
+	和 ObjectStorageClassSet 一样, 加密方式和存储类型保留: SSE-S3/SSE-KMS(含 KMS 密钥id) 从 HEAD 拿, SSE-C 用传进来的密钥
+*/
+func (basics BucketBasics) ObjectRestoreVersion(ctx context.Context, bucketName string, awsFileName string, versionId string, enc ...Encryption) (*CopyResult, error) {
+	// 1. 检查版本是否存在, 是不是删除标记
+	versions, err := basics.ObjectVersionsList(ctx, bucketName, awsFileName, true)
+	if err != nil {
+		return nil, err
+	}
+	var target *ObjectVersion
+	for i := range versions {
+		if versions[i].VersionId == versionId {
+			target = &versions[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("恢复版本失败, %s:%s 没有版本 %s", bucketName, awsFileName, versionId)
+	}
+	if target.IsDeleteMarker {
+		return nil, fmt.Errorf("恢复版本失败, %s:%s 的版本 %s 是删除标记, 请选一个普通版本", bucketName, awsFileName, versionId)
+	}
+	if target.IsLatest {
+		log.Infof("%s:%s 的版本 %s 已经是当前版本, 不用恢复", bucketName, awsFileName, versionId)
+		return &CopyResult{ETag: target.ETag, VersionId: target.VersionId}, nil
+	}
+
+	// 2. HEAD 这个版本
+	headInput := &s3.HeadObjectInput{
+		Bucket:    aws.String(bucketName),
+		Key:       aws.String(awsFileName),
+		VersionId: aws.String(versionId),
+	}
+	if len(enc) > 0 {
+		if err := enc[0].validate(); err != nil {
+			return nil, err
+		}
+		enc[0].applyHead(headInput)
+	}
+	head, err := basics.clientFor(ctx, bucketName).HeadObject(ctx, headInput)
+	if err != nil {
+		log.Errorf("查询 %s:%s 的版本 %s 失败, SSE-C 加密的版本要传密钥, err= %v", bucketName, awsFileName, versionId, err)
+		return nil, err
+	}
+
+	// 3. 把这个版本复制到同一个key上
+	opts := CopyOptions{SourceVersionId: versionId, StorageClass: head.StorageClass, Encryption: encryptionFromHead(head)}
+	if opts.Encryption.Mode == EncryptionSSEC { // 能 HEAD 成功说明传了密钥
+		opts.SourceEncryption, opts.Encryption = enc[0], enc[0]
+	}
+	result, err := basics.ObjectCopy(ctx, bucketName, awsFileName, bucketName, awsFileName, opts)
+	if err != nil {
+		log.Errorf("恢复 %s:%s 的版本 %s 失败, err= %v", bucketName, awsFileName, versionId, err)
+		return nil, err
+	}
+	log.Infof("恢复 %s:%s 的版本 %s 成功, 新版本id= %s", bucketName, awsFileName, versionId, result.VersionId)
+	return result, nil
+}
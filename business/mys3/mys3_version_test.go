@@ -0,0 +1,120 @@
+package mys3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestObjectRestoreVersion(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	if status, err := basics.BucketVersioningGet(ctx, testBucket); err != nil || status != "" {
+		t.Fatalf("没开过版本控制状态应该为空, status= %s, err= %v", status, err)
+	}
+	if err := basics.BucketVersioningSet(ctx, testBucket, true); err != nil {
+		t.Fatalf("开启版本控制失败: %v", err)
+	}
+	if status, err := basics.BucketVersioningGet(ctx, testBucket); err != nil || status != types.BucketVersioningStatusEnabled {
+		t.Fatalf("版本控制应该是 Enabled, status= %s, err= %v", status, err)
+	}
+
+	// 传两个版本再删掉, 有一个删除标记
+	var versionIds []string
+	for _, content := range []string{"one", "two"} {
+		if _, err := basics.ObjectUpload(ctx, testBucket, "a.txt", writeTempFile(t, "a.txt", []byte(content))); err != nil {
+			t.Fatalf("上传失败: %v", err)
+		}
+		versions, err := basics.ObjectVersionsList(ctx, testBucket, "a.txt", true)
+		if err != nil || len(versions) != len(versionIds)+1 {
+			t.Fatalf("版本数不对: %+v, err= %v", versions, err)
+		}
+		for _, v := range versions {
+			if v.IsLatest {
+				versionIds = append(versionIds, v.VersionId)
+			}
+		}
+	}
+	if _, err := basics.ObjectDelete(ctx, testBucket, "a.txt", "", false); err != nil {
+		t.Fatalf("删除失败: %v", err)
+	}
+	versions, err := basics.ObjectVersionsList(ctx, testBucket, "a.txt", true)
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("应该有2个版本和1个删除标记: %+v, err= %v", versions, err)
+	}
+	var markerId string
+	for _, v := range versions {
+		if v.IsDeleteMarker {
+			markerId = v.VersionId
+			if !v.IsLatest {
+				t.Fatalf("删除标记应该是当前版本: %+v", v)
+			}
+		}
+	}
+
+	// 删除标记和不存在的版本不能恢复
+	if _, err = basics.ObjectRestoreVersion(ctx, testBucket, "a.txt", markerId); err == nil {
+		t.Fatal("删除标记不能恢复")
+	}
+	if _, err = basics.ObjectRestoreVersion(ctx, testBucket, "a.txt", "no-such-version"); err == nil {
+		t.Fatal("不存在的版本不能恢复")
+	}
+
+	// 恢复第一个版本, 生成新的当前版本, 历史版本都还在
+	result, err := basics.ObjectRestoreVersion(ctx, testBucket, "a.txt", versionIds[0])
+	if err != nil || result.VersionId == "" || result.VersionId == versionIds[0] {
+		t.Fatalf("恢复版本失败, result= %+v, err= %v", result, err)
+	}
+	downloadFileName := filepath.Join(t.TempDir(), "a.txt")
+	if err = basics.ObjectDownload(ctx, testBucket, "a.txt", downloadFileName); err != nil {
+		t.Fatalf("下载失败: %v", err)
+	}
+	if got, _ := os.ReadFile(downloadFileName); string(got) != "one" {
+		t.Fatalf("恢复后的内容应该是第一个版本的: %q", got)
+	}
+	if versions, err = basics.ObjectVersionsList(ctx, testBucket, "", false); err != nil || len(versions) != 4 {
+		t.Fatalf("恢复后应该有4个版本: %+v, err= %v", versions, err)
+	}
+
+	// 清空存储桶要把历史版本和删除标记都删掉
+	purged, err := basics.BucketEmptyAndDelete(ctx, testBucket, PurgeOptions{})
+	if err != nil || purged.DeletedCount != 4 {
+		t.Fatalf("清空并删除存储桶失败, result= %+v, err= %v", purged, err)
+	}
+}
+
+func TestObjectRestoreVersionKeepsEncryption(t *testing.T) {
+	ctx, basics, _ := newTestBucket(t)
+	if err := basics.BucketVersioningSet(ctx, testBucket, true); err != nil {
+		t.Fatalf("开启版本控制失败: %v", err)
+	}
+
+	// 第一个版本 SSE-C 加密、低频存储, 第二个版本普通上传
+	enc := Encryption{Mode: EncryptionSSEC, CustomerKey: randomBytes(t, 32)}
+	if _, err := basics.ObjectUpload(ctx, testBucket, "a.txt", writeTempFile(t, "a.txt", []byte("one")),
+		UploadOptions{Encryption: enc, StorageClass: types.StorageClassStandardIa}); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+	first, err := basics.ObjectMetadataGet(ctx, testBucket, "a.txt", enc)
+	if err != nil {
+		t.Fatalf("查询元数据失败: %v", err)
+	}
+	if _, err = basics.ObjectUpload(ctx, testBucket, "a.txt", writeTempFile(t, "a.txt", []byte("two"))); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+
+	if _, err = basics.ObjectRestoreVersion(ctx, testBucket, "a.txt", first.VersionId); err == nil {
+		t.Fatal("SSE-C 加密的版本不传密钥不能恢复")
+	}
+	if _, err = basics.ObjectRestoreVersion(ctx, testBucket, "a.txt", first.VersionId, enc); err != nil {
+		t.Fatalf("恢复版本失败: %v", err)
+	}
+	metadata, err := basics.ObjectMetadataGet(ctx, testBucket, "a.txt", enc)
+	if err != nil {
+		t.Fatalf("恢复后应该还是用同一个密钥加密: %v", err)
+	}
+	if metadata.Encryption.Mode != EncryptionSSEC || metadata.StorageClass != types.StorageClassStandardIa {
+		t.Fatalf("恢复后加密方式和存储类型应该保留: %+v", metadata)
+	}
+}
@@ -0,0 +1,90 @@
+// 功能: 存储桶的配置子资源, 如生命周期、存储桶策略、阻止公共访问、跨域规则, 增删查都一样: PUT 存请求体, GET 原样返回, DELETE 删掉
+/*
+说明:
+	1. 只检查请求体的根元素对不对 (存储桶策略只检查是不是 json), 不校验规则内容, 规则怎么生效也不模拟
+	2. 没配置过时 GET 返回对应的 NoSuchXXX 错误, 和 s3 一样
+*/
+package mys3test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// 配置子资源
+type bucketConfig struct {
+	root      string // 请求体的根元素, 为空表示请求体是 json
+	noSuchErr string // 没配置过时的错误码
+}
+
+// 子资源名称 -> 配置子资源
+var bucketConfigs = map[string]bucketConfig{
+	"lifecycle":         {root: "LifecycleConfiguration", noSuchErr: "NoSuchLifecycleConfiguration"},
+	"policy":            {noSuchErr: "NoSuchBucketPolicy"},
+	"cors":              {root: "CORSConfiguration", noSuchErr: "NoSuchCORSConfiguration"},
+	"publicAccessBlock": {root: "PublicAccessBlockConfiguration", noSuchErr: "NoSuchPublicAccessBlockConfiguration"},
+}
+
+// 请求的是哪个配置子资源, 不是返回 false
+func bucketConfigName(query url.Values) (string, bool) {
+	for name := range bucketConfigs {
+		if query.Has(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// 增删查 - 存储桶的配置子资源
+func (s *Server) serveBucketConfig(w http.ResponseWriter, r *http.Request, bucketName string, name string, body []byte) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	config := bucketConfigs[name]
+	switch r.Method {
+	case http.MethodPut:
+		if config.root == "" {
+			if !json.Valid(body) {
+				return newError(http.StatusBadRequest, "MalformedPolicy", "请求体不是合法的 json")
+			}
+			b.configs[name] = body
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		var root struct {
+			XMLName xml.Name
+		}
+		if err := xml.Unmarshal(body, &root); err != nil {
+			return errMalformedXML(err)
+		}
+		if root.XMLName.Local != config.root {
+			return newError(http.StatusBadRequest, "MalformedXML", "根元素应该是 %s: %s", config.root, root.XMLName.Local)
+		}
+		b.configs[name] = body
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodGet:
+		data, ok := b.configs[name]
+		if !ok {
+			return newError(http.StatusNotFound, config.noSuchErr, "存储桶 %s 没有配置 %s", bucketName, name)
+		}
+		contentType := "application/xml"
+		if config.root == "" {
+			contentType = "application/json"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return nil
+	case http.MethodDelete:
+		delete(b.configs, name)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return notImplemented(r)
+}
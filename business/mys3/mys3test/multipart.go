@@ -0,0 +1,366 @@
+// 功能: 分段上传, 创建、上传分段、分段复制、合并、取消、列出分段和进行中的分段上传
+package mys3test
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 变量
+const maxPartNumber = 10000 // 分段号 1-10000
+
+// 进行中的分段上传
+type upload struct {
+	id        string
+	bucket    string
+	key       string
+	initiated time.Time
+	template  *object       // 创建时的请求头: Content-Type、元数据、标签、存储类型、加密、校验算法, 合并时用
+	parts     map[int]*part // 分段号 -> 分段
+}
+
+// 上传好的一段
+type part struct {
+	data         []byte
+	etag         string
+	checksum     string
+	lastModified time.Time
+}
+
+// 找进行中的分段上传, 存储桶和 key 要对得上
+func (s *Server) findUpload(b *bucket, key string, query url.Values) (*upload, *s3Error) {
+	u := s.uploads[query.Get("uploadId")]
+	if u == nil || u.bucket != b.name || u.key != key {
+		return nil, newError(http.StatusNotFound, "NoSuchUpload", "分段上传 %s 不存在, 可能已经合并或取消了", query.Get("uploadId"))
+	}
+	return u, nil
+}
+
+// 分段号, 1-10000
+func partNumberParam(query url.Values) (int, *s3Error) {
+	n, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || n < 1 || n > maxPartNumber {
+		return 0, newError(http.StatusBadRequest, "InvalidArgument", "分段号要是 1-%d, 现在是 %s", maxPartNumber, query.Get("partNumber"))
+	}
+	return n, nil
+}
+
+// 增 - 创建分段上传
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, b *bucket, key string) *s3Error {
+	template, e := newObject(r, nil)
+	if e != nil {
+		return e
+	}
+	template.checksumAlgorithm = strings.ToUpper(r.Header.Get("x-amz-checksum-algorithm"))
+	if template.checksumAlgorithm != "" && newChecksumHash(template.checksumAlgorithm) == nil {
+		return errChecksumAlgorithm(template.checksumAlgorithm)
+	}
+	u := &upload{
+		id:        newID(),
+		bucket:    b.name,
+		key:       key,
+		initiated: time.Now().UTC(),
+		template:  template,
+		parts:     map[int]*part{},
+	}
+	s.uploads[u.id] = u
+
+	if template.checksumAlgorithm != "" {
+		w.Header().Set("x-amz-checksum-algorithm", template.checksumAlgorithm)
+	}
+	template.writeEncryption(w.Header())
+	return writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: b.name, Key: key, UploadId: u.id})
+}
+
+// 增 - 上传一段, 创建时指定了校验算法, 每段都要算这个算法的校验值
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, b *bucket, key string, query url.Values, body []byte) *s3Error {
+	u, e := s.findUpload(b, key, query)
+	if e != nil {
+		return e
+	}
+	partNumber, e := partNumberParam(query)
+	if e != nil {
+		return e
+	}
+	if e = checkContentMD5(r, body); e != nil {
+		return e
+	}
+	algorithm, checksum, e := requestChecksum(r, body, u.template.checksumAlgorithm)
+	if e != nil {
+		return e
+	}
+	if u.template.checksumAlgorithm != "" && algorithm != u.template.checksumAlgorithm {
+		return newError(http.StatusBadRequest, "InvalidRequest", "分段的校验算法 %s 和创建时的 %s 不一致", algorithm, u.template.checksumAlgorithm)
+	}
+	p := newPart(body, checksum)
+	u.parts[partNumber] = p
+
+	w.Header().Set("ETag", p.etag)
+	if algorithm != "" {
+		w.Header().Set("x-amz-checksum-"+strings.ToLower(algorithm), checksum)
+	}
+	u.template.writeEncryption(w.Header())
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// 增 - 分段复制, 从已有对象复制一段字节范围
+func (s *Server) uploadPartCopy(w http.ResponseWriter, r *http.Request, b *bucket, key string, query url.Values) *s3Error {
+	u, e := s.findUpload(b, key, query)
+	if e != nil {
+		return e
+	}
+	partNumber, e := partNumberParam(query)
+	if e != nil {
+		return e
+	}
+	_, _, _, src, e := s.copySource(r)
+	if e != nil {
+		return e
+	}
+	data := src.data
+	if sourceRange := r.Header.Get("x-amz-copy-source-range"); sourceRange != "" {
+		start, end, ok := parseRange(sourceRange, int64(len(data)))
+		if !ok || !strings.HasSuffix(sourceRange, "-"+strconv.FormatInt(end, 10)) { // 复制范围不能超出源对象
+			return newError(http.StatusBadRequest, "InvalidArgument", "x-amz-copy-source-range 不对: %s, 源对象大小 %d", sourceRange, len(data))
+		}
+		data = data[start : end+1]
+	}
+	checksum := ""
+	if u.template.checksumAlgorithm != "" {
+		checksum = checksumOf(u.template.checksumAlgorithm, data)
+	}
+	p := newPart(data, checksum)
+	u.parts[partNumber] = p
+
+	return writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"CopyPartResult"`
+		ETag         string
+		LastModified string
+		checksumFields
+	}{ETag: p.etag, LastModified: p.lastModified.Format(timeFormatISO8601), checksumFields: newChecksumFields(u.template.checksumAlgorithm, checksum)})
+}
+
+func newPart(data []byte, checksum string) *part {
+	sum := md5.Sum(data)
+	return &part{
+		data:         bytes.Clone(data),
+		etag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		checksum:     checksum,
+		lastModified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// 合并分段
+/*
+思路:
+	1. 检查: 分段号从小到大, 每段都上传过且 ETag 对得上, 除了最后一段都不小于 MinPartSize
+	2. 拼数据, 算 "md5-分段数" 形式的 ETag; 创建时指定了校验算法的, 算组合校验值
+	3. 生成对象, 删掉分段上传
+*/
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, b *bucket, key string, query url.Values, body []byte) *s3Error {
+	u, e := s.findUpload(b, key, query)
+	if e != nil {
+		return e
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		return errMalformedXML(err)
+	}
+	if len(request.Parts) == 0 {
+		return newError(http.StatusBadRequest, "MalformedXML", "至少要有一段")
+	}
+
+	// 1. 检查 + 2. 拼数据
+	var data bytes.Buffer
+	obj := *u.template
+	etags := make([]string, 0, len(request.Parts))
+	for i, completed := range request.Parts {
+		if i > 0 && completed.PartNumber <= request.Parts[i-1].PartNumber {
+			return newError(http.StatusBadRequest, "InvalidPartOrder", "分段号要从小到大")
+		}
+		p := u.parts[completed.PartNumber]
+		if p == nil || strings.Trim(completed.ETag, `"`) != strings.Trim(p.etag, `"`) {
+			return newError(http.StatusBadRequest, "InvalidPart", "第 %d 段没有上传或 ETag 不对", completed.PartNumber)
+		}
+		if i < len(request.Parts)-1 && int64(len(p.data)) < s.MinPartSize {
+			return newError(http.StatusBadRequest, "EntityTooSmall", "第 %d 段只有 %d 字节, 除了最后一段最小 %d 字节", completed.PartNumber, len(p.data), s.MinPartSize)
+		}
+		data.Write(p.data)
+		etags = append(etags, p.etag)
+		obj.parts = append(obj.parts, int64(len(p.data)))
+		obj.partChecksums = append(obj.partChecksums, p.checksum)
+	}
+	obj.data = data.Bytes()
+	obj.etag = multipartETag(etags)
+	obj.lastModified = time.Now().UTC().Truncate(time.Second)
+	if obj.checksumAlgorithm != "" {
+		obj.checksum = compositeChecksum(obj.checksumAlgorithm, obj.partChecksums)
+	}
+
+	// 3. 生成对象
+	b.putVersion(key, &obj)
+	delete(s.uploads, u.id)
+
+	b.writeVersionId(w.Header(), &obj)
+	obj.writeEncryption(w.Header())
+	return writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+		checksumFields
+	}{Location: s.URL + "/" + b.name + "/" + key, Bucket: b.name, Key: key, ETag: obj.etag, checksumFields: newChecksumFields(obj.checksumAlgorithm, obj.checksum)})
+}
+
+// 删 - 取消分段上传, 已上传的分段一起删掉
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, b *bucket, key string, query url.Values) *s3Error {
+	u, e := s.findUpload(b, key, query)
+	if e != nil {
+		return e
+	}
+	delete(s.uploads, u.id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// 查 - 已上传的分段, 支持 part-number-marker 和 max-parts 分页
+func (s *Server) listParts(w http.ResponseWriter, b *bucket, key string, query url.Values) *s3Error {
+	u, e := s.findUpload(b, key, query)
+	if e != nil {
+		return e
+	}
+	maxParts, e := intParam(query, "max-parts", maxListKeys)
+	if e != nil {
+		return e
+	}
+	marker, _ := strconv.Atoi(query.Get("part-number-marker"))
+
+	type partEntry struct {
+		PartNumber   int
+		LastModified string
+		ETag         string
+		Size         int64
+		checksumFields
+	}
+	result := struct {
+		XMLName              xml.Name `xml:"ListPartsResult"`
+		Bucket               string
+		Key                  string
+		UploadId             string
+		PartNumberMarker     int
+		NextPartNumberMarker int
+		MaxParts             int
+		IsTruncated          bool
+		StorageClass         string
+		ChecksumAlgorithm    string      `xml:",omitempty"`
+		Parts                []partEntry `xml:"Part"`
+	}{Bucket: b.name, Key: key, UploadId: u.id, PartNumberMarker: marker, MaxParts: maxParts,
+		StorageClass: u.template.storageClass, ChecksumAlgorithm: u.template.checksumAlgorithm}
+
+	numbers := make([]int, 0, len(u.parts))
+	for n := range u.parts {
+		if n > marker {
+			numbers = append(numbers, n)
+		}
+	}
+	slices.Sort(numbers)
+	if len(numbers) > maxParts {
+		numbers, result.IsTruncated = numbers[:maxParts], true
+	}
+	for _, n := range numbers {
+		p := u.parts[n]
+		result.Parts = append(result.Parts, partEntry{
+			PartNumber:     n,
+			LastModified:   p.lastModified.Format(timeFormatISO8601),
+			ETag:           p.etag,
+			Size:           int64(len(p.data)),
+			checksumFields: newChecksumFields(u.template.checksumAlgorithm, p.checksum),
+		})
+		result.NextPartNumberMarker = n
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// 查 - 进行中的分段上传, 支持 prefix、key-marker/upload-id-marker、max-uploads
+func (s *Server) listMultipartUploads(w http.ResponseWriter, bucketName string, query url.Values) *s3Error {
+	if s.buckets[bucketName] == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	maxUploads, e := intParam(query, "max-uploads", maxListKeys)
+	if e != nil {
+		return e
+	}
+	prefix, keyMarker, idMarker := query.Get("prefix"), query.Get("key-marker"), query.Get("upload-id-marker")
+
+	var uploads []*upload
+	for _, u := range s.uploads {
+		if u.bucket != bucketName || !strings.HasPrefix(u.key, prefix) {
+			continue
+		}
+		if keyMarker != "" && (u.key < keyMarker || (u.key == keyMarker && (idMarker == "" || u.id <= idMarker))) {
+			continue
+		}
+		uploads = append(uploads, u)
+	}
+	slices.SortFunc(uploads, func(a, b *upload) int {
+		return cmp.Or(strings.Compare(a.key, b.key), strings.Compare(a.id, b.id))
+	})
+
+	type uploadEntry struct {
+		Key               string
+		UploadId          string
+		Initiated         string
+		StorageClass      string
+		ChecksumAlgorithm string `xml:",omitempty"`
+	}
+	result := struct {
+		XMLName            xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket             string
+		KeyMarker          string
+		UploadIdMarker     string
+		NextKeyMarker      string `xml:",omitempty"`
+		NextUploadIdMarker string `xml:",omitempty"`
+		Prefix             string
+		MaxUploads         int
+		IsTruncated        bool
+		Uploads            []uploadEntry `xml:"Upload"`
+	}{Bucket: bucketName, KeyMarker: keyMarker, UploadIdMarker: idMarker, Prefix: prefix, MaxUploads: maxUploads}
+	if len(uploads) > maxUploads {
+		uploads, result.IsTruncated = uploads[:maxUploads], true
+	}
+	for _, u := range uploads {
+		result.Uploads = append(result.Uploads, uploadEntry{
+			Key:               u.key,
+			UploadId:          u.id,
+			Initiated:         u.initiated.Format(timeFormatISO8601),
+			StorageClass:      u.template.storageClass,
+			ChecksumAlgorithm: u.template.checksumAlgorithm,
+		})
+		result.NextKeyMarker, result.NextUploadIdMarker = u.key, u.id
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker, result.NextUploadIdMarker = "", ""
+	}
+	return writeXML(w, http.StatusOK, result)
+}
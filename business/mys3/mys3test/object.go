@@ -0,0 +1,656 @@
+// 功能: 对象的上传、下载、HEAD、删除、复制、标签, 以及校验值和 SSE-C 的检查
+package mys3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 变量
+const maxObjectTags = 10 // 一个对象最多10个标签
+
+// 支持的校验算法, 和 sdk 的 types.ChecksumAlgorithm 一样
+var checksumAlgorithms = []string{"CRC32", "CRC32C", "CRC64NVME", "SHA1", "SHA256"}
+
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5) // CRC-64/NVME 的多项式(反转)
+
+// 对象
+type object struct {
+	data         []byte
+	etag         string // 带引号, 单次上传是 md5, 分段上传是 "md5-分段数"
+	lastModified time.Time
+	versionId    string // 版本id, 没开版本控制时是 "null"
+	deleteMarker bool   // 是不是删除标记, 删除标记没有数据
+
+	contentType        string
+	cacheControl       string
+	contentDisposition string
+	contentEncoding    string
+	contentLanguage    string
+	metadata           map[string]string // 用户元数据, key 是小写, 不带 x-amz-meta-
+	tags               map[string]string
+	storageClass       string
+
+	sse               string // AES256 / aws:kms / aws:kms:dsse, 没指定时为空, 响应里当 AES256
+	kmsKeyId          string
+	sseCustomerKeyMD5 string // SSE-C 密钥的 md5 (base64), 下载时要带一样的
+
+	checksumAlgorithm string   // 上传时指定的校验算法, 如 SHA256
+	checksum          string   // 校验值 (base64), 分段上传的是 "xxx-分段数" 形式的组合校验值
+	parts             []int64  // 分段上传的每段大小, 单次上传为空
+	partChecksums     []string // 分段上传的每段校验值
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, bucketName string, key string, query url.Values, body []byte) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	switch r.Method {
+	case http.MethodPut:
+		switch {
+		case query.Has("tagging"):
+			return s.putObjectTagging(w, b, key, body)
+		case query.Has("uploadId"):
+			if r.Header.Get("x-amz-copy-source") != "" {
+				return s.uploadPartCopy(w, r, b, key, query)
+			}
+			return s.uploadPart(w, r, b, key, query, body)
+		case unsupported(query):
+			return notImplemented(r)
+		case r.Header.Get("x-amz-copy-source") != "":
+			return s.copyObject(w, r, b, key)
+		default:
+			return s.putObject(w, r, b, key, body)
+		}
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case r.Method == http.MethodGet && query.Has("tagging"):
+			return s.getObjectTagging(w, b, key, query)
+		case r.Method == http.MethodGet && query.Has("uploadId"):
+			return s.listParts(w, b, key, query)
+		case unsupported(query, "partNumber", "versionId"):
+			return notImplemented(r)
+		default:
+			return s.getObject(w, r, b, key, query)
+		}
+	case http.MethodDelete:
+		switch {
+		case query.Has("tagging"):
+			return s.deleteObjectTagging(w, b, key)
+		case query.Has("uploadId"):
+			return s.abortMultipartUpload(w, b, key, query)
+		case unsupported(query, "versionId"):
+			return notImplemented(r)
+		default:
+			return s.deleteObject(w, b, key, query)
+		}
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			return s.createMultipartUpload(w, r, b, key)
+		case query.Has("uploadId"):
+			return s.completeMultipartUpload(w, b, key, query, body)
+		}
+	}
+	return notImplemented(r)
+}
+
+// 增 - PutObject
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, b *bucket, key string, body []byte) *s3Error {
+	obj, e := newObject(r, body)
+	if e != nil {
+		return e
+	}
+	if obj.checksumAlgorithm, obj.checksum, e = requestChecksum(r, body, r.Header.Get("x-amz-sdk-checksum-algorithm")); e != nil {
+		return e
+	}
+	b.putVersion(key, obj)
+	b.writeVersionId(w.Header(), obj)
+	obj.writeEncryption(w.Header())
+	obj.writeChecksum(w.Header(), obj.checksum)
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// 查 - GetObject / HeadObject, 支持 Range、partNumber、If-Match、If-None-Match
+/*
+思路:
+	1. 找对象, 检查版本、归档状态、SSE-C 密钥、条件
+	2. 算要返回的字节范围: partNumber 是那一段, Range 是那个范围, 都没有是整个对象
+	3. 写响应头, 要校验值 (x-amz-checksum-mode: ENABLED) 且返回的是整个对象或一整段时带上校验值
+	4. HEAD 不写响应体
+*/
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, b *bucket, key string, query url.Values) *s3Error {
+	// 1. 找对象
+	obj, e := b.version(key, query.Get("versionId"))
+	if e != nil {
+		if versions := b.versions[key]; query.Get("versionId") == "" && len(versions) > 0 && versions[0].deleteMarker {
+			w.Header().Set("x-amz-delete-marker", "true") // 当前版本是删除标记
+		}
+		return e
+	}
+	if r.Method == http.MethodGet && obj.archived() {
+		return errArchived(key)
+	}
+	if e := obj.checkCustomerKey(r.Header.Get("x-amz-server-side-encryption-customer-key-MD5")); e != nil {
+		return e
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != "*" && strings.Trim(match, `"`) != strings.Trim(obj.etag, `"`) {
+		return newError(http.StatusPreconditionFailed, "PreconditionFailed", "If-Match 不满足")
+	}
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && (noneMatch == "*" || strings.Trim(noneMatch, `"`) == strings.Trim(obj.etag, `"`)) {
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	// 2. 算字节范围
+	size := int64(len(obj.data))
+	start, end, status := int64(0), size-1, http.StatusOK
+	checksum := obj.checksum
+	header := w.Header()
+	if partNumber := query.Get("partNumber"); partNumber != "" {
+		parts := obj.parts
+		if len(parts) == 0 {
+			parts = []int64{size}
+		}
+		n, err := strconv.Atoi(partNumber)
+		if err != nil || n < 1 || n > len(parts) {
+			return newError(http.StatusRequestedRangeNotSatisfiable, "InvalidPartNumber", "分段号 %s 不对, 一共 %d 段", partNumber, len(parts))
+		}
+		for _, partSize := range parts[:n-1] {
+			start += partSize
+		}
+		end = start + parts[n-1] - 1
+		if len(obj.parts) > 0 {
+			header.Set("x-amz-mp-parts-count", strconv.Itoa(len(obj.parts)))
+			checksum = ""
+			if n <= len(obj.partChecksums) {
+				checksum = obj.partChecksums[n-1]
+			}
+			status = http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		}
+	} else if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && size > 0 { // 空对象 s3 会忽略 Range
+		var ok bool
+		if start, end, ok = parseRange(rangeHeader, size); !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return newError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "范围 %s 不对, 对象大小 %d", rangeHeader, size)
+		}
+		status = http.StatusPartialContent
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		checksum = ""
+	}
+
+	// 3. 写响应头
+	obj.writeHeaders(header)
+	b.writeVersionId(header, obj)
+	if r.Header.Get("x-amz-checksum-mode") == "ENABLED" {
+		obj.writeChecksum(header, checksum)
+	}
+	header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+
+	// 4. HEAD 不写响应体
+	if r.Method == http.MethodGet {
+		w.Write(obj.data[start : end+1])
+	}
+	return nil
+}
+
+// 复制 - CopyObject
+/*
+思路:
+	1. 找源对象, 检查源对象的 SSE-C 密钥和归档状态
+	2. 元数据: COPY 从源对象带过来, REPLACE 用请求头里的; 标签一样
+	3. 存储类型、加密不继承, 用请求头里的
+	4. 复制到自己身上什么都没改, s3 会报错, 这里也一样
+*/
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) *s3Error {
+	// 1. 找源对象
+	srcBucket, srcKey, srcVersionId, src, e := s.copySource(r)
+	if e != nil {
+		return e
+	}
+
+	// 2. 元数据和标签
+	obj, e := newObject(r, src.data)
+	if e != nil {
+		return e
+	}
+	replaceMetadata := r.Header.Get("x-amz-metadata-directive") == "REPLACE"
+	if !replaceMetadata {
+		obj.contentType, obj.cacheControl, obj.contentDisposition = src.contentType, src.cacheControl, src.contentDisposition
+		obj.contentEncoding, obj.contentLanguage = src.contentEncoding, src.contentLanguage
+		obj.metadata = maps.Clone(src.metadata)
+	}
+	if r.Header.Get("x-amz-tagging-directive") != "REPLACE" {
+		obj.tags = maps.Clone(src.tags)
+	}
+
+	// 4. 复制到自己身上, 复制历史版本 (恢复版本) 可以
+	if srcBucket == b.name && srcKey == key && srcVersionId == "" && !replaceMetadata &&
+		r.Header.Get("x-amz-storage-class") == "" && r.Header.Get("x-amz-server-side-encryption") == "" &&
+		r.Header.Get("x-amz-server-side-encryption-customer-algorithm") == "" {
+		return newError(http.StatusBadRequest, "InvalidRequest",
+			"This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.")
+	}
+
+	// 校验值: 指定了就用指定的算法, 没指定用源对象的算法, 都重新算整个对象的
+	obj.checksumAlgorithm = strings.ToUpper(r.Header.Get("x-amz-checksum-algorithm"))
+	if obj.checksumAlgorithm == "" {
+		obj.checksumAlgorithm = src.checksumAlgorithm
+	}
+	if obj.checksumAlgorithm != "" {
+		if newChecksumHash(obj.checksumAlgorithm) == nil {
+			return errChecksumAlgorithm(obj.checksumAlgorithm)
+		}
+		obj.checksum = checksumOf(obj.checksumAlgorithm, obj.data)
+	}
+	b.putVersion(key, obj)
+
+	result := struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+		checksumFields
+	}{ETag: obj.etag, LastModified: obj.lastModified.Format(timeFormatISO8601), checksumFields: newChecksumFields(obj.checksumAlgorithm, obj.checksum)}
+	b.writeVersionId(w.Header(), obj)
+	if srcVersionId != "" {
+		w.Header().Set("x-amz-copy-source-version-id", srcVersionId)
+	}
+	obj.writeEncryption(w.Header())
+	return writeXML(w, http.StatusOK, result)
+}
+
+// 找复制源对象: x-amz-copy-source 是 "存储桶/key" 或 "/存储桶/key", key 是 url 编码的, 可能带 ?versionId=
+/*
+返回值:
+	string: 源存储桶
+	string: 源对象key
+	string: 源版本id, 没指定为空
+	*object: 源对象
+	*s3Error: 错误
+*/
+func (s *Server) copySource(r *http.Request) (string, string, string, *object, *s3Error) {
+	source, versionId, _ := strings.Cut(r.Header.Get("x-amz-copy-source"), "?versionId=")
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return "", "", "", nil, newError(http.StatusBadRequest, "InvalidArgument", "x-amz-copy-source 不合法: %v", err)
+	}
+	srcBucket, srcKey, _ := strings.Cut(source, "/")
+	b := s.buckets[srcBucket]
+	if b == nil {
+		return "", "", "", nil, errNoSuchBucket(srcBucket)
+	}
+	src, e := b.version(srcKey, versionId)
+	if e != nil {
+		return "", "", "", nil, e
+	}
+	if src.archived() {
+		return "", "", "", nil, errArchived(srcKey)
+	}
+	if e := src.checkCustomerKey(r.Header.Get("x-amz-copy-source-server-side-encryption-customer-key-MD5")); e != nil {
+		return "", "", "", nil, e
+	}
+	return srcBucket, srcKey, versionId, src, nil
+}
+
+// 标签 - 查, 可以查历史版本的
+func (s *Server) getObjectTagging(w http.ResponseWriter, b *bucket, key string, query url.Values) *s3Error {
+	obj, e := b.version(key, query.Get("versionId"))
+	if e != nil {
+		return e
+	}
+	type tag struct{ Key, Value string }
+	result := struct {
+		XMLName xml.Name `xml:"Tagging"`
+		TagSet  []tag    `xml:"TagSet>Tag"`
+	}{}
+	for _, k := range sortedKeys(obj.tags) {
+		result.TagSet = append(result.TagSet, tag{Key: k, Value: obj.tags[k]})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// 标签 - 改, 整个替换
+func (s *Server) putObjectTagging(w http.ResponseWriter, b *bucket, key string, body []byte) *s3Error {
+	obj := b.objects[key]
+	if obj == nil {
+		return errNoSuchKey(key)
+	}
+	var tagging struct {
+		TagSet []struct{ Key, Value string } `xml:"TagSet>Tag"`
+	}
+	if err := xml.Unmarshal(body, &tagging); err != nil {
+		return errMalformedXML(err)
+	}
+	tags := map[string]string{}
+	for _, tag := range tagging.TagSet {
+		tags[tag.Key] = tag.Value
+	}
+	if len(tags) > maxObjectTags {
+		return errTooManyTags()
+	}
+	obj.tags = tags
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// 标签 - 删
+func (s *Server) deleteObjectTagging(w http.ResponseWriter, b *bucket, key string) *s3Error {
+	obj := b.objects[key]
+	if obj == nil {
+		return errNoSuchKey(key)
+	}
+	obj.tags = nil
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// 从请求头生成对象: Content-Type 等、用户元数据、标签、存储类型、加密; 数据会复制一份
+func newObject(r *http.Request, data []byte) (*object, *s3Error) {
+	if e := checkContentMD5(r, data); e != nil {
+		return nil, e
+	}
+	header := r.Header
+	sum := md5.Sum(data)
+	obj := &object{
+		data:               bytes.Clone(data),
+		etag:               `"` + hex.EncodeToString(sum[:]) + `"`,
+		lastModified:       time.Now().UTC().Truncate(time.Second),
+		contentType:        header.Get("Content-Type"),
+		cacheControl:       header.Get("Cache-Control"),
+		contentDisposition: header.Get("Content-Disposition"),
+		contentEncoding:    header.Get("Content-Encoding"),
+		contentLanguage:    header.Get("Content-Language"),
+		storageClass:       header.Get("x-amz-storage-class"),
+		sse:                header.Get("x-amz-server-side-encryption"),
+		kmsKeyId:           header.Get("x-amz-server-side-encryption-aws-kms-key-id"),
+	}
+	if obj.contentType == "" {
+		obj.contentType = "binary/octet-stream" // s3 的默认值
+	}
+	if obj.storageClass == "" {
+		obj.storageClass = "STANDARD"
+	}
+	for name, values := range header {
+		if metaKey, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			if obj.metadata == nil {
+				obj.metadata = map[string]string{}
+			}
+			obj.metadata[metaKey] = values[0]
+		}
+	}
+	if tagging := header.Get("x-amz-tagging"); tagging != "" {
+		values, err := url.ParseQuery(tagging)
+		if err != nil {
+			return nil, newError(http.StatusBadRequest, "InvalidArgument", "x-amz-tagging 不合法: %v", err)
+		}
+		if len(values) > maxObjectTags {
+			return nil, errTooManyTags()
+		}
+		obj.tags = map[string]string{}
+		for k := range values {
+			obj.tags[k] = values.Get(k)
+		}
+	}
+	if header.Get("x-amz-server-side-encryption-customer-algorithm") != "" {
+		key, err := base64.StdEncoding.DecodeString(header.Get("x-amz-server-side-encryption-customer-key"))
+		keySum := md5.Sum(key)
+		if err != nil || len(key) != 32 || base64.StdEncoding.EncodeToString(keySum[:]) != header.Get("x-amz-server-side-encryption-customer-key-MD5") {
+			return nil, newError(http.StatusBadRequest, "InvalidArgument", "SSE-C 密钥要是 base64 的32字节, key-MD5 要和密钥一致")
+		}
+		obj.sseCustomerKeyMD5 = header.Get("x-amz-server-side-encryption-customer-key-MD5")
+	}
+	return obj, nil
+}
+
+// 写对象的响应头, GetObject 和 HeadObject 用
+func (obj *object) writeHeaders(header http.Header) {
+	header.Set("Content-Type", obj.contentType)
+	header.Set("ETag", obj.etag)
+	header.Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+	for name, value := range map[string]string{
+		"Cache-Control":       obj.cacheControl,
+		"Content-Disposition": obj.contentDisposition,
+		"Content-Encoding":    obj.contentEncoding,
+		"Content-Language":    obj.contentLanguage,
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+	for k, v := range obj.metadata {
+		header.Set("x-amz-meta-"+k, v)
+	}
+	if obj.storageClass != "STANDARD" { // 标准存储 s3 不返回
+		header.Set("x-amz-storage-class", obj.storageClass)
+	}
+	if len(obj.tags) > 0 {
+		header.Set("x-amz-tagging-count", strconv.Itoa(len(obj.tags)))
+	}
+	obj.writeEncryption(header)
+}
+
+// 写加密相关响应头, 没指定加密的当成默认的 SSE-S3
+func (obj *object) writeEncryption(header http.Header) {
+	switch {
+	case obj.sseCustomerKeyMD5 != "":
+		header.Set("x-amz-server-side-encryption-customer-algorithm", "AES256")
+		header.Set("x-amz-server-side-encryption-customer-key-MD5", obj.sseCustomerKeyMD5)
+	case obj.sse != "":
+		header.Set("x-amz-server-side-encryption", obj.sse)
+		if obj.kmsKeyId != "" {
+			header.Set("x-amz-server-side-encryption-aws-kms-key-id", obj.kmsKeyId)
+		}
+	default:
+		header.Set("x-amz-server-side-encryption", "AES256")
+	}
+}
+
+// 写校验值响应头, 组合校验值 (带 -分段数) 的类型是 COMPOSITE
+func (obj *object) writeChecksum(header http.Header, checksum string) {
+	if obj.checksumAlgorithm == "" || checksum == "" {
+		return
+	}
+	header.Set("x-amz-checksum-"+strings.ToLower(obj.checksumAlgorithm), checksum)
+	if strings.Contains(checksum, "-") {
+		header.Set("x-amz-checksum-type", "COMPOSITE")
+	} else {
+		header.Set("x-amz-checksum-type", "FULL_OBJECT")
+	}
+}
+
+// 检查 SSE-C 密钥: SSE-C 的对象要带一样的密钥, 不是 SSE-C 的对象不能带
+func (obj *object) checkCustomerKey(keyMD5 string) *s3Error {
+	switch {
+	case obj.sseCustomerKeyMD5 == "" && keyMD5 != "":
+		return newError(http.StatusBadRequest, "InvalidRequest", "The encryption parameters are not applicable to this object.")
+	case obj.sseCustomerKeyMD5 != "" && keyMD5 == "":
+		return newError(http.StatusBadRequest, "InvalidRequest",
+			"The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+	case obj.sseCustomerKeyMD5 != keyMD5:
+		return newError(http.StatusForbidden, "AccessDenied", "The calculated MD5 hash of the key did not match the hash that was provided.")
+	}
+	return nil
+}
+
+// 是不是归档存储, 没恢复不能下载和复制 (这里没实现恢复)
+func (obj *object) archived() bool {
+	return obj.storageClass == "GLACIER" || obj.storageClass == "DEEP_ARCHIVE"
+}
+
+func errArchived(key string) *s3Error {
+	return newError(http.StatusForbidden, "InvalidObjectState", "对象 %s 是归档存储, 要先恢复", key)
+}
+
+func errTooManyTags() *s3Error {
+	return newError(http.StatusBadRequest, "InvalidTag", "Object tags cannot be greater than %d", maxObjectTags)
+}
+
+func errChecksumAlgorithm(algorithm string) *s3Error {
+	return newError(http.StatusBadRequest, "InvalidRequest", "不支持的校验算法 %s", algorithm)
+}
+
+// 检查 Content-MD5
+func checkContentMD5(r *http.Request, data []byte) *s3Error {
+	contentMD5 := r.Header.Get("Content-MD5")
+	if contentMD5 == "" {
+		return nil
+	}
+	sum := md5.Sum(data)
+	if base64.StdEncoding.EncodeToString(sum[:]) != contentMD5 {
+		return newError(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+	}
+	return nil
+}
+
+// 请求里的校验值: 带了 x-amz-checksum-xxx 头就检查是否一致; 只指定了算法就自己算
+/*
+参数:
+	r *http.Request : 请求
+	data []byte : 请求体
+	algorithm string : 没带校验值时用的算法, 如分段上传创建时指定的, 可以为空
+返回值:
+	string: 校验算法, 没有为空
+	string: 校验值 (base64)
+	*s3Error: 校验值不一致时是 BadDigest
+*/
+func requestChecksum(r *http.Request, data []byte, algorithm string) (string, string, *s3Error) {
+	for _, alg := range checksumAlgorithms {
+		value := r.Header.Get("x-amz-checksum-" + strings.ToLower(alg))
+		if value == "" {
+			continue
+		}
+		if actual := checksumOf(alg, data); actual != value {
+			return "", "", newError(http.StatusBadRequest, "BadDigest", "The %s you specified did not match the calculated checksum %s.", alg, actual)
+		}
+		return alg, value, nil
+	}
+	algorithm = strings.ToUpper(algorithm)
+	if algorithm == "" {
+		return "", "", nil
+	}
+	if newChecksumHash(algorithm) == nil {
+		return "", "", errChecksumAlgorithm(algorithm)
+	}
+	return algorithm, checksumOf(algorithm, data), nil
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "CRC32":
+		return crc32.NewIEEE()
+	case "CRC32C":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "CRC64NVME":
+		return crc64.New(crc64NVMETable)
+	case "SHA1":
+		return sha1.New()
+	case "SHA256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// 算校验值, 结果是 base64 (CRC 是大端字节序)
+func checksumOf(algorithm string, data []byte) string {
+	h := newChecksumHash(algorithm)
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// 解析 Range 头, 只支持一个范围: bytes=a-b, bytes=a-, bytes=-n
+func parseRange(rangeHeader string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(rangeHeader, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if first == "" { // 最后 n 个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+	}
+	return start, min(end, size-1), true
+}
+
+// 响应 xml 里的校验值, 只有一个有值
+type checksumFields struct {
+	ChecksumCRC32     string `xml:",omitempty"`
+	ChecksumCRC32C    string `xml:",omitempty"`
+	ChecksumCRC64NVME string `xml:",omitempty"`
+	ChecksumSHA1      string `xml:",omitempty"`
+	ChecksumSHA256    string `xml:",omitempty"`
+}
+
+func newChecksumFields(algorithm string, checksum string) checksumFields {
+	var fields checksumFields
+	switch algorithm {
+	case "CRC32":
+		fields.ChecksumCRC32 = checksum
+	case "CRC32C":
+		fields.ChecksumCRC32C = checksum
+	case "CRC64NVME":
+		fields.ChecksumCRC64NVME = checksum
+	case "SHA1":
+		fields.ChecksumSHA1 = checksum
+	case "SHA256":
+		fields.ChecksumSHA256 = checksum
+	}
+	return fields
+}
+
+// 组合校验值: 每段校验值(原始字节)拼起来再算一次, 后面加 "-分段数"
+func compositeChecksum(algorithm string, partChecksums []string) string {
+	h := newChecksumHash(algorithm)
+	for _, checksum := range partChecksums {
+		raw, _ := base64.StdEncoding.DecodeString(checksum)
+		h.Write(raw)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)) + "-" + strconv.Itoa(len(partChecksums))
+}
+
+// 分段上传的 ETag: 每段 md5(原始字节)拼起来再算 md5, 后面加 "-分段数"
+func multipartETag(partETags []string) string {
+	h := md5.New()
+	for _, etag := range partETags {
+		raw, _ := hex.DecodeString(strings.Trim(etag, `"`))
+		h.Write(raw)
+	}
+	return fmt.Sprintf(`"%x-%d"`, h.Sum(nil), len(partETags))
+}
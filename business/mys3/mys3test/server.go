@@ -0,0 +1,606 @@
+// 功能: 内存版的 s3 兼容服务, 给 mys3 的单元测试用, 不用连真的 aws, 离线就能跑
+/*
+说明:
+	1. 只实现 mys3.BucketBasics 用到的接口: 存储桶增删查、对象上传/下载/HEAD/删除、ListObjectsV2、DeleteObjects、
+	   分段上传、复制、对象标签、版本控制 (ListObjectVersions、按版本读/删/复制、删除标记)、
+	   存储桶配置 (生命周期、存储桶策略、阻止公共访问、跨域规则)
+	2. 不校验签名, 只支持路径风格 (http://host/存储桶/key), 客户端要开 UsePathStyle, 如 mys3.WithEndpoint(srv.URL, true, true)
+	3. 数据都在内存里, Close 后就没了
+	4. 没实现的接口返回 501 NotImplemented, 测试里一眼能看出来
+	5. 不能 import mys3, mys3 的测试要 import 这个包
+*/
+package mys3test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 变量
+const (
+	defaultMinPartSize = 5 * 1024 * 1024 // 分段上传除了最后一段, 每段最小5MB, 和 s3 一样
+	maxListKeys        = 1000            // ListObjectsV2、ListParts 等一次最多返回1000个
+	timeFormatISO8601  = "2006-01-02T15:04:05.000Z"
+)
+
+// 内存版 s3 服务
+type Server struct {
+	*httptest.Server
+	MinPartSize    int64 // 合并分段时, 除了最后一段每段最小多大, 默认5MB
+	NoRegionHeader bool  // HeadBucket 不返回 x-amz-bucket-region, 模拟不返回这个头的兼容 s3 服务
+
+	mu      sync.Mutex
+	buckets map[string]*bucket // 存储桶名称 -> 存储桶
+	uploads map[string]*upload // uploadId -> 进行中的分段上传
+}
+
+// 存储桶
+type bucket struct {
+	name       string
+	region     string
+	created    time.Time
+	objects    map[string]*object   // 对象key -> 当前版本
+	versions   map[string][]*object // 对象key -> 所有版本和删除标记, 从新到旧
+	versioning string               // 版本控制: "" 没开过 / Enabled / Suspended
+	configs    map[string][]byte    // 配置子资源名称 -> 配置的请求体, 如 lifecycle
+}
+
+// 启动服务, 用完要 Close
+/*
+返回值:
+	*Server: 服务, 地址是 Server.URL, 如 http://127.0.0.1:54321
+*/
+func NewServer() *Server {
+	s := &Server{
+		MinPartSize: defaultMinPartSize,
+		buckets:     map[string]*bucket{},
+		uploads:     map[string]*upload{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// 直接读存储的对象内容, 不走 http, 如检查客户端加密后存的是不是密文
+func (s *Server) ObjectData(bucketName string, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.lookup(bucketName, key)
+	if obj == nil {
+		return nil, false
+	}
+	return bytes.Clone(obj.data), true
+}
+
+// 直接改对象内容, ETag 和校验值不变, 模拟存储或传输损坏, 测下载校验用
+func (s *Server) CorruptObject(bucketName string, key string, data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.lookup(bucketName, key)
+	if obj == nil {
+		return false
+	}
+	obj.data = bytes.Clone(data)
+	return true
+}
+
+func (s *Server) lookup(bucketName string, key string) *object {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return nil
+	}
+	return b.objects[key]
+}
+
+// 处理请求: 按 路径 和 查询参数 分发
+/*
+思路:
+	1. 先把请求体读出来 (aws-chunked 的解码), 不在锁里做 io
+	2. 路径拆成 存储桶 和 对象key
+	3. 整个请求加锁处理, 测试用不追求并发性能
+*/
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-amz-request-id", newID()[:16])
+	// 1. 读请求体
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, r, newError(http.StatusBadRequest, "IncompleteBody", "读取请求体失败: %v", err))
+		return
+	}
+
+	// 2. 拆路径
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	// 3. 分发
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var e *s3Error
+	switch {
+	case bucketName == "":
+		e = s.serveService(w, r)
+	case key == "":
+		e = s.serveBucket(w, r, bucketName, query, body)
+	default:
+		e = s.serveObject(w, r, bucketName, key, query, body)
+	}
+	if e != nil {
+		writeError(w, r, e)
+	}
+}
+
+func (s *Server) serveService(w http.ResponseWriter, r *http.Request) *s3Error {
+	if r.Method != http.MethodGet {
+		return notImplemented(r)
+	}
+	return s.listBuckets(w)
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, bucketName string, query url.Values, body []byte) *s3Error {
+	if name, ok := bucketConfigName(query); ok {
+		return s.serveBucketConfig(w, r, bucketName, name, body)
+	}
+	switch r.Method {
+	case http.MethodPut:
+		switch {
+		case query.Has("versioning"):
+			return s.putBucketVersioning(w, bucketName, body)
+		case unsupported(query):
+			return notImplemented(r)
+		}
+		return s.createBucket(w, bucketName, body)
+	case http.MethodHead:
+		b := s.buckets[bucketName]
+		if b == nil {
+			return errNoSuchBucket(bucketName)
+		}
+		if !s.NoRegionHeader {
+			w.Header().Set("x-amz-bucket-region", b.regionName())
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodDelete:
+		if unsupported(query) {
+			return notImplemented(r)
+		}
+		return s.deleteBucket(w, bucketName)
+	case http.MethodGet:
+		switch {
+		case query.Get("list-type") == "2":
+			return s.listObjectsV2(w, bucketName, query)
+		case query.Has("uploads"):
+			return s.listMultipartUploads(w, bucketName, query)
+		case query.Has("versions"):
+			return s.listObjectVersions(w, bucketName, query)
+		case query.Has("location"):
+			return s.getBucketLocation(w, bucketName)
+		case query.Has("versioning"):
+			return s.getBucketVersioning(w, bucketName)
+		}
+	case http.MethodPost:
+		if query.Has("delete") {
+			return s.deleteObjects(w, bucketName, body)
+		}
+	}
+	return notImplemented(r)
+}
+
+// 查 - 所有存储桶
+func (s *Server) listBuckets(w http.ResponseWriter) *s3Error {
+	type bucketEntry struct {
+		Name         string
+		CreationDate string
+		BucketRegion string `xml:",omitempty"`
+	}
+	result := struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Owner   struct{ ID, DisplayName string }
+		Buckets []bucketEntry `xml:"Buckets>Bucket"`
+	}{}
+	result.Owner.ID, result.Owner.DisplayName = "mys3test", "mys3test"
+	for _, name := range sortedKeys(s.buckets) {
+		b := s.buckets[name]
+		result.Buckets = append(result.Buckets, bucketEntry{Name: name, CreationDate: b.created.Format(timeFormatISO8601), BucketRegion: b.region})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// 增 - 存储桶
+func (s *Server) createBucket(w http.ResponseWriter, bucketName string, body []byte) *s3Error {
+	if !validBucketName(bucketName) {
+		return newError(http.StatusBadRequest, "InvalidBucketName", "存储桶名称不合法: %s", bucketName)
+	}
+	if s.buckets[bucketName] != nil {
+		return newError(http.StatusConflict, "BucketAlreadyOwnedByYou", "存储桶 %s 已经存在", bucketName)
+	}
+	var config struct {
+		LocationConstraint string
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &config); err != nil {
+			return errMalformedXML(err)
+		}
+	}
+	s.buckets[bucketName] = &bucket{
+		name:     bucketName,
+		region:   config.LocationConstraint,
+		created:  time.Now().UTC(),
+		objects:  map[string]*object{},
+		versions: map[string][]*object{},
+		configs:  map[string][]byte{},
+	}
+	w.Header().Set("Location", "/"+bucketName)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// 查 - 存储桶所在区域, us-east-1 返回空
+func (s *Server) getBucketLocation(w http.ResponseWriter, bucketName string) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	return writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+		Region  string   `xml:",chardata"`
+	}{Region: b.region})
+}
+
+// 区域, 建桶时没填 LocationConstraint 是 us-east-1
+func (b *bucket) regionName() string {
+	if b.region == "" {
+		return "us-east-1"
+	}
+	return b.region
+}
+
+// 删 - 存储桶, 只能删空的 (历史版本和删除标记也要删掉), 进行中的分段上传一起删掉
+func (s *Server) deleteBucket(w http.ResponseWriter, bucketName string) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	if len(b.versions) > 0 {
+		return newError(http.StatusConflict, "BucketNotEmpty", "存储桶 %s 不是空的", bucketName)
+	}
+	for id, u := range s.uploads {
+		if u.bucket == bucketName {
+			delete(s.uploads, id)
+		}
+	}
+	delete(s.buckets, bucketName)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// 查 - ListObjectsV2
+/*
+思路:
+	1. key 排好序, 从 start-after / continuation-token 之后开始
+	2. 有 delimiter 时, 前缀后面第一个 delimiter 之前的合并成 CommonPrefixes, 和 Contents 一起算 max-keys
+	3. 没返回完时 NextContinuationToken 是最后一条 (key 或公共前缀) 的 base64
+*/
+func (s *Server) listObjectsV2(w http.ResponseWriter, bucketName string, query url.Values) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	maxKeys, e := intParam(query, "max-keys", maxListKeys)
+	if e != nil {
+		return e
+	}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+
+	// 1. 从哪里开始
+	marker := query.Get("start-after")
+	skipUnder := "" // 上一页最后是公共前缀时, 这个前缀下的 key 都跳过
+	if token := query.Get("continuation-token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return newError(http.StatusBadRequest, "InvalidArgument", "continuation-token 不合法")
+		}
+		marker = string(decoded)
+		if delimiter != "" && len(marker) > len(prefix) && strings.HasSuffix(marker, delimiter) {
+			skipUnder = marker
+		}
+	}
+
+	type listEntry struct {
+		Key               string
+		LastModified      string
+		ETag              string
+		Size              int64
+		StorageClass      string
+		ChecksumAlgorithm string `xml:",omitempty"`
+	}
+	type commonPrefix struct{ Prefix string }
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		Contents              []listEntry
+		CommonPrefixes        []commonPrefix
+	}{
+		Name:              bucketName,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxKeys,
+	}
+
+	// 2. 逐个 key 看
+	last, lastPrefix := "", ""
+	for _, key := range sortedKeys(b.objects) {
+		if !strings.HasPrefix(key, prefix) || key <= marker || (skipUnder != "" && strings.HasPrefix(key, skipUnder)) {
+			continue
+		}
+		entry, isPrefix := key, false
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry, isPrefix = key[:len(prefix)+i+len(delimiter)], true
+			}
+		}
+		if isPrefix && entry == lastPrefix {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+			lastPrefix = entry
+		} else {
+			obj := b.objects[key]
+			result.Contents = append(result.Contents, listEntry{
+				Key:               key,
+				LastModified:      obj.lastModified.Format(timeFormatISO8601),
+				ETag:              obj.etag,
+				Size:              int64(len(obj.data)),
+				StorageClass:      obj.storageClass,
+				ChecksumAlgorithm: obj.checksumAlgorithm,
+			})
+		}
+		result.KeyCount++
+		last = entry
+	}
+
+	// 3. 下一页的 token
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// 删 - 批量删除, 一次最多1000个, 不存在的 key 也算删除成功
+func (s *Server) deleteObjects(w http.ResponseWriter, bucketName string, body []byte) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	var request struct {
+		Quiet   bool
+		Objects []struct {
+			Key       string
+			VersionId string
+		} `xml:"Object"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		return errMalformedXML(err)
+	}
+	if len(request.Objects) == 0 || len(request.Objects) > maxListKeys {
+		return newError(http.StatusBadRequest, "MalformedXML", "一次要删 1-%d 个对象, 现在是 %d 个", maxListKeys, len(request.Objects))
+	}
+
+	type deleted struct {
+		Key                   string
+		VersionId             string `xml:",omitempty"`
+		DeleteMarker          bool   `xml:",omitempty"`
+		DeleteMarkerVersionId string `xml:",omitempty"`
+	}
+	type deleteError struct {
+		Key       string
+		VersionId string `xml:",omitempty"`
+		Code      string
+		Message   string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		Deleted []deleted
+		Errors  []deleteError `xml:"Error"`
+	}{}
+	for _, obj := range request.Objects {
+		removed, e := b.deleteVersion(obj.Key, obj.VersionId)
+		if e != nil {
+			result.Errors = append(result.Errors, deleteError{Key: obj.Key, VersionId: obj.VersionId, Code: e.code, Message: e.message})
+			continue
+		}
+		if request.Quiet {
+			continue
+		}
+		d := deleted{Key: obj.Key, VersionId: obj.VersionId}
+		if removed != nil && removed.deleteMarker {
+			d.DeleteMarker = true
+			if obj.VersionId == "" {
+				d.DeleteMarkerVersionId = removed.versionId // 新加的删除标记
+			}
+		}
+		result.Deleted = append(result.Deleted, d)
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// s3 错误, 会写成 <Error><Code>..</Code><Message>..</Message></Error>
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *s3Error) Error() string {
+	return e.code + ": " + e.message
+}
+
+func newError(status int, code string, format string, args ...any) *s3Error {
+	return &s3Error{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func errNoSuchBucket(bucketName string) *s3Error {
+	return newError(http.StatusNotFound, "NoSuchBucket", "存储桶 %s 不存在", bucketName)
+}
+
+func errNoSuchKey(key string) *s3Error {
+	return newError(http.StatusNotFound, "NoSuchKey", "对象 %s 不存在", key)
+}
+
+func errMalformedXML(err error) *s3Error {
+	return newError(http.StatusBadRequest, "MalformedXML", "请求体不是合法的 xml: %v", err)
+}
+
+func notImplemented(r *http.Request) *s3Error {
+	return newError(http.StatusNotImplemented, "NotImplemented", "mys3test 没有实现 %s %s", r.Method, r.URL.RequestURI())
+}
+
+// 写错误, HEAD 请求没有响应体, sdk 只看状态码 (404 -> *types.NotFound)
+func writeError(w http.ResponseWriter, r *http.Request, e *s3Error) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestId string
+	}{Code: e.code, Message: e.message, Resource: r.URL.Path, RequestId: w.Header().Get("x-amz-request-id")})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) *s3Error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "InternalError", "生成 xml 失败: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	w.Write(body)
+	return nil
+}
+
+// 读请求体, aws-chunked 编码的 (https 下 sdk 会用尾部校验值) 解码成原始数据, 尾部的校验值放回请求头
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	encodings := strings.Split(r.Header.Get("Content-Encoding"), ",")
+	i := slices.IndexFunc(encodings, func(encoding string) bool { return strings.TrimSpace(encoding) == "aws-chunked" })
+	if i < 0 {
+		return data, nil
+	}
+	r.Header.Set("Content-Encoding", strings.Join(slices.Delete(encodings, i, i+1), ","))
+	if r.Header.Get("Content-Encoding") == "" {
+		r.Header.Del("Content-Encoding")
+	}
+
+	var decoded bytes.Buffer
+	for {
+		line, rest, ok := bytes.Cut(data, []byte("\r\n"))
+		if !ok {
+			return nil, errors.New("aws-chunked 格式不对, 缺少分块头")
+		}
+		sizeHex, _, _ := strings.Cut(string(line), ";") // 签名的分块头是 "大小;chunk-signature=xxx"
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 || size > int64(len(rest)) {
+			return nil, fmt.Errorf("aws-chunked 分块大小不对: %q", sizeHex)
+		}
+		if size == 0 { // 最后一块, 后面是尾部头 "name:value\r\n", 以空行结束
+			for _, trailer := range strings.Split(string(rest), "\r\n") {
+				if name, value, ok := strings.Cut(trailer, ":"); ok {
+					r.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+				}
+			}
+			return decoded.Bytes(), nil
+		}
+		decoded.Write(rest[:size])
+		data = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+}
+
+// 查询参数里有没有不认识的子资源, 如 ?acl ?versioning, 有就返回 NotImplemented
+// sdk 会带 x-id, 预签名url 会带 X-Amz-*, 这些不算
+func unsupported(query url.Values, allowed ...string) bool {
+	for name := range query {
+		if name == "x-id" || strings.HasPrefix(name, "X-Amz-") || strings.HasPrefix(name, "response-") || slices.Contains(allowed, name) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// 读整数查询参数, 没填用默认值, 最大 maxListKeys
+func intParam(query url.Values, name string, defaultValue int) (int, *s3Error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, newError(http.StatusBadRequest, "InvalidArgument", "%s 不合法: %s", name, value)
+	}
+	return min(n, maxListKeys), nil
+}
+
+// 存储桶名称: 3-63个字符, 小写字母、数字、点、横线, 开头结尾是字母或数字
+func validBucketName(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		alnum := ('a' <= c && c <= 'z') || ('0' <= c && c <= '9')
+		if !alnum && ((c != '.' && c != '-') || i == 0 || i == len(name)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,262 @@
+// 功能: 版本控制, 对象的历史版本、删除标记、ListObjectVersions
+/*
+说明:
+	1. 每个 key 的所有版本按从新到旧存在 bucket.versions 里, 第一个是当前版本; bucket.objects 里是当前版本 (当前版本是删除标记时没有)
+	2. 没开过版本控制: 只有一个 "null" 版本, 删除就真删了
+	3. 开启: 每次写都是新版本 (随机id), 不带版本id删除会加一个删除标记
+	4. 暂停: 写的是 "null" 版本, 会覆盖原来的 "null" 版本; 删除加一个 "null" 的删除标记
+*/
+package mys3test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 变量
+const (
+	versioningEnabled   = "Enabled"
+	versioningSuspended = "Suspended"
+	nullVersionId       = "null" // 没开版本控制时写的版本id
+)
+
+// 写一个新版本, 成为当前版本
+func (b *bucket) putVersion(key string, obj *object) {
+	obj.versionId = nullVersionId
+	if b.versioning == versioningEnabled {
+		obj.versionId = newID()
+	}
+	b.versions[key] = append([]*object{obj}, b.withoutNullVersion(key, obj.versionId)...)
+	b.objects[key] = obj
+}
+
+// 删除对象
+/*
+参数:
+	key string : 对象key
+	versionId string : 版本id, 为空是删当前版本 (开过版本控制的加删除标记)
+返回值:
+	*object: 删掉的版本 或 新加的删除标记, 没开过版本控制时为 nil
+	*s3Error: 版本不存在 ("null" 版本不存在算删除成功)
+*/
+func (b *bucket) deleteVersion(key string, versionId string) (*object, *s3Error) {
+	if versionId == "" {
+		if b.versioning == "" {
+			delete(b.objects, key)
+			delete(b.versions, key)
+			return nil, nil
+		}
+		marker := &object{deleteMarker: true, lastModified: time.Now().UTC().Truncate(time.Second)}
+		b.putVersion(key, marker)
+		delete(b.objects, key)
+		return marker, nil
+	}
+
+	versions := b.versions[key]
+	i := slices.IndexFunc(versions, func(v *object) bool { return v.versionId == versionId })
+	if i < 0 {
+		if versionId == nullVersionId { // 和不存在的 key 一样, 算删除成功
+			return nil, nil
+		}
+		return nil, errNoSuchVersion(versionId)
+	}
+	removed := versions[i]
+	versions = slices.Delete(versions, i, i+1)
+	if len(versions) == 0 {
+		delete(b.versions, key)
+	} else {
+		b.versions[key] = versions
+	}
+	// 删的是当前版本, 下一个版本成为当前版本
+	if len(versions) > 0 && !versions[0].deleteMarker {
+		b.objects[key] = versions[0]
+	} else {
+		delete(b.objects, key)
+	}
+	return removed, nil
+}
+
+// 找对象的某个版本, 版本id为空是当前版本; 删除标记不能读
+func (b *bucket) version(key string, versionId string) (*object, *s3Error) {
+	if versionId == "" {
+		if obj := b.objects[key]; obj != nil {
+			return obj, nil
+		}
+		return nil, errNoSuchKey(key)
+	}
+	for _, v := range b.versions[key] {
+		if v.versionId != versionId {
+			continue
+		}
+		if v.deleteMarker {
+			return nil, newError(http.StatusMethodNotAllowed, "MethodNotAllowed", "版本 %s 是删除标记", versionId)
+		}
+		return v, nil
+	}
+	return nil, errNoSuchVersion(versionId)
+}
+
+// 去掉 "null" 版本, 暂停版本控制时写 "null" 版本会覆盖原来的
+func (b *bucket) withoutNullVersion(key string, versionId string) []*object {
+	versions := b.versions[key]
+	if versionId != nullVersionId {
+		return versions
+	}
+	return slices.DeleteFunc(slices.Clone(versions), func(v *object) bool { return v.versionId == nullVersionId })
+}
+
+// 写版本id响应头, 没开过版本控制的存储桶 s3 不返回
+func (b *bucket) writeVersionId(header http.Header, obj *object) {
+	if b.versioning == "" || obj == nil {
+		return
+	}
+	header.Set("x-amz-version-id", obj.versionId)
+	if obj.deleteMarker {
+		header.Set("x-amz-delete-marker", "true")
+	}
+}
+
+// 删 - DeleteObject, 不存在的 key 也算成功
+func (s *Server) deleteObject(w http.ResponseWriter, b *bucket, key string, query url.Values) *s3Error {
+	deleted, e := b.deleteVersion(key, query.Get("versionId"))
+	if e != nil {
+		return e
+	}
+	b.writeVersionId(w.Header(), deleted)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// 查 - 版本控制状态, 从没开过时 Status 为空
+func (s *Server) getBucketVersioning(w http.ResponseWriter, bucketName string) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	return writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"VersioningConfiguration"`
+		Status  string   `xml:",omitempty"`
+	}{Status: b.versioning})
+}
+
+// 改 - 开启/暂停版本控制, 开过就关不掉了, 只能暂停
+func (s *Server) putBucketVersioning(w http.ResponseWriter, bucketName string, body []byte) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	var config struct {
+		Status string
+	}
+	if err := xml.Unmarshal(body, &config); err != nil {
+		return errMalformedXML(err)
+	}
+	if config.Status != versioningEnabled && config.Status != versioningSuspended {
+		return newError(http.StatusBadRequest, "IllegalVersioningConfigurationException", "Status 只能是 Enabled 或 Suspended: %s", config.Status)
+	}
+	b.versioning = config.Status
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// 查 - ListObjectVersions
+/*
+思路:
+	1. 所有版本按 key 排序, 同一个 key 从新到旧
+	2. 从 key-marker / version-id-marker 之后开始, 没有 version-id-marker 时跳过 key-marker 的所有版本
+	3. 普通版本放 Version, 删除标记放 DeleteMarker
+*/
+func (s *Server) listObjectVersions(w http.ResponseWriter, bucketName string, query url.Values) *s3Error {
+	b := s.buckets[bucketName]
+	if b == nil {
+		return errNoSuchBucket(bucketName)
+	}
+	if query.Get("delimiter") != "" {
+		return newError(http.StatusNotImplemented, "NotImplemented", "mys3test 的 ListObjectVersions 不支持 delimiter")
+	}
+	maxKeys, e := intParam(query, "max-keys", maxListKeys)
+	if e != nil {
+		return e
+	}
+	prefix, keyMarker, versionIdMarker := query.Get("prefix"), query.Get("key-marker"), query.Get("version-id-marker")
+
+	type versionEntry struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type deleteMarkerEntry struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+	}
+	result := struct {
+		XMLName             xml.Name `xml:"ListVersionsResult"`
+		Name                string
+		Prefix              string
+		KeyMarker           string
+		VersionIdMarker     string
+		NextKeyMarker       string `xml:",omitempty"`
+		NextVersionIdMarker string `xml:",omitempty"`
+		MaxKeys             int
+		IsTruncated         bool
+		Versions            []versionEntry      `xml:"Version"`
+		DeleteMarkers       []deleteMarkerEntry `xml:"DeleteMarker"`
+	}{Name: bucketName, Prefix: prefix, KeyMarker: keyMarker, VersionIdMarker: versionIdMarker, MaxKeys: maxKeys}
+
+	count, lastKey, lastVersionId := 0, "", ""
+	for _, key := range sortedKeys(b.versions) {
+		if !strings.HasPrefix(key, prefix) || key < keyMarker || (key == keyMarker && versionIdMarker == "") {
+			continue
+		}
+		versions := b.versions[key]
+		if key == keyMarker { // 从 version-id-marker 的下一个版本开始
+			i := slices.IndexFunc(versions, func(v *object) bool { return v.versionId == versionIdMarker })
+			versions = versions[i+1:]
+		}
+		for _, v := range versions {
+			if count == maxKeys {
+				result.IsTruncated = true
+				break
+			}
+			isLatest := v == b.versions[key][0]
+			if v.deleteMarker {
+				result.DeleteMarkers = append(result.DeleteMarkers, deleteMarkerEntry{
+					Key: key, VersionId: v.versionId, IsLatest: isLatest, LastModified: v.lastModified.Format(timeFormatISO8601),
+				})
+			} else {
+				result.Versions = append(result.Versions, versionEntry{
+					Key:          key,
+					VersionId:    v.versionId,
+					IsLatest:     isLatest,
+					LastModified: v.lastModified.Format(timeFormatISO8601),
+					ETag:         v.etag,
+					Size:         int64(len(v.data)),
+					StorageClass: v.storageClass,
+				})
+			}
+			count++
+			lastKey, lastVersionId = key, v.versionId
+		}
+		if result.IsTruncated {
+			break
+		}
+	}
+	if result.IsTruncated {
+		result.NextKeyMarker, result.NextVersionIdMarker = lastKey, lastVersionId
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func errNoSuchVersion(versionId string) *s3Error {
+	return newError(http.StatusNotFound, "NoSuchVersion", "版本 %s 不存在", versionId)
+}
@@ -0,0 +1,179 @@
+// 功能: 封装restfult api - s3 对象模块
+package object
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"study-aws-api-go/business/storage"
+	"study-aws-api-go/log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 变量
+var (
+	store            storage.Storage // main.go 按配置创建好的存储后端 (s3/本地目录/内存)
+	presignBuckets   []string        // 允许预签名的存储桶, 为空全部拒绝
+	presignKeyPrefix string          // 允许预签名的 key 前缀, 为空不限
+)
+
+// 初始化, main.go 创建好存储后端后调用
+/*
+参数:
+	st storage.Storage : 存储后端
+	buckets []string : 允许预签名的存储桶, 配置文件 storage.presign_buckets
+	keyPrefix string : 允许预签名的 key 前缀, 配置文件 storage.presign_key_prefix
+*/
+func InitObject(st storage.Storage, buckets []string, keyPrefix string) {
+	store = st
+	presignBuckets = buckets
+	presignKeyPrefix = keyPrefix
+}
+
+// 存储桶和 key 在不在允许预签名的范围里, 不在的话任何人都能拿到别的存储桶的上传url
+func presignAllowed(bucketName string, key string) bool {
+	return slices.Contains(presignBuckets, bucketName) && strings.HasPrefix(key, presignKeyPrefix)
+}
+
+// 预签名请求参数
+type presignRequest struct {
+	Bucket           string `json:"bucket" binding:"required"` // 存储桶
+	Key              string `json:"key" binding:"required"`    // 对象key
+	Method           string `json:"method"`                    // GET(默认)/PUT/POST
+	Expires          int64  `json:"expires"`                   // 有效期(秒), 不填默认15分钟
+	ContentType      string `json:"contentType"`               // Content-Type
+	ContentLength    int64  `json:"contentLength"`             // PUT: 文件大小
+	MinContentLength int64  `json:"minContentLength"`          // POST: 大小下限
+	MaxContentLength int64  `json:"maxContentLength"`          // POST: 大小上限
+}
+
+// 预签名, 给前端临时访问对象
+/*
+请求: json对象
+{
+	"bucket": "sexcomic",
+	"key": "充满各种变态行为的家-1.jpg",
+	"method": "GET",
+	"expires": 900
+}
+返回: json对象, 见 mys3.PresignResult; 本地/内存后端不支持 POST; 存储桶不在 storage.presign_buckets 里或 key 不在 storage.presign_key_prefix 下返回 403
+{
+	"url": "https://...",
+	"method": "GET",
+	"header": {},
+	"values": {},
+	"expires": "2025-05-01T00:00:00Z"
+}
+*/
+func ObjectPresign(c *gin.Context) {
+	log.Debug("生成预签名url")
+	var req presignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Error("解析请求体失败, err: ", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return // 必须保留 return，确保绑定失败时提前退出
+	}
+	if !presignAllowed(req.Bucket, req.Key) {
+		log.Warnf("拒绝预签名 %s:%s, 不在允许的存储桶/前缀里", req.Bucket, req.Key)
+		c.JSON(403, gin.H{"error": "不允许对这个存储桶/key 预签名"})
+		return
+	}
+
+	opts := storage.PresignOptions{
+		Expires:          time.Duration(req.Expires) * time.Second,
+		ContentType:      req.ContentType,
+		ContentLength:    req.ContentLength,
+		MinContentLength: req.MinContentLength,
+		MaxContentLength: req.MaxContentLength,
+	}
+	result, err := store.Presign(c.Request.Context(), req.Method, req.Bucket, req.Key, opts)
+	if errors.Is(err, storage.ErrNotSupported) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error("生成预签名url失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, result)
+}
+
+// 查-分页, 按前缀/分隔符查对象
+/*
+参数: query
+	bucket: 存储桶, 必填
+	prefix: 前缀, 如 "亲家四姊妹/"
+	delimiter: 分隔符, 一般是 "/", 填了会返回下一级目录
+	size: 每页个数, 默认100, 最大1000
+	token: 上一页返回的 nextToken, 第一页不填
+返回: json对象
+{
+	"objects": [{"key": "", "size": 0, "etag": "", "lastModified": "", "storageClass": ""}],
+	"prefixes": [],
+	"nextToken": "",
+	"isTruncated": false
+}
+*/
+func ObjectsPageQuery(c *gin.Context) {
+	log.Debug("分页查询对象")
+
+	// 参数缺失校验
+	bucket := c.Query("bucket")
+	if bucket == "" {
+		c.JSON(400, gin.H{"error": "参数缺失"})
+		return
+	}
+
+	// 参数类型校验
+	size := 100
+	if sizeStr := c.Query("size"); sizeStr != "" {
+		var err error
+		if size, err = strconv.Atoi(sizeStr); err != nil || size <= 0 {
+			c.JSON(400, gin.H{"error": "size参数类型错误"})
+			return
+		}
+	}
+
+	// 业务逻辑
+	page, err := store.List(c.Request.Context(), bucket, storage.ListOptions{
+		Prefix:            c.Query("prefix"),
+		Delimiter:         c.Query("delimiter"),
+		MaxKeys:           int32(min(size, 1000)),
+		ContinuationToken: c.Query("token"),
+	})
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error("分页查询对象失败, err: ", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 构造指定的返回结构
+	objects := make([]gin.H, 0, len(page.Objects))
+	for _, obj := range page.Objects {
+		objects = append(objects, gin.H{
+			"key":          obj.Key,
+			"size":         obj.Size,
+			"etag":         obj.ETag,
+			"lastModified": obj.LastModified,
+			"storageClass": obj.StorageClass,
+		})
+	}
+	prefixes := page.CommonPrefixes
+	if prefixes == nil {
+		prefixes = []string{}
+	}
+	c.JSON(200, gin.H{
+		"objects":     objects,
+		"prefixes":    prefixes,
+		"nextToken":   page.NextContinuationToken,
+		"isTruncated": page.IsTruncated,
+	})
+}
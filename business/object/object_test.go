@@ -0,0 +1,39 @@
+package object
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"study-aws-api-go/business/storage"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestObjectPresignAllowlist(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer, err := storage.NewSigner("http://localhost:8888/storage", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitObject(storage.NewMemory(signer, "public", "private"), []string{"public"}, "uploads/")
+	r := gin.New()
+	r.POST("/objects/presign", ObjectPresign)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"bucket": "public", "key": "uploads/1.jpg", "method": "PUT"}`, 200},
+		{`{"bucket": "private", "key": "uploads/1.jpg", "method": "PUT"}`, 403}, // 不在允许的存储桶里
+		{`{"bucket": "public", "key": "config/1.json", "method": "PUT"}`, 403},  // 不在允许的前缀下
+		{`{"bucket": "public", "key": "config/1.json"}`, 403},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/objects/presign", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: status= %d, 应该是 %d, body= %s", tt.body, w.Code, tt.status, w.Body)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+// 功能: 本地目录存储后端, 存储桶是目录, 对象是文件, 元数据放在旁边的 .meta.json 文件里, 离线开发用
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"study-aws-api-go/business/mys3"
+	"study-aws-api-go/log"
+	"sync"
+	"time"
+)
+
+// 变量
+const (
+	metaFileSuffix = ".meta.json" // 元数据文件后缀, 如 1.jpg -> 1.jpg.meta.json
+	tmpDirName     = ".tmp"       // 上传时先写到 根目录/.tmp, 写完再改名, 不会读到写了一半的文件
+)
+
+// 本地目录存储后端
+/*
+目录结构:
+	根目录/存储桶/亲家四姊妹/1.jpg            对象内容
+	根目录/存储桶/亲家四姊妹/1.jpg.meta.json  Content-Type、ETag、用户元数据
+	根目录/.tmp/                            上传中的临时文件
+*/
+type LocalStorage struct {
+	root    string       // 根目录
+	mu      sync.RWMutex // 对象和元数据文件要一起改
+	presign *Signer      // 预签名, 为 nil 不支持
+}
+
+// 元数据文件的内容, 大小和修改时间直接看对象文件
+type localMeta struct {
+	ContentType string            `json:"contentType"`
+	ETag        string            `json:"etag"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// 创建本地目录存储后端
+/*
+参数:
+	root string : 根目录, 不存在会自动创建
+	signer *Signer : 预签名签名器, 可以为 nil
+	buckets ...string : 要建好的存储桶, 已经有的不动
+返回值:
+	*LocalStorage: 本地目录存储后端
+	error: 错误, 目录建不了或者存储桶名字不对
+*/
+func NewLocal(root string, signer *Signer, buckets ...string) (*LocalStorage, error) {
+	if root == "" {
+		return nil, errors.New("本地存储根目录 storage.local_root 不能为空")
+	}
+	l := &LocalStorage{root: root, presign: signer}
+	if err := os.MkdirAll(filepath.Join(root, tmpDirName), 0755); err != nil {
+		return nil, fmt.Errorf("创建本地存储目录 %s 失败: %w", root, err)
+	}
+	for _, bucketName := range buckets {
+		if err := l.BucketAdd(bucketName); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// 建存储桶, 就是建个目录, 已经有了什么也不做
+func (l *LocalStorage) BucketAdd(bucketName string) error {
+	if !validLocalBucket(bucketName) {
+		return fmt.Errorf("存储桶名字不合法: %q", bucketName)
+	}
+	if err := os.MkdirAll(filepath.Join(l.root, bucketName), 0755); err != nil {
+		return fmt.Errorf("创建存储桶目录 %s 失败: %w", bucketName, err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) signer() *Signer {
+	return l.presign
+}
+
+// 上传
+/*
+思路:
+	1. 先写到 .tmp 下的临时文件, 边写边算 md5 当 ETag
+	2. 加锁, 临时文件改名成对象文件, 再写元数据文件
+*/
+func (l *LocalStorage) Put(ctx context.Context, bucketName string, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	fileName, err := l.objectPath(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType, body = mys3.DetectContentType(key, body)
+	}
+
+	// 1. 先写临时文件
+	tmp, err := os.CreateTemp(filepath.Join(l.root, tmpDirName), "put-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // 改名成功后就不存在了, 删除会失败, 不用管
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("写入 %s:%s 失败: %w", bucketName, key, err)
+	}
+	meta := localMeta{ContentType: contentType, ETag: quoteETag(hash.Sum(nil)), Metadata: copyMetadata(opts.Metadata)}
+
+	// 2. 改名, 写元数据
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err = os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, fmt.Errorf("创建目录失败 %s:%s: %w", bucketName, key, err)
+	}
+	if err = os.Rename(tmp.Name(), fileName); err != nil {
+		return nil, fmt.Errorf("保存 %s:%s 失败: %w", bucketName, key, err)
+	}
+	if err = writeLocalMeta(fileName, meta); err != nil {
+		return nil, err
+	}
+	log.Debugf("本地存储上传成功 %s:%s", bucketName, key)
+	return l.info(key, fileName)
+}
+
+// 下载, 返回的是打开的文件
+func (l *LocalStorage) Get(ctx context.Context, bucketName string, key string) (io.ReadCloser, *ObjectInfo, error) {
+	fileName, err := l.objectPath(bucketName, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	info, err := l.info(key, fileName)
+	if err != nil {
+		return nil, nil, l.notFound(bucketName, key, err)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, l.notFound(bucketName, key, err)
+	}
+	return file, info, nil
+}
+
+// 查元数据
+func (l *LocalStorage) Head(ctx context.Context, bucketName string, key string) (*ObjectInfo, error) {
+	fileName, err := l.objectPath(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	info, err := l.info(key, fileName)
+	if err != nil {
+		return nil, l.notFound(bucketName, key, err)
+	}
+	return info, nil
+}
+
+// 分页查, 每次都把存储桶目录整个遍历一遍, 对象多了会慢, 离线开发够用
+func (l *LocalStorage) List(ctx context.Context, bucketName string, opts ListOptions) (*ListPage, error) {
+	bucketDir, err := l.bucketPath(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var keys []string
+	err = filepath.WalkDir(bucketDir, func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(fileName, metaFileSuffix) || strings.HasSuffix(fileName, metaFileSuffix+".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, fileName)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("遍历存储桶 %s 失败: %w", bucketName, err)
+	}
+	sort.Strings(keys) // 遍历是按每层目录排序的, "a.txt" 会排在 "a/1.txt" 后面, 要按整个key重新排
+	return listKeys(keys, opts, func(key string) ObjectInfo {
+		info, err := l.info(key, filepath.Join(bucketDir, filepath.FromSlash(key)))
+		if err != nil {
+			log.Warnf("读取 %s:%s 的信息失败, err= %v", bucketName, key, err)
+			return ObjectInfo{Key: key, StorageClass: storageClassStandard}
+		}
+		return info.listed()
+	})
+}
+
+// 删除, 不存在也算成功, 删完把空目录也删掉, 和 s3 一样没有对象就没有 "目录"
+func (l *LocalStorage) Delete(ctx context.Context, bucketName string, key string) error {
+	fileName, err := l.objectPath(bucketName, key)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, name := range []string{fileName, fileName + metaFileSuffix} {
+		if err = os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("删除 %s:%s 失败: %w", bucketName, key, err)
+		}
+	}
+	bucketDir := filepath.Join(l.root, bucketName)
+	for dir := filepath.Dir(fileName); dir != bucketDir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil { // 不是空目录删不掉, 上面的也不用看了
+			break
+		}
+	}
+	log.Debugf("本地存储删除成功 %s:%s", bucketName, key)
+	return nil
+}
+
+// 复制, 内容、Content-Type、用户元数据都保留
+func (l *LocalStorage) Copy(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string) (*ObjectInfo, error) {
+	body, info, err := l.Get(ctx, srcBucket, srcKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return l.Put(ctx, dstBucket, dstKey, body, PutOptions{ContentType: info.ContentType, Metadata: info.Metadata})
+}
+
+// 预签名, 只支持 GET/PUT, 由 Handler 提供下载/上传
+func (l *LocalStorage) Presign(ctx context.Context, method string, bucketName string, key string, opts PresignOptions) (*PresignResult, error) {
+	if _, err := l.objectPath(bucketName, key); err != nil {
+		return nil, err
+	}
+	return l.presign.Presign(method, bucketName, key, opts)
+}
+
+// 存储桶目录, 不存在返回 ErrBucketNotFound
+func (l *LocalStorage) bucketPath(bucketName string) (string, error) {
+	if !validLocalBucket(bucketName) {
+		return "", fmt.Errorf("%s: %w", bucketName, ErrBucketNotFound)
+	}
+	bucketDir := filepath.Join(l.root, bucketName)
+	if stat, err := os.Stat(bucketDir); err != nil || !stat.IsDir() {
+		return "", fmt.Errorf("%s: %w", bucketName, ErrBucketNotFound)
+	}
+	return bucketDir, nil
+}
+
+// 对象文件路径, key 要能安全地变成相对路径
+func (l *LocalStorage) objectPath(bucketName string, key string) (string, error) {
+	if !validLocalKey(key) {
+		return "", fmt.Errorf("%q: %w", key, ErrInvalidKey)
+	}
+	bucketDir, err := l.bucketPath(bucketName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(bucketDir, filepath.FromSlash(key)), nil
+}
+
+// 对象信息, 大小和修改时间看对象文件, 其他看元数据文件
+/*
+说明:
+	没有元数据文件 (手动拷进来的文件) 时, Content-Type 按扩展名和内容判断, ETag 现算 md5
+*/
+func (l *LocalStorage) info(key string, fileName string) (*ObjectInfo, error) {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	meta, err := readLocalMeta(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ETag:         meta.ETag,
+		ContentType:  meta.ContentType,
+		StorageClass: storageClassStandard,
+		LastModified: stat.ModTime().UTC().Truncate(time.Second),
+		Metadata:     copyMetadata(meta.Metadata),
+	}, nil
+}
+
+// 文件不存在 -> ErrNotFound
+func (l *LocalStorage) notFound(bucketName string, key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s:%s: %w", bucketName, key, ErrNotFound)
+	}
+	return fmt.Errorf("读取 %s:%s 失败: %w", bucketName, key, err)
+}
+
+// 写元数据文件, 先写临时文件再改名
+func writeLocalMeta(fileName string, meta localMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmpName := fileName + metaFileSuffix + ".tmp"
+	if err = os.WriteFile(tmpName, data, 0644); err != nil {
+		return fmt.Errorf("写元数据文件失败: %w", err)
+	}
+	if err = os.Rename(tmpName, fileName+metaFileSuffix); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("写元数据文件失败: %w", err)
+	}
+	return nil
+}
+
+// 读元数据文件, 没有就现算
+func readLocalMeta(fileName string) (localMeta, error) {
+	var meta localMeta
+	data, err := os.ReadFile(fileName + metaFileSuffix)
+	if err == nil {
+		if err = json.Unmarshal(data, &meta); err != nil {
+			return meta, fmt.Errorf("元数据文件 %s 格式不对: %w", fileName+metaFileSuffix, err)
+		}
+		return meta, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return meta, err
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return meta, err
+	}
+	defer file.Close()
+	meta.ContentType, _ = mys3.DetectContentType(fileName, file) // 文件能 Seek, 判断完会 Seek 回开头
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return meta, err
+	}
+	meta.ETag = quoteETag(hash.Sum(nil))
+	return meta, nil
+}
+
+// 存储桶名字: 不能为空, 不能是路径, 不能以 "." 开头 (.tmp 是临时目录)
+func validLocalBucket(bucketName string) bool {
+	return bucketName != "" && !strings.ContainsAny(bucketName, `/\:`) && !strings.HasPrefix(bucketName, ".")
+}
+
+// 对象key: 每一段都不能为空、不能是 "." ".."; 不能是元数据文件和它的临时文件; 不能有 "\" (windows 会当成目录)
+func validLocalKey(key string) bool {
+	if key == "" || strings.ContainsAny(key, "\\\x00") || strings.HasSuffix(key, metaFileSuffix) || strings.HasSuffix(key, metaFileSuffix+".tmp") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
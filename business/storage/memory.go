@@ -0,0 +1,180 @@
+// 功能: 内存存储后端, 重启就没了, 测试用
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"study-aws-api-go/business/mys3"
+	"sync"
+	"time"
+)
+
+// 内存存储后端
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]*memoryObject // 存储桶 -> key -> 对象
+	presign *Signer                             // 预签名, 为 nil 不支持
+}
+
+// 内存里的对象
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+// 创建内存存储后端
+/*
+参数:
+	signer *Signer : 预签名签名器, 可以为 nil
+	buckets ...string : 建好的存储桶
+返回值:
+	*MemoryStorage: 内存存储后端
+*/
+func NewMemory(signer *Signer, buckets ...string) *MemoryStorage {
+	m := &MemoryStorage{buckets: map[string]map[string]*memoryObject{}, presign: signer}
+	for _, bucketName := range buckets {
+		m.BucketAdd(bucketName)
+	}
+	return m
+}
+
+// 建存储桶, 已经有了什么也不做
+func (m *MemoryStorage) BucketAdd(bucketName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[bucketName]; !ok {
+		m.buckets[bucketName] = map[string]*memoryObject{}
+	}
+}
+
+func (m *MemoryStorage) signer() *Signer {
+	return m.presign
+}
+
+// 上传, 整个读进内存
+func (m *MemoryStorage) Put(ctx context.Context, bucketName string, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType, body = mys3.DetectContentType(key, body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("读取上传内容 %s:%s 失败: %w", bucketName, key, err)
+	}
+	sum := md5.Sum(data)
+	obj := &memoryObject{data: data, info: ObjectInfo{
+		Key:          key,
+		Size:         int64(len(data)),
+		ETag:         quoteETag(sum[:]),
+		ContentType:  contentType,
+		StorageClass: storageClassStandard,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+		Metadata:     copyMetadata(opts.Metadata),
+	}}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	objects, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", bucketName, ErrBucketNotFound)
+	}
+	objects[key] = obj
+	info := obj.info.clone()
+	return &info, nil
+}
+
+// 下载
+func (m *MemoryStorage) Get(ctx context.Context, bucketName string, key string) (io.ReadCloser, *ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, err := m.object(bucketName, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := obj.info.clone()
+	return io.NopCloser(bytes.NewReader(obj.data)), &info, nil // 对象只会整个替换, 不会改 data, 不用复制
+}
+
+// 查元数据
+func (m *MemoryStorage) Head(ctx context.Context, bucketName string, key string) (*ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, err := m.object(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	info := obj.info.clone()
+	return &info, nil
+}
+
+// 分页查
+func (m *MemoryStorage) List(ctx context.Context, bucketName string, opts ListOptions) (*ListPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objects, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", bucketName, ErrBucketNotFound)
+	}
+	return listKeys(sortedKeys(objects), opts, func(key string) ObjectInfo {
+		return objects[key].info.listed()
+	})
+}
+
+// 删除, 不存在也算成功
+func (m *MemoryStorage) Delete(ctx context.Context, bucketName string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	objects, ok := m.buckets[bucketName]
+	if !ok {
+		return fmt.Errorf("%s: %w", bucketName, ErrBucketNotFound)
+	}
+	delete(objects, key)
+	return nil
+}
+
+// 复制, 内容、Content-Type、用户元数据都保留
+func (m *MemoryStorage) Copy(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string) (*ObjectInfo, error) {
+	if dstKey == "" {
+		return nil, ErrInvalidKey
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src, err := m.object(srcBucket, srcKey)
+	if err != nil {
+		return nil, err
+	}
+	objects, ok := m.buckets[dstBucket]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", dstBucket, ErrBucketNotFound)
+	}
+	dst := &memoryObject{data: src.data, info: src.info.clone()}
+	dst.info.Key = dstKey
+	dst.info.LastModified = time.Now().UTC().Truncate(time.Second)
+	objects[dstKey] = dst
+	info := dst.info.clone()
+	return &info, nil
+}
+
+// 预签名, 只支持 GET/PUT, 由 Handler 提供下载/上传
+func (m *MemoryStorage) Presign(ctx context.Context, method string, bucketName string, key string, opts PresignOptions) (*PresignResult, error) {
+	return m.presign.Presign(method, bucketName, key, opts)
+}
+
+// 找对象, 调用方要先加锁
+func (m *MemoryStorage) object(bucketName string, key string) (*memoryObject, error) {
+	objects, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", bucketName, ErrBucketNotFound)
+	}
+	obj, ok := objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%s:%s: %w", bucketName, key, ErrNotFound)
+	}
+	return obj, nil
+}
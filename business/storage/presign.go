@@ -0,0 +1,239 @@
+// 功能: 本地/内存后端的预签名url, 用 HMAC 签名, 由 Handler 挂在 gin 上提供下载/上传, 前端用法和 s3 预签名一样
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"study-aws-api-go/log"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 预签名url的签名器
+type Signer struct {
+	baseURL string // url前缀, 如 http://localhost:8888/storage
+	secret  []byte // HMAC 密钥
+}
+
+// 本地/内存后端实现, Handler 用来拿签名器
+type presigner interface {
+	signer() *Signer
+}
+
+// 创建签名器
+/*
+参数:
+	baseURL string : url前缀, 指向 Handler 挂的路由; 为空返回 nil, 这个后端就不支持预签名
+	secret string : HMAC 密钥, 为空随机生成, 重启后以前的url就失效了
+返回值:
+	*Signer: 签名器
+	error: 错误
+*/
+func NewSigner(baseURL string, secret string) (*Signer, error) {
+	if baseURL == "" {
+		log.Warn("没有配置 storage.presign_base_url, 预签名不可用")
+		return nil, nil
+	}
+	signer := &Signer{baseURL: strings.TrimSuffix(baseURL, "/"), secret: []byte(secret)}
+	if secret == "" {
+		signer.secret = make([]byte, 32)
+		if _, err := rand.Read(signer.secret); err != nil {
+			return nil, fmt.Errorf("生成预签名密钥失败: %w", err)
+		}
+		log.Warn("没有配置 storage.presign_secret, 使用随机密钥, 重启后以前的预签名url失效")
+	}
+	return signer, nil
+}
+
+// 预签名, 只支持 GET/PUT
+/*
+参数:
+	method string : GET/PUT
+	bucketName string : 存储桶名称
+	key string : 对象key
+	opts PresignOptions : 有效期、Content-Type、PUT 的大小
+返回值:
+	*PresignResult: 预签名结果, PUT 时 Header 里的头前端上传时必须带上
+	error: 不支持的方法返回 ErrNotSupported
+*/
+func (s *Signer) Presign(method string, bucketName string, key string, opts PresignOptions) (*PresignResult, error) {
+	if s == nil {
+		return nil, fmt.Errorf("预签名 %s:%s 失败: %w", bucketName, key, ErrNotSupported)
+	}
+	method = strings.ToUpper(method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		return nil, fmt.Errorf("预签名方法 %s: %w, 只支持 GET/PUT", method, ErrNotSupported)
+	}
+	if strings.ContainsFunc(key, unicode.IsControl) { // 换行这些控制字符放到url里容易出问题, 直接不让签
+		return nil, fmt.Errorf("预签名 %s:%q 失败, key 有控制字符: %w", bucketName, key, ErrInvalidKey)
+	}
+
+	expires := opts.ExpiresOrDefault()
+	expiresAt := time.Now().Add(expires)
+	params := url.Values{}
+	params.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	if opts.ContentType != "" {
+		params.Set("content-type", opts.ContentType)
+	}
+	if method == http.MethodPut && opts.ContentLength > 0 {
+		params.Set("content-length", strconv.FormatInt(opts.ContentLength, 10))
+	}
+	params.Set("signature", s.signature(method, bucketName, key, params))
+
+	result := &PresignResult{
+		URL:     s.baseURL + "/" + url.PathEscape(bucketName) + "/" + escapeKey(key) + "?" + params.Encode(),
+		Method:  method,
+		Header:  http.Header{},
+		Expires: expiresAt,
+	}
+	if method == http.MethodPut && opts.ContentType != "" {
+		result.Header.Set("Content-Type", opts.ContentType)
+	}
+	log.Debugf("生成%s预签名url成功 %s:%s, 有效期 %v", method, bucketName, key, expires)
+	return result, nil
+}
+
+// 校验预签名url
+/*
+参数:
+	method string : 请求的方法
+	bucketName string : 存储桶名称
+	key string : 对象key
+	params url.Values : url 参数
+返回值:
+	error: 过期或签名不对
+*/
+func (s *Signer) verify(method string, bucketName string, key string, params url.Values) error {
+	expiresAt, err := strconv.ParseInt(params.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("预签名url缺少有效期")
+	}
+	if time.Now().Unix() > expiresAt {
+		return errors.New("预签名url已过期")
+	}
+	expected := s.signature(method, bucketName, key, params)
+	if !hmac.Equal([]byte(params.Get("signature")), []byte(expected)) {
+		return errors.New("预签名url签名不对")
+	}
+	return nil
+}
+
+// 签名: HMAC-SHA256(方法、存储桶、key、有效期、Content-Type、大小 按 url 参数编码), 十六进制
+/*
+说明:
+	每个字段都做了 url 编码, "&" "=" 换行都会被转义, 不会出现挪动字段分隔符还能签出一样结果的情况
+*/
+func (s *Signer) signature(method string, bucketName string, key string, params url.Values) string {
+	fields := url.Values{
+		"method":         {method},
+		"bucket":         {bucketName},
+		"key":            {key},
+		"expires":        {params.Get("expires")},
+		"content-type":   {params.Get("content-type")},
+		"content-length": {params.Get("content-length")},
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(fields.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// key 按段编码, "/" 保留
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// 预签名url的 gin 处理函数, 挂在 storage.presign_base_url 对应的路由上
+/*
+参数:
+	st Storage : 存储后端, 只有本地/内存后端能用
+返回值:
+	gin.HandlerFunc: 处理函数, st 不支持时返回 nil; 路由要有 :bucket 和 *key 两个参数
+使用方式：
+	if handler := storage.Handler(store); handler != nil {
+		r.GET("/storage/:bucket/*key", handler)
+		r.PUT("/storage/:bucket/*key", handler)
+	}
+*/
+func Handler(st Storage) gin.HandlerFunc {
+	p, ok := st.(presigner)
+	if !ok || p.signer() == nil {
+		return nil
+	}
+	signer := p.signer()
+	return func(c *gin.Context) {
+		bucketName := c.Param("bucket")
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		params := c.Request.URL.Query()
+		if err := signer.verify(c.Request.Method, bucketName, key, params); err != nil {
+			log.Warnf("预签名url校验失败 %s %s:%s, err= %v", c.Request.Method, bucketName, key, err)
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet:
+			body, info, err := st.Get(c.Request.Context(), bucketName, key)
+			if err != nil {
+				c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			defer body.Close()
+			contentType := info.ContentType
+			if params.Get("content-type") != "" {
+				contentType = params.Get("content-type")
+			}
+			c.DataFromReader(200, info.Size, contentType, body, map[string]string{
+				"ETag":          info.ETag,
+				"Last-Modified": info.LastModified.UTC().Format(http.TimeFormat),
+			})
+		case http.MethodPut:
+			contentType := c.GetHeader("Content-Type")
+			if signed := params.Get("content-type"); signed != "" && signed != contentType {
+				c.JSON(403, gin.H{"error": "Content-Type 和预签名的不一样"})
+				return
+			}
+			if signed := params.Get("content-length"); signed != "" && signed != strconv.FormatInt(c.Request.ContentLength, 10) {
+				c.JSON(403, gin.H{"error": "文件大小和预签名的不一样"})
+				return
+			}
+			info, err := st.Put(c.Request.Context(), bucketName, key, c.Request.Body, PutOptions{ContentType: contentType})
+			if err != nil {
+				c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.Header("ETag", info.ETag)
+			c.Status(200)
+		default:
+			c.JSON(405, gin.H{"error": "只支持 GET/PUT"})
+		}
+	}
+}
+
+// 错误 -> http 状态码
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrBucketNotFound):
+		return 404
+	case errors.Is(err, ErrInvalidKey):
+		return 400
+	default:
+		return 500
+	}
+}
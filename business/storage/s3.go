@@ -0,0 +1,157 @@
+// 功能: s3 存储后端, 包一层 mys3.BucketBasics, 错误转成 ErrNotFound/ErrBucketNotFound
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"study-aws-api-go/business/mys3"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+)
+
+// s3 存储后端
+type S3Storage struct {
+	basics mys3.BucketBasics
+}
+
+// 创建 s3 存储后端
+func NewS3(basics mys3.BucketBasics) *S3Storage {
+	return &S3Storage{basics: basics}
+}
+
+// 上传, 用 ObjectUploadStream, 大文件自动分段; s3 返回的信息不全, 再 HEAD 一次
+func (s *S3Storage) Put(ctx context.Context, bucketName string, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	_, err := s.basics.ObjectUploadStream(ctx, bucketName, key, body, mys3.UploadOptions{
+		ContentType: opts.ContentType,
+		Metadata:    opts.Metadata,
+	})
+	if err != nil {
+		return nil, s3Error(err, bucketName, key)
+	}
+	return s.Head(ctx, bucketName, key)
+}
+
+// 下载, 返回 GetObject 的响应体; 客户端加密的对象要用 mys3.ObjectDownload
+func (s *S3Storage) Get(ctx context.Context, bucketName string, key string) (io.ReadCloser, *ObjectInfo, error) {
+	output, err := s.basics.ObjectGet(ctx, bucketName, key)
+	if err != nil {
+		return nil, nil, s3Error(err, bucketName, key)
+	}
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(output.ContentLength),
+		ETag:         aws.ToString(output.ETag),
+		ContentType:  aws.ToString(output.ContentType),
+		StorageClass: string(output.StorageClass),
+		LastModified: aws.ToTime(output.LastModified),
+		Metadata:     copyMetadata(output.Metadata),
+	}
+	if info.StorageClass == "" {
+		info.StorageClass = storageClassStandard
+	}
+	return output.Body, info, nil
+}
+
+// 查元数据
+func (s *S3Storage) Head(ctx context.Context, bucketName string, key string) (*ObjectInfo, error) {
+	metadata, err := s.basics.ObjectMetadataGet(ctx, bucketName, key)
+	if err != nil {
+		return nil, s3Error(err, bucketName, key)
+	}
+	return &ObjectInfo{
+		Key:          metadata.Key,
+		Size:         metadata.Size,
+		ETag:         metadata.ETag,
+		ContentType:  metadata.ContentType,
+		StorageClass: string(metadata.StorageClass),
+		LastModified: metadata.LastModified,
+		Metadata:     metadata.Metadata,
+	}, nil
+}
+
+// 分页查
+func (s *S3Storage) List(ctx context.Context, bucketName string, opts ListOptions) (*ListPage, error) {
+	output, err := s.basics.ObjectList(ctx, bucketName, mys3.ListOptions(opts))
+	if err != nil {
+		return nil, s3Error(err, bucketName, "")
+	}
+	page := &ListPage{
+		CommonPrefixes:        output.CommonPrefixes,
+		NextContinuationToken: output.NextContinuationToken,
+		IsTruncated:           output.IsTruncated,
+	}
+	for _, obj := range output.Objects {
+		page.Objects = append(page.Objects, ObjectInfo{
+			Key:          aws.ToString(obj.Key),
+			Size:         aws.ToInt64(obj.Size),
+			ETag:         aws.ToString(obj.ETag),
+			StorageClass: string(obj.StorageClass),
+			LastModified: aws.ToTime(obj.LastModified),
+		})
+	}
+	return page, nil
+}
+
+// 删除, 会等到对象确实没了
+func (s *S3Storage) Delete(ctx context.Context, bucketName string, key string) error {
+	if _, err := s.basics.ObjectDelete(ctx, bucketName, key, "", false); err != nil {
+		return s3Error(err, bucketName, key)
+	}
+	return nil
+}
+
+// 复制, 用 ObjectCopy, 大对象自动分段复制, 元数据和标签都保留
+func (s *S3Storage) Copy(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string) (*ObjectInfo, error) {
+	if _, err := s.basics.ObjectCopy(ctx, srcBucket, srcKey, dstBucket, dstKey, mys3.CopyOptions{}); err != nil {
+		return nil, s3CopyError(err, srcBucket, srcKey, dstBucket, dstKey)
+	}
+	return s.Head(ctx, dstBucket, dstKey)
+}
+
+// 预签名 GET/PUT/POST
+func (s *S3Storage) Presign(ctx context.Context, method string, bucketName string, key string, opts PresignOptions) (*PresignResult, error) {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet:
+		return s.basics.ObjectPresignGet(ctx, bucketName, key, opts)
+	case http.MethodPut:
+		return s.basics.ObjectPresignPut(ctx, bucketName, key, opts)
+	case http.MethodPost:
+		return s.basics.ObjectPresignPost(ctx, bucketName, key, opts)
+	default:
+		return nil, fmt.Errorf("预签名方法 %s: %w, 只支持 GET/PUT/POST", method, ErrNotSupported)
+	}
+}
+
+// s3 错误码 -> ErrNotFound/ErrBucketNotFound, 原来的错误还在, errors.As 也能拿到
+func s3Error(err error, bucketName string, key string) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%s:%s: %w: %w", bucketName, key, ErrNotFound, err)
+	case "NoSuchBucket":
+		return fmt.Errorf("%s: %w: %w", bucketName, ErrBucketNotFound, err)
+	}
+	return err
+}
+
+// 复制的错误, 源和目标都带上, 存储桶不存在可能是源也可能是目标
+func s3CopyError(err error, srcBucket string, srcKey string, dstBucket string, dstKey string) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		case "NoSuchBucket":
+			err = fmt.Errorf("%w: %w", ErrBucketNotFound, err)
+		}
+	}
+	return fmt.Errorf("复制 %s:%s -> %s:%s: %w", srcBucket, srcKey, dstBucket, dstKey, err)
+}
@@ -0,0 +1,242 @@
+// 功能: 存储后端接口, 业务代码只依赖 Storage, 具体用 s3 / 本地目录 / 内存 在 config.yaml 的 storage.backend 里选
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"study-aws-api-go/business/mys3"
+	"study-aws-api-go/log"
+	"time"
+)
+
+// 变量
+const (
+	BackendS3     = "s3"     // aws s3 或兼容 s3 的服务 (MinIO/SeaweedFS/LocalStack), 默认
+	BackendLocal  = "local"  // 本地目录, 存储桶是目录, 元数据放在旁边的 .meta.json 文件里, 离线开发用
+	BackendMemory = "memory" // 内存, 重启就没了, 测试用
+
+	maxListKeys          = 1000       // 一页最多 1000 个, 和 s3 一样
+	storageClassStandard = "STANDARD" // 本地/内存后端的存储类型
+)
+
+var (
+	ErrNotFound       = errors.New("对象不存在")
+	ErrBucketNotFound = errors.New("存储桶不存在")
+	ErrInvalidKey     = errors.New("对象key不合法")
+	ErrNotSupported   = errors.New("当前存储后端不支持这个操作")
+)
+
+// 存储后端
+/*
+说明:
+	bucketName 是存储桶, 本地目录后端里是 local_root 下的子目录, 要先建好 (配置 storage.buckets)
+	对象不存在返回 ErrNotFound, 存储桶不存在返回 ErrBucketNotFound, 用 errors.Is 判断
+*/
+type Storage interface {
+	Put(ctx context.Context, bucketName string, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error) // 上传, 同名覆盖
+	Get(ctx context.Context, bucketName string, key string) (io.ReadCloser, *ObjectInfo, error)                   // 下载, 用完要 Close
+	Head(ctx context.Context, bucketName string, key string) (*ObjectInfo, error)                                 // 查元数据, 不下载内容
+	List(ctx context.Context, bucketName string, opts ListOptions) (*ListPage, error)                             // 分页查
+	Delete(ctx context.Context, bucketName string, key string) error                                              // 删除, 不存在也算成功
+	Copy(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string) (*ObjectInfo, error)
+	Presign(ctx context.Context, method string, bucketName string, key string, opts PresignOptions) (*PresignResult, error) // 预签名 GET/PUT/POST
+}
+
+// 预签名选项和结果, 和 mys3 的一样, 前端不用区分是哪个后端
+type PresignOptions = mys3.PresignOptions
+type PresignResult = mys3.PresignResult
+
+// 对象信息
+type ObjectInfo struct {
+	Key          string            `json:"key"`                   // 对象key
+	Size         int64             `json:"size"`                  // 大小
+	ETag         string            `json:"etag"`                  // ETag, 带双引号, 和 s3 一样
+	ContentType  string            `json:"contentType,omitempty"` // Content-Type, List 返回的没有
+	StorageClass string            `json:"storageClass"`          // 存储类型, 本地/内存后端都是 STANDARD
+	LastModified time.Time         `json:"lastModified"`          // 修改时间
+	Metadata     map[string]string `json:"metadata,omitempty"`    // 用户元数据, key 都是小写, List 返回的没有
+}
+
+// 上传选项
+type PutOptions struct {
+	ContentType string            // Content-Type, 不填按扩展名和内容自动检测
+	Metadata    map[string]string // 用户元数据, 如 {"comic-id": "1024"}
+}
+
+// 查询选项, 同 mys3.ListOptions
+type ListOptions struct {
+	Prefix            string // 前缀, 如 "亲家四姊妹/"
+	Delimiter         string // 分隔符, 一般是 "/", 填了就会把下一级 "目录" 放进 CommonPrefixes
+	MaxKeys           int32  // 每页最多几个 (对象+目录一起算), <=0 或 >1000 按 1000
+	ContinuationToken string // 上一页返回的 NextContinuationToken, 第一页不填
+}
+
+// 一页查询结果
+type ListPage struct {
+	Objects               []ObjectInfo // 对象
+	CommonPrefixes        []string     // 下一级 "目录", 只有填了 Delimiter 才有
+	NextContinuationToken string       // 下一页的 token, 没有下一页为 ""
+	IsTruncated           bool         // 是否还有下一页
+}
+
+// 配置, main.go 从 config.yaml 的 storage 读出来
+type Config struct {
+	Backend        string   // s3(默认) / local / memory
+	LocalRoot      string   // local: 根目录, 下面一个存储桶一个目录
+	Buckets        []string // local/memory: 启动时建好的存储桶
+	PresignBaseURL string   // local/memory: 预签名url前缀, 指向 Handler 挂的路由, 如 http://localhost:8888/storage
+	PresignSecret  string   // local/memory: 预签名密钥, 不填启动时随机生成, 重启后以前的url就失效了
+}
+
+// 按配置创建存储后端
+/*
+参数:
+	cfg Config : 配置
+	basics mys3.BucketBasics : s3 客户端, 只有 s3 后端用
+返回值:
+	Storage: 存储后端
+	error: 错误, 后端名字不对或者本地目录建不了
+*/
+func New(cfg Config, basics mys3.BucketBasics) (Storage, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", BackendS3:
+		log.Info("存储后端: s3")
+		return NewS3(basics), nil
+	case BackendLocal:
+		signer, err := NewSigner(cfg.PresignBaseURL, cfg.PresignSecret)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("存储后端: 本地目录 %s, 存储桶 %v", cfg.LocalRoot, cfg.Buckets)
+		return NewLocal(cfg.LocalRoot, signer, cfg.Buckets...)
+	case BackendMemory:
+		signer, err := NewSigner(cfg.PresignBaseURL, cfg.PresignSecret)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("存储后端: 内存, 存储桶 %v", cfg.Buckets)
+		return NewMemory(signer, cfg.Buckets...), nil
+	default:
+		return nil, fmt.Errorf("storage.backend 配置错误: %s, 只支持 s3/local/memory", cfg.Backend)
+	}
+}
+
+// 每页个数, 没填或超过1000按1000
+func (opts ListOptions) maxKeys() int {
+	if opts.MaxKeys <= 0 || opts.MaxKeys > maxListKeys {
+		return maxListKeys
+	}
+	return int(opts.MaxKeys)
+}
+
+// 分页, 本地/内存后端共用, 行为和 ListObjectsV2 一样
+/*
+参数:
+	keys []string : 存储桶里所有的key, 已经排好序
+	opts ListOptions : 前缀、分隔符、每页个数、token
+	info func(key string) ObjectInfo : 取对象信息
+返回值:
+	*ListPage: 一页结果
+	error: token 不合法
+思路:
+	token 是上一页最后一项, 前面加 "k:" 表示对象, "p:" 表示目录, 再 base64;
+	上一页停在目录上时, 这个目录下的key都要跳过
+*/
+func listKeys(keys []string, opts ListOptions, info func(key string) ObjectInfo) (*ListPage, error) {
+	var after, afterPrefix string
+	if opts.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(opts.ContinuationToken)
+		if err != nil || len(token) < 2 || (token[0] != 'k' && token[0] != 'p') || token[1] != ':' {
+			return nil, fmt.Errorf("token 不合法: %s", opts.ContinuationToken)
+		}
+		after = string(token[2:])
+		if token[0] == 'p' {
+			afterPrefix = after
+		}
+	}
+
+	page := &ListPage{}
+	maxKeys := opts.maxKeys()
+	count := 0
+	last := ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, opts.Prefix) || key <= after {
+			continue
+		}
+		if afterPrefix != "" && strings.HasPrefix(key, afterPrefix) {
+			continue
+		}
+
+		// 有分隔符, 下一级目录只返回一次
+		prefix := ""
+		if opts.Delimiter != "" {
+			if i := strings.Index(key[len(opts.Prefix):], opts.Delimiter); i >= 0 {
+				prefix = key[:len(opts.Prefix)+i+len(opts.Delimiter)]
+				if prefix == last {
+					continue
+				}
+			}
+		}
+		if count == maxKeys {
+			page.IsTruncated = true
+			break
+		}
+		count++
+		if prefix != "" {
+			page.CommonPrefixes = append(page.CommonPrefixes, prefix)
+			last = prefix
+			page.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte("p:" + prefix))
+			continue
+		}
+		page.Objects = append(page.Objects, info(key))
+		last = key
+		page.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte("k:" + key))
+	}
+	if !page.IsTruncated {
+		page.NextContinuationToken = ""
+	}
+	return page, nil
+}
+
+// 复制一份, 元数据 map 不共用
+func (info ObjectInfo) clone() ObjectInfo {
+	info.Metadata = copyMetadata(info.Metadata)
+	return info
+}
+
+// List 返回的信息, 和 ListObjectsV2 一样没有 Content-Type 和用户元数据
+func (info ObjectInfo) listed() ObjectInfo {
+	info.ContentType = ""
+	info.Metadata = nil
+	return info
+}
+
+// md5 -> 带双引号的 ETag, 和 s3 单次上传的一样
+func quoteETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+// 排序后的key
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 用户元数据复制一份, key 转小写, 和 s3 返回的一样
+func copyMetadata(metadata map[string]string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[strings.ToLower(k)] = v
+	}
+	return result
+}
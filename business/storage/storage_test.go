@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"study-aws-api-go/business/mys3"
+	"study-aws-api-go/business/mys3/mys3test"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/gin-gonic/gin"
+)
+
+const testBucket = "storage-test"
+
+// 三个后端跑同一套测试, s3 后端连内存版 s3
+func testBackends(t *testing.T) map[string]Storage {
+	t.Helper()
+	srv := mys3test.NewServer()
+	t.Cleanup(srv.Close)
+	ctx, client := mys3.InitS3Client("ap-northeast-1", "test", "test", "", mys3.WithEndpoint(srv.URL, true, true))
+	basics := mys3.BucketBasics{S3Client: client, S3Manager: manager.NewUploader(client), S3Downloader: manager.NewDownloader(client)}
+	if err := basics.BucketAdd(ctx, testBucket, "ap-northeast-1"); err != nil {
+		t.Fatalf("创建存储桶失败: %v", err)
+	}
+
+	local, err := NewLocal(t.TempDir(), nil, testBucket)
+	if err != nil {
+		t.Fatalf("创建本地存储失败: %v", err)
+	}
+	return map[string]Storage{
+		BackendS3:     NewS3(basics),
+		BackendLocal:  local,
+		BackendMemory: NewMemory(nil, testBucket),
+	}
+}
+
+// s3 后端的每个操作都要用存储桶所在区域的客户端, 默认客户端连的是不存在的地址
+func TestS3StorageBucketRegion(t *testing.T) {
+	srv := mys3test.NewServer()
+	t.Cleanup(srv.Close)
+	ctx, client := mys3.InitS3Client("ap-northeast-1", "test", "test", "", mys3.WithEndpoint("127.0.0.1:1", true, true))
+	registry := mys3.NewClientRegistry("ap-northeast-1")
+	registry.AccountAdd("", aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("test", "test", "")), mys3.WithEndpoint(srv.URL, true, true))
+	basics := mys3.BucketBasics{S3Client: client, Registry: registry}
+	if err := basics.BucketAdd(ctx, testBucket, "us-west-2"); err != nil {
+		t.Fatalf("创建存储桶失败: %v", err)
+	}
+
+	st := NewS3(basics)
+	if _, err := st.Put(ctx, testBucket, "a.txt", strings.NewReader("hello"), PutOptions{}); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+	body, info, err := st.Get(ctx, testBucket, "a.txt")
+	if err != nil {
+		t.Fatalf("下载失败: %v", err)
+	}
+	defer body.Close()
+	if content, _ := io.ReadAll(body); string(content) != "hello" || info.Size != 5 {
+		t.Fatalf("下载的内容不对: %q, info= %+v", content, info)
+	}
+}
+
+func TestStoragePutGetHead(t *testing.T) {
+	for name, st := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			data := "充满各种变态行为的家"
+			sum := md5.Sum([]byte(data))
+			info, err := st.Put(ctx, testBucket, "comic/1.txt", strings.NewReader(data), PutOptions{Metadata: map[string]string{"Comic-Id": "1024"}})
+			if err != nil {
+				t.Fatalf("上传失败: %v", err)
+			}
+			if info.Size != int64(len(data)) || info.ETag != fmt.Sprintf(`"%x"`, sum) || !strings.HasPrefix(info.ContentType, "text/plain") ||
+				info.Metadata["comic-id"] != "1024" || info.StorageClass != "STANDARD" {
+				t.Fatalf("上传返回的信息不对: %+v", info)
+			}
+
+			body, got, err := st.Get(ctx, testBucket, "comic/1.txt")
+			if err != nil {
+				t.Fatalf("下载失败: %v", err)
+			}
+			content, _ := io.ReadAll(body)
+			body.Close()
+			if string(content) != data || got.ETag != info.ETag {
+				t.Fatalf("下载的内容不对: %q, info= %+v", content, got)
+			}
+
+			if _, err = st.Head(ctx, testBucket, "comic/2.txt"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("不存在的对象应该返回 ErrNotFound, err= %v", err)
+			}
+			if _, _, err = st.Get(ctx, testBucket, "comic/2.txt"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("不存在的对象应该返回 ErrNotFound, err= %v", err)
+			}
+			if _, err = st.Put(ctx, "no-such-bucket", "a.txt", strings.NewReader("a"), PutOptions{}); !errors.Is(err, ErrBucketNotFound) {
+				t.Fatalf("存储桶不存在应该返回 ErrBucketNotFound, err= %v", err)
+			}
+		})
+	}
+}
+
+func TestStorageListCopyDelete(t *testing.T) {
+	for name, st := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, key := range []string{"a/1.txt", "a/2.txt", "b/1.txt", "c.txt"} {
+				if _, err := st.Put(ctx, testBucket, key, strings.NewReader(key), PutOptions{}); err != nil {
+					t.Fatalf("上传 %s 失败: %v", key, err)
+				}
+			}
+
+			// 按目录分页: 第一页是 a/ b/, 第二页是 c.txt
+			page, err := st.List(ctx, testBucket, ListOptions{Delimiter: "/", MaxKeys: 2})
+			if err != nil || !page.IsTruncated || len(page.Objects) != 0 || strings.Join(page.CommonPrefixes, ",") != "a/,b/" {
+				t.Fatalf("第一页不对: %+v, err= %v", page, err)
+			}
+			page, err = st.List(ctx, testBucket, ListOptions{Delimiter: "/", MaxKeys: 2, ContinuationToken: page.NextContinuationToken})
+			if err != nil || page.IsTruncated || len(page.Objects) != 1 || page.Objects[0].Key != "c.txt" || page.Objects[0].Size != 5 {
+				t.Fatalf("第二页不对: %+v, err= %v", page, err)
+			}
+
+			if _, err = st.Copy(ctx, testBucket, "a/1.txt", testBucket, "d/1.txt"); err != nil {
+				t.Fatalf("复制失败: %v", err)
+			}
+			if _, err = st.Copy(ctx, testBucket, "a/1.txt", "no-such-bucket", "d/1.txt"); !errors.Is(err, ErrBucketNotFound) || !strings.Contains(err.Error(), "no-such-bucket") {
+				t.Fatalf("目标存储桶不存在应该返回 ErrBucketNotFound, 错误里要有目标存储桶, err= %v", err)
+			}
+			if _, err = st.Copy(ctx, testBucket, "no-such-key", testBucket, "d/2.txt"); !errors.Is(err, ErrNotFound) ||
+				(name == BackendS3 && !strings.Contains(err.Error(), testBucket+":d/2.txt")) {
+				t.Fatalf("源对象不存在应该返回 ErrNotFound, s3 后端的错误里要有目标, err= %v", err)
+			}
+			for _, key := range []string{"a/1.txt", "a/2.txt", "no-such-key"} {
+				if err = st.Delete(ctx, testBucket, key); err != nil {
+					t.Fatalf("删除 %s 失败: %v", key, err)
+				}
+			}
+			page, err = st.List(ctx, testBucket, ListOptions{})
+			var keys []string
+			for _, obj := range page.Objects {
+				keys = append(keys, obj.Key)
+			}
+			if err != nil || strings.Join(keys, ",") != "b/1.txt,c.txt,d/1.txt" {
+				t.Fatalf("复制删除后的对象不对: %v, err= %v", keys, err)
+			}
+		})
+	}
+}
+
+func TestLocalInvalidKey(t *testing.T) {
+	st, err := NewLocal(t.TempDir(), nil, testBucket)
+	if err != nil {
+		t.Fatalf("创建本地存储失败: %v", err)
+	}
+	for _, key := range []string{"", "../a.txt", "a//b.txt", "a/", `a\b.txt`, "a.txt" + metaFileSuffix, "a.txt" + metaFileSuffix + ".tmp"} {
+		if _, err = st.Put(context.Background(), testBucket, key, strings.NewReader("a"), PutOptions{}); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("key %q 应该返回 ErrInvalidKey, err= %v", key, err)
+		}
+	}
+}
+
+func TestPresignHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	signer, err := NewSigner(srv.URL+"/storage", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewMemory(signer, testBucket)
+	handler := Handler(st)
+	r.GET("/storage/:bucket/*key", handler)
+	r.PUT("/storage/:bucket/*key", handler)
+	ctx := context.Background()
+
+	// PUT: Content-Type 签进去了, 要带上一样的
+	put, err := st.Presign(ctx, http.MethodPut, testBucket, "亲家四姊妹/1.jpg", PresignOptions{ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatalf("生成PUT预签名失败: %v", err)
+	}
+	if resp := doRequest(t, http.MethodPut, put.URL, "text/plain", "jpg"); resp.StatusCode != 403 {
+		t.Fatalf("Content-Type 不一样应该 403, status= %d", resp.StatusCode)
+	}
+	if resp := doRequest(t, http.MethodPut, put.URL, "image/jpeg", "jpg"); resp.StatusCode != 200 {
+		t.Fatalf("预签名上传失败, status= %d", resp.StatusCode)
+	}
+
+	// GET
+	get, err := st.Presign(ctx, "", testBucket, "亲家四姊妹/1.jpg", PresignOptions{})
+	if err != nil {
+		t.Fatalf("生成GET预签名失败: %v", err)
+	}
+	resp := doRequest(t, http.MethodGet, get.URL, "", "")
+	content, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(content) != "jpg" || resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("预签名下载不对, status= %d, content= %q", resp.StatusCode, content)
+	}
+	if resp = doRequest(t, http.MethodGet, strings.Replace(get.URL, "1.jpg", "2.jpg", 1), "", ""); resp.StatusCode != 403 {
+		t.Fatalf("改了key签名就对不上, 应该 403, status= %d", resp.StatusCode)
+	}
+	if _, err = st.Presign(ctx, http.MethodPost, testBucket, "1.jpg", PresignOptions{}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("内存后端不支持 POST, err= %v", err)
+	}
+	if _, err = st.Presign(ctx, http.MethodGet, testBucket, "1.jpg\n2.jpg", PresignOptions{}); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("key 有控制字符应该返回 ErrInvalidKey, err= %v", err)
+	}
+}
+
+func TestPresignSignatureUnambiguous(t *testing.T) {
+	signer, err := NewSigner("http://localhost/storage", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 字段里带分隔符, 挪到别的字段里签名也要不一样
+	a := signer.signature(http.MethodGet, testBucket, "1.jpg\n100", url.Values{"expires": {"200"}})
+	b := signer.signature(http.MethodGet, testBucket, "1.jpg", url.Values{"expires": {"100\n200"}})
+	if a == b {
+		t.Fatal("不同的 key 和有效期签出来一样")
+	}
+}
+
+func doRequest(t *testing.T, method string, url string, contentType string, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
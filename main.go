@@ -4,13 +4,18 @@ import (
 	"context"
 	"io"
 	"os"
+	"strings"
+	"study-aws-api-go/business/bucket"
 	"study-aws-api-go/business/mys3"
+	"study-aws-api-go/business/object"
 	"study-aws-api-go/business/order"
+	"study-aws-api-go/business/storage"
 	"study-aws-api-go/db"
 	"study-aws-api-go/errorutil"
 	"study-aws-api-go/log"
 	"study-aws-api-go/models"
 	"study-aws-api-go/myconfig"
+	"time"
 
 	// 三方
 
@@ -30,10 +35,14 @@ var (
 	cfg *myconfig.Config // 配置文件
 
 	// aws 相关
-	s3Basic   mys3.BucketBasics // 替代 s3Client
-	s3Client  *s3.Client
-	s3Manager *manager.Uploader
-	ctx       context.Context
+	s3Basic      mys3.BucketBasics // 替代 s3Client
+	s3Client     *s3.Client
+	s3Manager    *manager.Uploader
+	s3Downloader *manager.Downloader
+	ctx          context.Context
+
+	// 存储后端, 按 storage.backend 选 s3/本地目录/内存
+	store storage.Storage
 )
 
 // 初始化, 默认main会自动调用本方法
@@ -44,6 +53,7 @@ var (
 // 4. 自动迁移表结构
 // 5. db插入默认数据
 // 6. 初始化aws s3配置,创建s3客户端
+// 7. 按配置创建存储后端
 func init() {
 	// 1. 读取配置文件， (如果配置文件不填, 自动会有默认值)
 	cfg = myconfig.GetConfig(".", "config", "yaml")
@@ -94,7 +104,21 @@ func init() {
 	log.Info("gin.mode: ", cfg.Gin.Mode)
 	log.Info("region: ", cfg.AWS_S3.Region)
 	log.Info("access_key_id: ", cfg.AWS_S3.AccessKeyId)
-	log.Info("access_key_secret: ", cfg.AWS_S3.AccessKeySecret)
+	log.Info("access_key_secret: ", maskSecret(cfg.AWS_S3.AccessKeySecret)) // 密钥不打到日志里
+	log.Info("credentials.source: ", cfg.AWS_S3.Credentials.Source)
+	log.Info("credentials.profile: ", cfg.AWS_S3.Credentials.Profile)
+	log.Info("credentials.role_arn: ", cfg.AWS_S3.Credentials.RoleArn)
+	log.Info("endpoint: ", cfg.AWS_S3.Endpoint)
+	log.Info("use_path_style: ", cfg.AWS_S3.UsePathStyle)
+	log.Info("disable_ssl: ", cfg.AWS_S3.DisableSSL)
+	log.Info("cors_allowed_origins: ", cfg.AWS_S3.CorsAllowedOrigins)
+	log.Info("[storage] 相关")
+	log.Info("storage.backend: ", cfg.Storage.Backend)
+	log.Info("storage.local_root: ", cfg.Storage.LocalRoot)
+	log.Info("storage.buckets: ", cfg.Storage.Buckets)
+	log.Info("storage.presign_base_url: ", cfg.Storage.PresignBaseURL)
+	log.Info("storage.presign_buckets: ", cfg.Storage.PresignBuckets)
+	log.Info("storage.presign_key_prefix: ", cfg.Storage.PresignKeyPrefix)
 
 	// 初始化数据库连接
 	db.InitDB("mysql", cfg.DB.Name, cfg.DB.User, cfg.DB.Password)
@@ -107,11 +131,51 @@ func init() {
 
 	// 6. 初始化aws s3配置,创建s3客户端 ->  实际使用s3Basic
 	// s3Client := mys3.InitS3Client("ap-northeast-1", "11keyId", "keySecret", "")
-	ctx, s3Client = mys3.InitS3Client(cfg.AWS_S3.Region, cfg.AWS_S3.AccessKeyId, cfg.AWS_S3.AccessKeySecret, "")
-	s3Manager = manager.NewUploader(s3Client) // init
+	credentialOptions := mys3.CredentialOptions{ // 凭证来源, 不配置就是 access_key_id/access_key_secret
+		Source:               cfg.AWS_S3.Credentials.Source,
+		AccessKeyId:          cfg.AWS_S3.AccessKeyId,
+		SecretKey:            cfg.AWS_S3.AccessKeySecret,
+		Profile:              cfg.AWS_S3.Credentials.Profile,
+		RoleArn:              cfg.AWS_S3.Credentials.RoleArn,
+		RoleSessionName:      cfg.AWS_S3.Credentials.RoleSessionName,
+		WebIdentityTokenFile: cfg.AWS_S3.Credentials.WebIdentityTokenFile,
+		ExternalId:           cfg.AWS_S3.Credentials.ExternalId,
+		Duration:             time.Duration(cfg.AWS_S3.Credentials.DurationSeconds) * time.Second,
+		STSEndpoint:          cfg.AWS_S3.Credentials.STSEndpoint,
+	}
+	ctx = context.Background()
+	credentials, err := mys3.NewCredentialsProvider(ctx, cfg.AWS_S3.Region, credentialOptions)
+	if err != nil {
+		log.Fatal("创建s3客户端失败, 检查 aws_s3.credentials 配置, err: ", err)
+	}
+	s3Registry := mys3.NewClientRegistry(cfg.AWS_S3.Region) // 每个区域一个客户端, 操作存储桶时自动用桶所在区域的
+	s3Registry.AccountAdd(mys3.DefaultAccount, credentials,
+		mys3.WithEndpoint(cfg.AWS_S3.Endpoint, cfg.AWS_S3.UsePathStyle, cfg.AWS_S3.DisableSSL)) // 自定义地址, 不配置就是 aws
+	s3Client, _ = s3Registry.Client(mys3.DefaultAccount, cfg.AWS_S3.Region)                       // 默认区域的客户端, 账号刚加过不会报错
+	s3Manager = manager.NewUploader(s3Client)                                                     // init
+	s3Downloader = manager.NewDownloader(s3Client)                                                // init, 分段并发下载
+	cseMasterKey, err := mys3.LoadMasterKey(cfg.AWS_S3.CseMasterKey, cfg.AWS_S3.CseMasterKeyFile) // 客户端加密主密钥, 没配置为空
+	if err != nil {
+		log.Fatal("读取客户端加密主密钥失败, err: ", err)
+	}
 	s3Basic = mys3.BucketBasics{
-		S3Client:  s3Client,
-		S3Manager: s3Manager,
+		S3Client:     s3Client,
+		S3Manager:    s3Manager,
+		S3Downloader: s3Downloader,
+		CSEMasterKey: cseMasterKey,
+		Registry:     s3Registry,
+	}
+
+	// 7. 按配置创建存储后端, 离线开发配 local 就不用连 s3
+	store, err = storage.New(storage.Config{
+		Backend:        cfg.Storage.Backend,
+		LocalRoot:      cfg.Storage.LocalRoot,
+		Buckets:        cfg.Storage.Buckets,
+		PresignBaseURL: cfg.Storage.PresignBaseURL,
+		PresignSecret:  cfg.Storage.PresignSecret,
+	}, s3Basic)
+	if err != nil {
+		log.Fatal("创建存储后端失败, err: ", err)
 	}
 }
 
@@ -124,27 +188,59 @@ func init() {
 func main() {
 	// 3. s3 增删改查、上传、下载
 	// err := s3Basic.BucketDelete(ctx, "mytesttest12234") // 存储桶 - add
+	// _, err := s3Basic.BucketEmptyAndDelete(ctx, "mytesttest12234", mys3.PurgeOptions{DryRun: true}) // 存储桶 - 清空后删除, DryRun 只列出要删的
 	// err := s3Basic.BucketAdd(ctx, "mytesttest12234", cfg.AWS_S3.Region) // 存储桶 - delete
 	// _, err := s3Basic.BucketQueryAll(ctx) // 存储桶 - query
 	// exists, err := s3Basic.BucketExists(ctx, "sexcomic") // 查询桶是否存在
+	// err := s3Basic.BucketVersioningSet(ctx, "sexcomic", true) // 开启版本控制
+	// err := s3Basic.BucketAdd(ctx, "mytesttest12234", cfg.AWS_S3.Region, mys3.BucketAddOptions{Private: true}) // 创建私有存储桶, 禁用ACL + 阻止公共访问
+	// policy, err := s3Basic.BucketPolicyGet(ctx, "sexcomic") // 查存储桶策略
+	// rules, err := mys3.CorsRulesFromOrigins(cfg.AWS_S3.CorsAllowedOrigins); err = s3Basic.BucketCorsPut(ctx, "sexcomic", rules) // 浏览器直传前设置跨域
 
 	// object 操作
 	// err := s3Basic.FileUploadLowApi(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "C://home/manhua/亲家四姊妹/充满各种变态行为的家/1.jpg")     // 上传文件
 	// outKey, err := s3Basic.ObjectUpload(ctx, "sexcomic", "充满各种变态行为的家-2.jpg", "C://home/manhua/亲家四姊妹/充满各种变态行为的家/2.jpg") // 上传文件
+	// result, err := s3Basic.ObjectUploadStream(ctx, "sexcomic", "亲家四姊妹.zip", file, mys3.UploadOptions{PartSize: 64 * 1024 * 1024, Concurrency: 4}) // 流式分段上传大文件, file 可以是任意 io.Reader
+	// result, err := s3Basic.ObjectUploadResumable(ctx, "sexcomic", "亲家四姊妹.zip", "C://home/manhua/亲家四姊妹.zip", mys3.ResumableOptions{}) // 断点续传, 中断后再执行一次就接着传
+	// uploads, err := s3Basic.MultipartUploadsList(ctx, "sexcomic", "")                 // 查未完成的分段上传
+	// aborted, err := s3Basic.MultipartUploadsAbort(ctx, "sexcomic", "", 7*24*time.Hour) // 清理7天前未完成的分段上传
 	// _, err := s3Basic.ObjectDelete(ctx, "sexcomic", "充满各种变态行为的家-2.jpg", "", false) // 上传文件
+	// _, err := s3Basic.ObjectUpload(ctx, "sexcomic", "充满各种变态行为的家-2.jpg", "C://home/manhua/亲家四姊妹/充满各种变态行为的家/2.jpg", mys3.UploadOptions{Metadata: map[string]string{"comic-id": "1024"}, Tags: map[string]string{"comic": "亲家四姊妹", "country": "kr"}}) // 上传时带元数据和标签
+	// err := s3Basic.ObjectTagsPut(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", map[string]string{"category": "家庭"}) // 改标签
+	// meta, err := s3Basic.ObjectMetadataGet(ctx, "sexcomic", "充满各种变态行为的家-1.jpg") // 查大小、类型、存储类型、元数据
+	// _, err := s3Basic.ObjectUpload(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "C://home/manhua/亲家四姊妹/充满各种变态行为的家/1.jpg", mys3.UploadOptions{CacheControl: "public, max-age=31536000", ContentDisposition: mys3.ContentDisposition("inline", "充满各种变态行为的家-1.jpg")}) // Content-Type 自动检测, 中文文件名
+	// _, err := s3Basic.ObjectUpload(ctx, "sexcomic", "订单/买家.json", "C://home/订单/买家.json", mys3.UploadOptions{Encryption: mys3.Encryption{Mode: mys3.EncryptionSSEKMS, KMSKeyId: "alias/order"}}) // SSE-KMS 加密上传
+	// err := s3Basic.BucketEncryptionPut(ctx, "sexcomic", mys3.Encryption{Mode: mys3.EncryptionSSES3}) // 存储桶默认加密
+	// _, err := s3Basic.ObjectUpload(ctx, "sexcomic", "订单/买家.json", "C://home/订单/买家.json", mys3.UploadOptions{ClientEncrypt: true}) // 客户端加密上传, ObjectDownload 自动解密
+	// _, err := s3Basic.ObjectDeletePrefix(ctx, "sexcomic", "亲家四姊妹/", mys3.PurgeOptions{}) // 删目录, 包括所有历史版本
+	// _, err := s3Basic.ObjectCopy(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "sexcomic-bak", "充满各种变态行为的家-1.jpg", mys3.CopyOptions{}) // 复制, 可以跨存储桶
+	// _, err := s3Basic.ObjectRename(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "亲家四姊妹/充满各种变态行为的家-1.jpg") // 改名
+	// versions, err := s3Basic.ObjectVersionsList(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", true) // 查历史版本
+	// _, err := s3Basic.ObjectStorageClassSet(ctx, "sexcomic", "亲家四姊妹.zip", types.StorageClassDeepArchive) // 改存储类型, 老漫画归档
+	// err := s3Basic.ObjectRestore(ctx, "sexcomic", "亲家四姊妹.zip", 3, types.TierBulk)                 // 开始恢复归档对象, 保留3天
+	// status, err := s3Basic.ObjectRestoreWait(ctx, "sexcomic", "亲家四姊妹.zip", 30*time.Minute)       // 等恢复完成, 再 ObjectDownload
+	// _, err := s3Basic.ObjectRestoreVersion(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", versions[1].VersionId) // 误覆盖了, 恢复成上一个版本
 
 	// 批量删 文件
 	// objs := []types.ObjectIdentifier{
 	// 	{Key: aws.String("充满各种变态行为的家-1.jpg")},
 	// 	{Key: aws.String("充满各种变态行为的家-2.jpg")},
 	// }
-	// err := s3Basic.ObjectDeleteBatch(ctx, "sexcomic", objs, false) // 上传文件
+	// result, err := s3Basic.ObjectDeleteBatch(ctx, "sexcomic", objs, mys3.BatchDeleteOptions{}) // 批量删除, 超过1000个自动分批, result 里有每个key的结果
 	// results, err := s3Basic.ObjectQueryAll(ctx, "sexcomic") // 查所有
 	// for _, result := range results {
 	// 	log.Info("查询到 ", *result.Key)
 	// }
+	// page, err := s3Basic.ObjectList(ctx, "sexcomic", mys3.ListOptions{Prefix: "亲家四姊妹/", Delimiter: "/", MaxKeys: 100}) // 分页查, 下一页传 page.NextContinuationToken
+
+	// 同步
+	// _, err := s3Basic.SyncLocalToS3(ctx, "C://home/manhua/亲家四姊妹", "sexcomic", "亲家四姊妹/", mys3.SyncOptions{Include: []string{"*.jpg"}}) // 本地目录 -> s3
+	// _, err := s3Basic.SyncS3ToLocal(ctx, "sexcomic", "亲家四姊妹/", "C://home/test/亲家四姊妹", mys3.SyncOptions{Delete: true})      // s3 -> 本地目录
 
 	// 下载
+	// err := s3Basic.ObjectDownload(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "C://home/test/1.jpg", mys3.DownloadOptions{Verify: mys3.ChecksumModeSHA256}) // 下载校验, 不一致返回 *mys3.IntegrityError
+	// err := s3Basic.ObjectVerify(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "C://home/test/1.jpg", "") // 本地文件和 s3 上的是否一样
+	// err := s3Basic.ObjectDownloadParallel(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "C://home/test/1.jpg", mys3.DownloadOptions{PartSize: 16 * 1024 * 1024, Concurrency: 8}) // 大文件, 分段并发下载
 	err := s3Basic.ObjectDownload(ctx, "sexcomic", "充满各种变态行为的家-1.jpg", "C://home/test/1.jpg") // 上传文件
 
 	errorutil.ErrorPrint(err, " 报错 err= ")
@@ -161,6 +257,30 @@ func main() {
 	r.PUT("/orders", order.OrderUpdate)
 	r.GET("/orders", order.OrdersPageQuery) // 分页查询
 
+	object.InitObject(store, cfg.Storage.PresignBuckets, cfg.Storage.PresignKeyPrefix)
+	r.POST("/objects/presign", object.ObjectPresign) // 预签名url
+	r.GET("/objects", object.ObjectsPageQuery)       // 分页查询, 按前缀/分隔符
+	if handler := storage.Handler(store); handler != nil {
+		r.GET("/storage/:bucket/*key", handler) // 本地/内存后端: 预签名url下载
+		r.PUT("/storage/:bucket/*key", handler) // 本地/内存后端: 预签名url上传
+	}
+
+	bucket.InitBucket(s3Basic, cfg.AWS_S3.CorsAllowedOrigins)
+	r.GET("/buckets/:bucket/lifecycle", bucket.BucketLifecycleQuery)     // 生命周期规则 - 查
+	r.PUT("/buckets/:bucket/lifecycle", bucket.BucketLifecycleUpdate)    // 生命周期规则 - 改
+	r.DELETE("/buckets/:bucket/lifecycle", bucket.BucketLifecycleDelete) // 生命周期规则 - 删
+	r.GET("/buckets/:bucket/cors", bucket.BucketCorsQuery)               // 跨域规则 - 查
+	r.PUT("/buckets/:bucket/cors", bucket.BucketCorsUpdate)              // 跨域规则 - 改, 浏览器直传要先设置
+	r.DELETE("/buckets/:bucket/cors", bucket.BucketCorsDelete)           // 跨域规则 - 删
+
 	r.Run(":8888") // 启动服务
 
 }
+
+// 密钥打码, 只留前4位, 日志里看得出配没配
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", len(secret)-4)
+}
@@ -30,8 +30,36 @@ type Config struct {
 	}
 	AWS_S3 struct {
 		Region          string `mapstructure:"region"`
-		AccessKeyId     string `mapstructure:"access_key_id"`
-		AccessKeySecret string `mapstructure:"access_key_secret"`
+		AccessKeyId     string `mapstructure:"access_key_id"`     // 静态凭证, credentials.source 是 static 时用
+		AccessKeySecret string `mapstructure:"access_key_secret"` // 静态凭证, 不想提交到 github 就换成 profile/env 等其他来源
+		Endpoint        string `mapstructure:"endpoint"`          // 自定义地址, 连本地 MinIO/SeaweedFS/LocalStack 用, 为空用 aws
+		UsePathStyle    bool   `mapstructure:"use_path_style"`    // 路径风格 host/bucket/key, 本地服务一般要开
+		DisableSSL      bool   `mapstructure:"disable_ssl"`       // 用 http 不用 https
+
+		CorsAllowedOrigins []string `mapstructure:"cors_allowed_origins"` // 浏览器跨域来源, 只用来生成存储桶跨域规则 (gin 用 cors.Default() 允许所有来源)
+		CseMasterKey       string   `mapstructure:"cse_master_key"`       // 客户端加密主密钥, base64(32字节), 优先用这个
+		CseMasterKeyFile   string   `mapstructure:"cse_master_key_file"`  // 客户端加密主密钥文件, 不想把密钥写在配置里用这个
+
+		Credentials struct {
+			Source               string `mapstructure:"source"`                  // 凭证来源: static(默认) / profile / env / web_identity / assume_role
+			Profile              string `mapstructure:"profile"`                 // profile: ~/.aws 里的 profile 名字; assume_role: 源凭证用这个 profile
+			RoleArn              string `mapstructure:"role_arn"`                // web_identity/assume_role: 角色 arn
+			RoleSessionName      string `mapstructure:"role_session_name"`       // web_identity/assume_role: 会话名
+			WebIdentityTokenFile string `mapstructure:"web_identity_token_file"` // web_identity: token 文件
+			ExternalId           string `mapstructure:"external_id"`             // assume_role: 外部id
+			DurationSeconds      int    `mapstructure:"duration_seconds"`        // web_identity/assume_role: 临时凭证有效期(秒), 不填1小时
+			STSEndpoint          string `mapstructure:"sts_endpoint"`            // 自定义 STS 地址, LocalStack/MinIO 一般和 endpoint 一样, 不填用 aws
+		}
+	}
+	Storage struct {
+		Backend        string   `mapstructure:"backend"`          // 存储后端: s3(默认) / local(本地目录, 离线开发) / memory(内存, 测试)
+		LocalRoot      string   `mapstructure:"local_root"`       // local: 根目录, 一个存储桶一个子目录
+		Buckets        []string `mapstructure:"buckets"`          // local/memory: 启动时建好的存储桶
+		PresignBaseURL string   `mapstructure:"presign_base_url"` // local/memory: 预签名url前缀, 指向 gin 的 /storage 路由
+		PresignSecret  string   `mapstructure:"presign_secret"`   // local/memory: 预签名密钥, 不填每次启动随机生成
+
+		PresignBuckets   []string `mapstructure:"presign_buckets"`    // /objects/presign 只给这些存储桶签名, 为空全部拒绝
+		PresignKeyPrefix string   `mapstructure:"presign_key_prefix"` // /objects/presign 只给这个前缀下的 key 签名, 如 "uploads/", 为空不限
 	}
 }
 
@@ -93,6 +121,11 @@ func GetConfig(path, name, ext string) *Config {
 		// 设置默认值 [gin] 相关
 		viper.SetDefault("gin.mode", "release") // 设置默认release模式
 
+		// 设置默认值 [storage] 相关
+		viper.SetDefault("storage.backend", "s3")                                     // 默认用 s3
+		viper.SetDefault("storage.local_root", "data")                                // 本地存储根目录
+		viper.SetDefault("storage.presign_base_url", "http://localhost:8888/storage") // 和 main.go 里 gin 的端口一致
+
 		// 读取配置文件
 		if err := viper.ReadInConfig(); err != nil {
 			log.Fatalln("读取配置文件失败,err: ", err)